@@ -3,17 +3,36 @@
 package lru
 
 import (
+	"sync/atomic"
 	"unsafe"
 )
 
 // BytesCache implements Bytes Cache with least recent used eviction policy.
 type BytesCache struct {
+	rejections uint64 // keep first for 64-bit atomic alignment
+
 	shards []bytesshard
 	mask   uint32
+
+	// the values longer than maxValueSize are rejected, or truncated if truncateValue is set, see WithMaxValueSize
+	maxValueSize  int
+	truncateValue bool
+
+	// the keys and values are copied on Set, and the values are copied on Get as well, see WithCopyValues
+	copyOnSet bool
+	copyOnGet bool
+
+	// the policy of an entry larger than the bytes budget of its shard, see WithBytesOversizePolicy
+	oversize OversizePolicy
 }
 
 // NewBytesCache creates bytes cache with size capacity.
-func NewBytesCache(shards uint8, shardsize uint32) *BytesCache {
+// It panics with *SizeError if shardsize exceeds MaxShardSize.
+func NewBytesCache(shards uint8, shardsize uint32, options ...BytesOption) *BytesCache {
+	if shardsize > MaxShardSize {
+		panic(&SizeError{Size: int(shardsize), Shards: 1})
+	}
+
 	c := new(BytesCache)
 
 	c.mask = nextPowOf2(uint32(shards)) - 1
@@ -23,33 +42,189 @@ func NewBytesCache(shards uint8, shardsize uint32) *BytesCache {
 		c.shards[i].Init(shardsize)
 	}
 
+	for _, o := range options {
+		o.applyToBytesCache(c)
+	}
+
+	return c
+}
+
+// NewBytesCacheWithBudget creates bytes cache bounded by maxBytes, the total bytes of keys and values, besides
+// the shards and shardsize capacity which sizes the pre-allocated lists. The maxBytes is split evenly among shards,
+// and the least recent entries of a shard are evicted until its total bytes fit. An entry larger than the budget
+// of its shard is handled by WithBytesOversizePolicy, which rejects it by default.
+func NewBytesCacheWithBudget(shards uint8, shardsize uint32, maxBytes int, options ...BytesOption) *BytesCache {
+	c := NewBytesCache(shards, shardsize, options...)
+
+	budget := (int64(maxBytes) + int64(c.mask)) / int64(c.mask+1)
+	if budget == 0 {
+		return c
+	}
+	for i := uint32(0); i <= c.mask; i++ {
+		c.shards[i].budget = &bytesbudget{max: budget, evictAll: c.oversize == OversizeEvictAll}
+	}
+
 	return c
 }
 
+// BytesOption is an interface for BytesCache configuration.
+type BytesOption interface {
+	applyToBytesCache(*BytesCache)
+}
+
+// WithCopyValues specifies that BytesCache copies keys and values into buffers owned by cache on Set, so the caller
+// could reuse its buffers after Set. If onGet is true, the values returned by Get and Peek are copies as well,
+// so the caller could modify them.
+func WithCopyValues(onGet bool) BytesOption {
+	return &copyValuesOption{onGet: onGet}
+}
+
+type copyValuesOption struct {
+	onGet bool
+}
+
+func (o *copyValuesOption) applyToBytesCache(c *BytesCache) {
+	c.copyOnSet = true
+	c.copyOnGet = o.onGet
+}
+
+// WithMaxValueSize specifies the max length of values, a larger value is rejected by Set and SetIfAbsent,
+// and the previous value of the key is deleted by Set. If truncate is true, the value is truncated to n bytes
+// and stored instead. Both are counted in Stats.Rejections.
+func WithMaxValueSize(n int, truncate bool) BytesOption {
+	return &maxValueSizeOption{n: n, truncate: truncate}
+}
+
+type maxValueSizeOption struct {
+	n        int
+	truncate bool
+}
+
+func (o *maxValueSizeOption) applyToBytesCache(c *BytesCache) {
+	if o.n > 0 {
+		c.maxValueSize = o.n
+		c.truncateValue = o.truncate
+	}
+}
+
+// WithBytesOversizePolicy specifies the policy of an entry larger than the bytes budget of its shard by NewBytesCacheWithBudget.
+func WithBytesOversizePolicy(policy OversizePolicy) BytesOption {
+	return &bytesOversizePolicyOption{policy: policy}
+}
+
+type bytesOversizePolicyOption struct {
+	policy OversizePolicy
+}
+
+func (o *bytesOversizePolicyOption) applyToBytesCache(c *BytesCache) {
+	c.oversize = o.policy
+}
+
+// oversized reports whether value exceeds the max value size, and truncates it if configured.
+func (c *BytesCache) oversized(value *[]byte) bool {
+	if c.maxValueSize == 0 || len(*value) <= c.maxValueSize {
+		return false
+	}
+	atomic.AddUint64(&c.rejections, 1)
+	if c.truncateValue {
+		*value = (*value)[:c.maxValueSize:c.maxValueSize]
+		return false
+	}
+	return true
+}
+
+// bytesOwned copies key and value into one buffer, and returns the copies.
+func bytesOwned(key, value []byte) ([]byte, []byte) {
+	buf := make([]byte, len(key)+len(value))
+	n := copy(buf, key)
+	copy(buf[n:], value)
+	return buf[:n:n], buf[n:]
+}
+
 // Get returns value for key.
 func (c *BytesCache) Get(key []byte) (value []byte, ok bool) {
 	hash := uint32(wyhashHashbytes(key, 0))
-	// return c.shards[hash&c.mask].Get(hash, key)
-	return (*bytesshard)(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Get(hash, key)
+	// value, ok = c.shards[hash&c.mask].Get(hash, key)
+	value, ok = (*bytesshard)(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Get(hash, key)
+	if ok && c.copyOnGet {
+		value = append(make([]byte, 0, len(value)), value...)
+	}
+	return
+}
+
+// GetAppend appends the value for key to dst under the shard lock and returns the extended buffer, it never allocates
+// if dst has enough capacity. The appended value is a copy, so it is safe to modify with or without WithCopyValues.
+func (c *BytesCache) GetAppend(dst []byte, key []byte) ([]byte, bool) {
+	hash := uint32(wyhashHashbytes(key, 0))
+	return c.shards[hash&c.mask].GetAppend(dst, hash, key)
+}
+
+// ShardOf returns the index of the shard which key lands in, it matches the index of ShardStats.
+// The shard of a key is deterministic across caches with the same shards count.
+func (c *BytesCache) ShardOf(key []byte) int {
+	return int(uint32(wyhashHashbytes(key, 0)) & c.mask)
 }
 
 // Peek returns value, but does not modify its recency.
 func (c *BytesCache) Peek(key []byte) (value []byte, ok bool) {
 	hash := uint32(wyhashHashbytes(key, 0))
-	// return c.shards[hash&c.mask].Peek(hash, key)
-	return (*bytesshard)(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Peek(hash, key)
+	// value, ok = c.shards[hash&c.mask].Peek(hash, key)
+	value, ok = (*bytesshard)(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Peek(hash, key)
+	if ok && c.copyOnGet {
+		value = append(make([]byte, 0, len(value)), value...)
+	}
+	return
+}
+
+// Contains reports whether key is in the cache, but does not modify its recency.
+func (c *BytesCache) Contains(key []byte) bool {
+	hash := uint32(wyhashHashbytes(key, 0))
+	return c.shards[hash&c.mask].Contains(hash, key)
 }
 
 // Set inserts key value pair and returns previous value.
 func (c *BytesCache) Set(key []byte, value []byte) (prev []byte, replaced bool) {
 	hash := uint32(wyhashHashbytes(key, 0))
+	if c.oversized(&value) {
+		// drop the stale value of key, as the budget rejection does.
+		c.shards[hash&c.mask].Delete(hash, key)
+		return
+	}
+	if c.copyOnSet {
+		key, value = bytesOwned(key, value)
+	}
 	// return c.shards[hash&c.mask].Set(hash, key, value)
 	return (*bytesshard)(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Set(hash, key, value)
 }
 
+// TrySet inserts key value pair and returns previous value as Set, and returns *OversizeError if the value is rejected by
+// WithMaxValueSize, or the entry is larger than the bytes budget of its shard and rejected by OversizeReject,
+// in which case the previous value is deleted.
+func (c *BytesCache) TrySet(key []byte, value []byte) (prev []byte, replaced bool, err error) {
+	hash := uint32(wyhashHashbytes(key, 0))
+	n := len(value)
+	if c.maxValueSize != 0 && n > c.maxValueSize && c.truncateValue {
+		n = c.maxValueSize
+	}
+	switch s := &c.shards[hash&c.mask]; {
+	case c.maxValueSize != 0 && n > c.maxValueSize:
+		err = &OversizeError{Size: int64(n), Budget: int64(c.maxValueSize)}
+	case s.budget != nil && !s.budget.evictAll && int64(len(key)+n) > s.budget.max:
+		err = &OversizeError{Size: int64(len(key) + n), Budget: s.budget.max}
+	}
+	prev, replaced = c.Set(key, value)
+	return
+}
+
 // SetIfAbsent inserts key value pair and returns previous value, if key is absent in the cache.
 func (c *BytesCache) SetIfAbsent(key []byte, value []byte) (prev []byte, replaced bool) {
 	hash := uint32(wyhashHashbytes(key, 0))
+	if c.oversized(&value) {
+		return
+	}
+	if c.copyOnSet {
+		key, value = bytesOwned(key, value)
+	}
 	// return c.shards[hash&c.mask].SetIfAbsent(hash, key, value)
 	return (*bytesshard)(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).SetIfAbsent(hash, key, value)
 }
@@ -61,6 +236,21 @@ func (c *BytesCache) Delete(key []byte) (prev []byte) {
 	return (*bytesshard)(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Delete(hash, key)
 }
 
+// DeletePrefix deletes all keys with prefix by scanning shard tables once, and returns the number of deleted keys.
+func (c *BytesCache) DeletePrefix(prefix []byte) (deleted int) {
+	for i := uint32(0); i <= c.mask; i++ {
+		deleted += int(c.shards[i].DeletePrefix(prefix))
+	}
+	return
+}
+
+// Clear deletes all entries in place, the pre-allocated lists and tables are reused.
+func (c *BytesCache) Clear() {
+	for i := uint32(0); i <= c.mask; i++ {
+		c.shards[i].Clear()
+	}
+}
+
 // Len returns number of cached nodes.
 func (c *BytesCache) Len() int {
 	var n uint32
@@ -78,23 +268,116 @@ func (c *BytesCache) AppendKeys(keys [][]byte) [][]byte {
 	return keys
 }
 
+// EvictToWatermark evicts the least recent used entries of shards proportionally,
+// until the number of cached nodes is not greater than n, and returns the number of evicted nodes.
+// It is intended to be called by memory pressure handlers, e.g. polling runtime/metrics against debug.SetMemoryLimit.
+func (c *BytesCache) EvictToWatermark(n int) (evicted int) {
+	if n < 0 {
+		n = 0
+	}
+	total := c.Len()
+	if total <= n {
+		return
+	}
+	for i := uint32(0); i <= c.mask; i++ {
+		s := &c.shards[i]
+		target := uint64(s.Len()) * uint64(n) / uint64(total)
+		evicted += int(s.EvictTo(uint32(target)))
+	}
+	return
+}
+
+// ScanKeys iterates keys incrementally in the way of redis SCAN, it returns at most count keys
+// starting from cursor and the next cursor, iteration is complete when the next cursor is zero.
+// Keys added or deleted during the iteration may be missed or returned twice.
+func (c *BytesCache) ScanKeys(cursor uint64, count int) (keys [][]byte, next uint64) {
+	if count < 1 {
+		count = 1
+	}
+	pos := uint32(cursor)
+	for i := uint32(cursor >> 32); i <= c.mask && len(keys) < count; i++ {
+		keys, pos = c.shards[i].ScanKeys(keys, pos, count-len(keys))
+		if pos != 0 {
+			return keys, uint64(i)<<32 | uint64(pos)
+		}
+		if i < c.mask {
+			next = uint64(i+1) << 32
+		} else {
+			next = 0
+		}
+	}
+	return
+}
+
+// SizeHistograms returns the histograms of key and value sizes of the entries in cache.
+func (c *BytesCache) SizeHistograms() (keys, values SizeHistogram) {
+	for i := uint32(0); i <= c.mask; i++ {
+		c.shards[i].SizeHistograms(&keys, &values)
+	}
+	return
+}
+
 // Stats returns cache stats.
 func (c *BytesCache) Stats() (stats Stats) {
+	c.CollectStats(&stats)
+	return
+}
+
+// CollectStats overwrites stats with cache stats, it reads the shard counters atomically without locking shards,
+// so it is cheap for frequent scraping, and the counters of different shards may be observed at slightly different times.
+func (c *BytesCache) CollectStats(stats *Stats) {
+	*stats = Stats{}
+	for i := uint32(0); i <= c.mask; i++ {
+		s := &c.shards[i]
+		stats.EntriesCount += uint64(atomic.LoadUint32(&s.tableLength))
+		stats.GetCalls += atomic.LoadUint64(&s.statsGetCalls)
+		stats.SetCalls += atomic.LoadUint64(&s.statsSetCalls)
+		stats.Misses += atomic.LoadUint64(&s.statsMisses)
+		stats.Evictions += atomic.LoadUint64(&s.statsEvictions)
+		stats.BytesUsed += uint64(atomic.LoadInt64(&s.bytesUsed))
+	}
+	stats.Rejections = atomic.LoadUint64(&c.rejections)
+}
+
+// ShardStats returns the stats of each shard, for finding the imbalance of shards, Rejections is not per shard.
+func (c *BytesCache) ShardStats() []Stats {
+	stats := make([]Stats, c.mask+1)
+	for i := range stats {
+		s := &c.shards[i]
+		stats[i] = Stats{
+			GetCalls:     atomic.LoadUint64(&s.statsGetCalls),
+			SetCalls:     atomic.LoadUint64(&s.statsSetCalls),
+			Misses:       atomic.LoadUint64(&s.statsMisses),
+			EntriesCount: uint64(atomic.LoadUint32(&s.tableLength)),
+			Evictions:    atomic.LoadUint64(&s.statsEvictions),
+			BytesUsed:    uint64(atomic.LoadInt64(&s.bytesUsed)),
+		}
+	}
+	return stats
+}
+
+// ResetStats swaps the stats counters to zero and returns their values before reset, EntriesCount and BytesUsed are kept
+// as current values.
+// Each counter is swapped atomically, so the counts between two calls are never lost or counted twice, and the returned
+// stats are the counts of the interval since the last reset.
+func (c *BytesCache) ResetStats() (stats Stats) {
 	for i := uint32(0); i <= c.mask; i++ {
 		s := &c.shards[i]
-		s.mu.Lock()
-		stats.EntriesCount += uint64(s.tableLength)
-		stats.GetCalls += s.statsGetCalls
-		stats.SetCalls += s.statsSetCalls
-		stats.Misses += s.statsMisses
-		s.mu.Unlock()
+		stats.EntriesCount += uint64(atomic.LoadUint32(&s.tableLength))
+		stats.GetCalls += atomic.SwapUint64(&s.statsGetCalls, 0)
+		stats.SetCalls += atomic.SwapUint64(&s.statsSetCalls, 0)
+		stats.Misses += atomic.SwapUint64(&s.statsMisses, 0)
+		stats.Evictions += atomic.SwapUint64(&s.statsEvictions, 0)
+		stats.BytesUsed += uint64(atomic.LoadInt64(&s.bytesUsed))
 	}
+	stats.Rejections = atomic.SwapUint64(&c.rejections, 0)
 	return
 }
 
 func wyhashHashbytes(data []byte, seed uint64) uint64 {
 	if len(data) == 0 {
-		return seed
+		// mix the seed for empty keys, so that they do not collide with the keys hashed to the seed.
+		return wyhash__wymum(seed^wyhash__wyp0, wyhash__wyp1)
 	}
 	return wyhash_hash(*(*string)(unsafe.Pointer(&data)), seed)
 }
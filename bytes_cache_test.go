@@ -3,6 +3,7 @@ package lru
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 	"testing"
 )
 
@@ -213,6 +214,168 @@ func TestBytesCacheStats(t *testing.T) {
 	}
 }
 
+func TestBytesCacheEvictToWatermark(t *testing.T) {
+	cache := NewBytesCache(1, 256)
+
+	for i := 0; i < 256; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+	for i := 0; i < 10; i++ {
+		cache.Get([]byte(fmt.Sprint(i)))
+	}
+
+	if got, want := cache.EvictToWatermark(100), 156; got != want {
+		t.Fatalf("evicted count %v should be %v", got, want)
+	}
+
+	if got, want := cache.Len(), 100; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, ok := cache.Get([]byte(fmt.Sprint(i))); !ok {
+			t.Fatalf("key %v should not be evicted", i)
+		}
+	}
+}
+
+func TestBytesCacheScanKeys(t *testing.T) {
+	cache := NewBytesCache(4, 256)
+
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+
+	seen := make(map[string]bool)
+	var keys [][]byte
+	var cursor uint64
+	for {
+		keys, cursor = cache.ScanKeys(cursor, 7)
+		for _, k := range keys {
+			seen[string(k)] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if got, want := len(seen), cache.Len(); got != want {
+		t.Fatalf("scanned keys length %v should be %v", got, want)
+	}
+}
+
+func TestBytesCacheSetAfterDelete(t *testing.T) {
+	cache := NewBytesCache(1, 8)
+
+	cache.Set([]byte("a"), []byte("1"))
+	cache.Set([]byte("b"), []byte("2"))
+	cache.Delete([]byte("a"))
+	cache.Delete([]byte("b"))
+	cache.Set([]byte("a"), []byte("1"))
+	cache.Set([]byte("c"), []byte("3"))
+
+	if v, ok := cache.Get([]byte("a")); !ok || string(v) != "1" {
+		t.Fatalf("a should be set to 1: %s", v)
+	}
+}
+
+func TestBytesCacheDeletePrefix(t *testing.T) {
+	cache := NewBytesCache(4, 256)
+
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("/a/%d", i)), []byte("a"))
+		cache.Set([]byte(fmt.Sprintf("/b/%d", i)), []byte("b"))
+	}
+
+	if got, want := cache.DeletePrefix([]byte("/a/")), 100; got != want {
+		t.Fatalf("deleted keys %v should be %v", got, want)
+	}
+
+	if got, want := cache.Len(), 100; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, ok := cache.Get([]byte(fmt.Sprintf("/a/%d", i))); ok {
+			t.Fatalf("/a/%d should be deleted", i)
+		}
+		if v, ok := cache.Get([]byte(fmt.Sprintf("/b/%d", i))); !ok || string(v) != "b" {
+			t.Fatalf("/b/%d should be set to b: %s", i, v)
+		}
+	}
+}
+
+func TestBytesCacheClear(t *testing.T) {
+	cache := NewBytesCache(4, 32)
+
+	for i := 0; i < 256; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+	cache.Clear()
+
+	if got, want := cache.Len(), 0; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+
+	cache.Set([]byte("a"), []byte("1"))
+	if v, ok := cache.Get([]byte("a")); !ok || string(v) != "1" {
+		t.Fatalf("a should be set after clear: %s %v", v, ok)
+	}
+}
+
+func TestBytesCacheContains(t *testing.T) {
+	cache := NewBytesCache(1, 128)
+
+	cache.Set([]byte("a"), []byte("1"))
+
+	if !cache.Contains([]byte("a")) {
+		t.Fatalf("a should be contained")
+	}
+	if cache.Contains([]byte("b")) {
+		t.Fatalf("b should not be contained")
+	}
+}
+
+func TestBytesCacheEmptyKey(t *testing.T) {
+	cache := NewBytesCache(1, 4)
+
+	if _, replaced := cache.Set(nil, []byte("nil")); replaced {
+		t.Fatalf("empty key should not be replaced")
+	}
+	if v, ok := cache.Get([]byte{}); !ok || string(v) != "nil" {
+		t.Fatalf("empty key should be found: %s %v", v, ok)
+	}
+	if prev, replaced := cache.Set([]byte{}, []byte("empty")); !replaced || string(prev) != "nil" {
+		t.Fatalf("empty key should be replaced: %s %v", prev, replaced)
+	}
+
+	for i := 0; i < 3; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+	if got, want := cache.Len(), 4; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+	if v, ok := cache.Get(nil); !ok || string(v) != "empty" {
+		t.Fatalf("empty key should be found: %s %v", v, ok)
+	}
+
+	cache.Set([]byte("3"), []byte("3"))
+	cache.Set([]byte("4"), []byte("4"))
+	if v, ok := cache.Get(nil); !ok || string(v) != "empty" {
+		t.Fatalf("recent empty key should not be evicted: %s %v", v, ok)
+	}
+
+	if prev := cache.Delete(nil); string(prev) != "empty" {
+		t.Fatalf("empty key should be deleted: %s", prev)
+	}
+	if v, ok := cache.Get(nil); ok {
+		t.Fatalf("empty key should not be found: %s", v)
+	}
+	if got, want := cache.Len(), 3; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+}
+
 func BenchmarkBytesCacheRand(b *testing.B) {
 	cache := NewBytesCache(1, 8192)
 
@@ -267,3 +430,230 @@ func BenchmarkBytesCacheFreq(b *testing.B) {
 	}
 	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
 }
+
+func TestBytesCacheWithBudget(t *testing.T) {
+	cache := NewBytesCacheWithBudget(1, 128, 20)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		cache.Set([]byte(key), []byte(key+key+key+key))
+	}
+	if cache.Contains([]byte("a")) {
+		t.Fatalf("a should be evicted")
+	}
+
+	cache.Get([]byte("b"))
+	cache.Set([]byte("f"), []byte("ffffffff"))
+	keys := cache.AppendKeys(nil)
+	sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+	if got, want := fmt.Sprintf("%s", keys), "[b e f]"; got != want {
+		t.Fatalf("cache keys %v should be %v", got, want)
+	}
+	if got, want := cache.shards[0].bytesUsed, int64(19); got != want {
+		t.Fatalf("bytes used %v should be %v", got, want)
+	}
+
+	// the entry larger than the budget is rejected, and the previous value is deleted.
+	cache.Set([]byte("b"), make([]byte, 20))
+	if cache.Contains([]byte("b")) {
+		t.Fatalf("large entry should be rejected")
+	}
+	if got, want := cache.shards[0].bytesUsed, int64(14); got != want {
+		t.Fatalf("bytes used %v should be %v after rejecting", got, want)
+	}
+
+	cache.Delete([]byte("e"))
+	cache.DeletePrefix([]byte("f"))
+	if got, want := cache.shards[0].bytesUsed, int64(0); got != want {
+		t.Fatalf("bytes used %v should be %v after deleting", got, want)
+	}
+}
+
+func TestBytesCacheOversizePolicy(t *testing.T) {
+	cache := NewBytesCacheWithBudget(1, 128, 10, WithMaxValueSize(8, false))
+	cache.Set([]byte("a"), []byte("aa"))
+
+	_, _, err := cache.TrySet([]byte("b"), []byte("bbbbbbbbb"))
+	if e, ok := err.(*OversizeError); !ok || e.Size != 9 || e.Budget != 8 {
+		t.Fatalf("long b should be rejected by max value size: %v", err)
+	}
+	_, _, err = cache.TrySet([]byte("bbbb"), []byte("bbbbbbb"))
+	if e, ok := err.(*OversizeError); !ok || e.Size != 11 || e.Budget != 10 {
+		t.Fatalf("large bbbb should be rejected by budget: %v", err)
+	}
+	if _, _, err := cache.TrySet([]byte("c"), []byte("cc")); err != nil || !cache.Contains([]byte("a")) {
+		t.Fatalf("c should be set: %v", err)
+	}
+
+	cache = NewBytesCacheWithBudget(1, 128, 10, WithBytesOversizePolicy(OversizeEvictAll))
+	cache.Set([]byte("a"), []byte("aa"))
+	cache.Set([]byte("b"), []byte("bb"))
+	if _, _, err := cache.TrySet([]byte("c"), []byte("cccccccccc")); err != nil {
+		t.Fatalf("large c should be set: %v", err)
+	}
+	if !cache.Contains([]byte("c")) || cache.Len() != 1 {
+		t.Fatalf("large c should evict all other entries: %v", cache.Len())
+	}
+}
+
+func TestBytesCacheSizeHistograms(t *testing.T) {
+	cache := NewBytesCache(4, 16)
+
+	cache.Set([]byte("a"), nil)
+	cache.Set([]byte("bb"), make([]byte, 3))
+	cache.Set([]byte("cc"), make([]byte, 1000))
+	cache.Set([]byte("dddd"), make([]byte, 1))
+	cache.Set([]byte("dddd"), make([]byte, 2))
+	cache.Delete([]byte("cc"))
+
+	keys, values := cache.SizeHistograms()
+	if got, want := fmt.Sprint(keys[:4]), "[0 1 1 1]"; got != want {
+		t.Fatalf("key size histogram %v should be %v", got, want)
+	}
+	if got, want := fmt.Sprint(values[:4]), "[1 0 2 0]"; got != want {
+		t.Fatalf("value size histogram %v should be %v", got, want)
+	}
+	if got, want := values[sizeClass(1000)], uint64(0); got != want {
+		t.Fatalf("value size histogram of 1000 %v should be %v", got, want)
+	}
+
+	// fill the shards to evict entries
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), make([]byte, 100))
+	}
+	keys, values = cache.SizeHistograms()
+	var nkeys, nvalues uint64
+	for i := range keys {
+		nkeys += keys[i]
+		nvalues += values[i]
+	}
+	if nkeys != uint64(cache.Len()) || nvalues != uint64(cache.Len()) {
+		t.Fatalf("histogram counts %v %v should be %v", nkeys, nvalues, cache.Len())
+	}
+	if got, want := sizeClass(1<<31-1), len(SizeHistogram{})-1; got != want {
+		t.Fatalf("size class of huge size %v should be %v", got, want)
+	}
+}
+
+func TestBytesCacheStatsEvictions(t *testing.T) {
+	cache := NewBytesCache(1, 4)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		cache.Set([]byte(key), []byte(key))
+	}
+	if got, want := cache.Stats().Evictions, uint64(1); got != want {
+		t.Fatalf("cache evictions should be %v: %v", want, got)
+	}
+}
+
+func TestBytesCacheCopyValues(t *testing.T) {
+	cache := NewBytesCache(1, 128, WithCopyValues(true))
+
+	key, value := []byte("foo"), []byte("bar")
+	cache.Set(key, value)
+	copy(key, "xxx")
+	copy(value, "xxx")
+
+	v, ok := cache.Get([]byte("foo"))
+	if !ok || string(v) != "bar" {
+		t.Fatalf("foo should be set to bar: %q", v)
+	}
+	copy(v, "xxx")
+	if v, ok := cache.Peek([]byte("foo")); !ok || string(v) != "bar" {
+		t.Fatalf("foo should be set to bar: %q", v)
+	}
+}
+
+func TestBytesCacheGetAppend(t *testing.T) {
+	cache := NewBytesCache(1, 128)
+	cache.Set([]byte("foo"), []byte("bar"))
+
+	buf := make([]byte, 0, 64)
+	buf, ok := cache.GetAppend(append(buf, "value="...), []byte("foo"))
+	if !ok || string(buf) != "value=bar" {
+		t.Fatalf("foo should be appended: %q", buf)
+	}
+	if buf, ok := cache.GetAppend(buf[:0], []byte("baz")); ok || len(buf) != 0 {
+		t.Fatalf("baz should not be appended: %q", buf)
+	}
+
+	if n := testing.AllocsPerRun(100, func() { buf, _ = cache.GetAppend(buf[:0], []byte("foo")) }); n != 0 {
+		t.Fatalf("GetAppend should not allocate: %v", n)
+	}
+	if got, want := cache.Stats().Misses, uint64(1); got != want {
+		t.Fatalf("cache misses %v should be %v", got, want)
+	}
+}
+
+func TestBytesCacheStatsBytesUsed(t *testing.T) {
+	cache := NewBytesCache(2, 2)
+
+	cache.Set([]byte("a"), []byte("12345"))
+	cache.Set([]byte("b"), []byte("123"))
+	if got, want := cache.Stats().BytesUsed, uint64(10); got != want {
+		t.Fatalf("cache bytes used %v should be %v", got, want)
+	}
+
+	cache.Set([]byte("a"), []byte("1"))
+	cache.Delete([]byte("b"))
+	if got, want := cache.Stats().BytesUsed, uint64(2); got != want {
+		t.Fatalf("cache bytes used %v should be %v", got, want)
+	}
+
+	for i := 0; i < 16; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%02d", i)), []byte("value"))
+	}
+	var used uint64
+	for _, s := range cache.ShardStats() {
+		used += s.BytesUsed
+	}
+	if got, want := used, uint64(cache.Len()*10); got != want {
+		t.Fatalf("shard bytes used %v should be %v", got, want)
+	}
+	if got, want := cache.ResetStats().BytesUsed, used; got != want {
+		t.Fatalf("reset bytes used %v should be %v", got, want)
+	}
+	if got, want := cache.Stats().BytesUsed, used; got != want {
+		t.Fatalf("cache bytes used %v should be kept %v", got, want)
+	}
+
+	cache.Clear()
+	if got, want := cache.Stats().BytesUsed, uint64(0); got != want {
+		t.Fatalf("cache bytes used %v should be %v", got, want)
+	}
+}
+
+func TestBytesCacheMaxValueSize(t *testing.T) {
+	cache := NewBytesCache(1, 8, WithMaxValueSize(4, false))
+
+	cache.Set([]byte("a"), []byte("1234"))
+	cache.Set([]byte("b"), []byte("1"))
+	if _, replaced := cache.Set([]byte("b"), []byte("12345")); replaced {
+		t.Fatalf("oversized value should be rejected")
+	}
+	if _, ok := cache.Get([]byte("b")); ok {
+		t.Fatalf("stale value of b should be deleted")
+	}
+	cache.SetIfAbsent([]byte("c"), []byte("12345"))
+	if cache.Contains([]byte("c")) {
+		t.Fatalf("oversized value of c should be rejected")
+	}
+	if v, _ := cache.Get([]byte("a")); string(v) != "1234" {
+		t.Fatalf("value of a should be kept: %q", v)
+	}
+	if got, want := cache.Stats().Rejections, uint64(2); got != want {
+		t.Fatalf("cache rejections %v should be %v", got, want)
+	}
+	if got, want := cache.ResetStats().Rejections, uint64(2); got != want {
+		t.Fatalf("reset rejections %v should be %v", got, want)
+	}
+
+	cache = NewBytesCache(1, 8, WithMaxValueSize(4, true), WithCopyValues(false))
+	value := []byte("123456")
+	cache.Set([]byte("a"), value)
+	if v, _ := cache.Get([]byte("a")); string(v) != "1234" || cap(v) != 4 {
+		t.Fatalf("value of a should be truncated: %q", v)
+	}
+	if got, want := cache.Stats().Rejections, uint64(1); got != want {
+		t.Fatalf("cache rejections %v should be %v", got, want)
+	}
+}
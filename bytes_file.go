@@ -9,6 +9,8 @@ import (
 	"syscall"
 )
 
+// bytesfile maps a file region into memory. It has no caller: this package has no mmap-backed cache, so there is
+// no MmapCache to port, to back with an allocator, to reopen or to compact.
 type bytesfile struct {
 	location string
 	file     *os.File
@@ -0,0 +1,268 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+import (
+	"bytes"
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// PrefixView is a view of BytesCache which prepends the prefix to keys of all operations,
+// it is a cheap way to partition one big BytesCache for tenants.
+type PrefixView struct {
+	cache  *BytesCache
+	prefix []byte
+
+	// the recency list of keys in the view, only for views with quota.
+	quota *prefixkeys
+}
+
+// WithPrefix returns a view of cache scoped by prefix.
+func (c *BytesCache) WithPrefix(prefix []byte) *PrefixView {
+	return &PrefixView{
+		cache:  c,
+		prefix: append([]byte(nil), prefix...),
+	}
+}
+
+// WithPrefixQuota returns a view of cache scoped by prefix, which holds at most quota entries.
+// Setting a new key into a full view evicts the least recent used key of the view rather than other views.
+// The keys of the view removed by the cache itself do not count against the quota.
+func (c *BytesCache) WithPrefixQuota(prefix []byte, quota int) *PrefixView {
+	v := c.WithPrefix(prefix)
+	v.quota = newPrefixKeys(quota)
+	return v
+}
+
+func (v *PrefixView) key(key []byte) []byte {
+	return append(append(make([]byte, 0, len(v.prefix)+len(key)), v.prefix...), key...)
+}
+
+// Get returns value for key.
+func (v *PrefixView) Get(key []byte) (value []byte, ok bool) {
+	key = v.key(key)
+	value, ok = v.cache.Get(key)
+	if ok && v.quota != nil {
+		v.quota.touch(b2s(key))
+	}
+	return
+}
+
+// Peek returns value, but does not modify its recency.
+func (v *PrefixView) Peek(key []byte) (value []byte, ok bool) {
+	return v.cache.Peek(v.key(key))
+}
+
+// Contains reports whether key is in the view, but does not modify its recency.
+func (v *PrefixView) Contains(key []byte) bool {
+	return v.cache.Contains(v.key(key))
+}
+
+// Set inserts key value pair and returns previous value.
+func (v *PrefixView) Set(key []byte, value []byte) (prev []byte, replaced bool) {
+	key = v.key(key)
+	prev, replaced = v.cache.Set(key, value)
+	if v.quota != nil {
+		v.quota.track(v.cache, key)
+	}
+	return
+}
+
+// SetIfAbsent inserts key value pair and returns previous value, if key is absent in the cache.
+func (v *PrefixView) SetIfAbsent(key []byte, value []byte) (prev []byte, replaced bool) {
+	key = v.key(key)
+	prev, replaced = v.cache.SetIfAbsent(key, value)
+	if v.quota != nil {
+		v.quota.track(v.cache, key)
+	}
+	return
+}
+
+// Delete method deletes value associated with key and returns deleted value (or empty value if key was not in cache).
+func (v *PrefixView) Delete(key []byte) (prev []byte) {
+	key = v.key(key)
+	if v.quota != nil {
+		v.quota.remove(b2s(key))
+	}
+	return v.cache.Delete(key)
+}
+
+// DeletePrefix deletes all keys with prefix in the view, and returns the number of deleted keys.
+func (v *PrefixView) DeletePrefix(prefix []byte) int {
+	prefix = v.key(prefix)
+	if v.quota != nil {
+		v.quota.removePrefix(b2s(prefix))
+	}
+	return v.cache.DeletePrefix(prefix)
+}
+
+// AppendKeys appends all keys in the view to keys with the prefix stripped, and return the keys.
+func (v *PrefixView) AppendKeys(keys [][]byte) [][]byte {
+	n := len(keys)
+	keys = v.cache.AppendKeys(keys)
+	for _, key := range keys[n:] {
+		if bytes.HasPrefix(key, v.prefix) {
+			keys[n] = key[len(v.prefix):]
+			n++
+		}
+	}
+	return keys[:n]
+}
+
+// PrefixGuard limits the number of entries per key prefix of BytesCache, it mitigates cache pollution
+// from user controlled keys, e.g. an attacker floods unique URLs of a host.
+// A new key of the prefix which already holds max entries replaces the least recently set key of that prefix.
+type PrefixGuard struct {
+	cache    *BytesCache
+	prefix   func(key []byte) []byte
+	max      int
+	prefixes *LRUCache[string, *prefixkeys]
+}
+
+// WithPrefixGuard returns a guard of cache, which allows at most max entries per prefix extracted by prefix function.
+// The guard tracks the keys of at most maxPrefixes recent used prefixes, and the keys removed by the cache itself
+// do not count against max.
+func (c *BytesCache) WithPrefixGuard(prefix func(key []byte) []byte, max int, maxPrefixes int) *PrefixGuard {
+	if max < 1 {
+		max = 1
+	}
+	return &PrefixGuard{
+		cache:    c,
+		prefix:   prefix,
+		max:      max,
+		prefixes: NewLRUCache[string, *prefixkeys](maxPrefixes),
+	}
+}
+
+// Get returns value for key.
+func (g *PrefixGuard) Get(key []byte) (value []byte, ok bool) {
+	return g.cache.Get(key)
+}
+
+// Peek returns value, but does not modify its recency.
+func (g *PrefixGuard) Peek(key []byte) (value []byte, ok bool) {
+	return g.cache.Peek(key)
+}
+
+// Set inserts key value pair and returns previous value, the key counts against the limit of its prefix.
+func (g *PrefixGuard) Set(key []byte, value []byte) (prev []byte, replaced bool) {
+	prev, replaced = g.cache.Set(key, value)
+
+	prefix := string(g.prefix(key))
+	mu := g.prefixes.KeyLock(prefix)
+	mu.Lock()
+	p, ok := g.prefixes.Get(prefix)
+	if !ok {
+		p = newPrefixKeys(g.max)
+		g.prefixes.Set(prefix, p)
+	}
+	mu.Unlock()
+
+	p.track(g.cache, key)
+	return
+}
+
+// Delete method deletes value associated with key and returns deleted value (or empty value if key was not in cache).
+func (g *PrefixGuard) Delete(key []byte) (prev []byte) {
+	if p, ok := g.prefixes.Peek(string(g.prefix(key))); ok {
+		p.remove(b2s(key))
+	}
+	return g.cache.Delete(key)
+}
+
+// prefixkeys is the recency list of the keys of a prefix, which bounds the entries of a PrefixView quota and
+// a PrefixGuard prefix. The keys are deduplicated, and the keys removed by the cache itself are pruned lazily.
+type prefixkeys struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // the keys, the front is the most recent
+	keys  map[string]*list.Element
+	adds  int // the adds since the last prune of the whole list
+}
+
+func newPrefixKeys(max int) *prefixkeys {
+	return &prefixkeys{
+		max:   max,
+		order: list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+}
+
+// track adds key which is just set to cache as the most recent key, and evicts the least recent keys from cache
+// if the keys exceed max. A key rejected by the cache, e.g. an oversized value, is removed instead.
+func (p *prefixkeys) track(cache *BytesCache, key []byte) {
+	if !cache.Contains(key) {
+		p.remove(b2s(key))
+		return
+	}
+
+	var victims []string
+
+	p.mu.Lock()
+	if e, ok := p.keys[b2s(key)]; ok {
+		p.order.MoveToFront(e)
+		p.mu.Unlock()
+		return
+	}
+	k := string(key)
+	p.keys[k] = p.order.PushFront(k)
+	if p.order.Len() > p.max {
+		// prune the keys removed by the cache once per max adds, and always from the back,
+		// so that they do not count against max and the live keys are evicted only if needed.
+		p.adds++
+		for e := p.order.Back(); e != nil && p.order.Len() > p.max; {
+			prev := e.Prev()
+			if k := e.Value.(string); !cache.Contains([]byte(k)) {
+				p.order.Remove(e)
+				delete(p.keys, k)
+			} else if p.adds < p.max {
+				break
+			}
+			e = prev
+		}
+		if p.adds >= p.max {
+			p.adds = 0
+		}
+		for p.order.Len() > p.max {
+			k := p.order.Remove(p.order.Back()).(string)
+			delete(p.keys, k)
+			victims = append(victims, k)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, k := range victims {
+		cache.Delete([]byte(k))
+	}
+}
+
+// touch moves key to the front if it is tracked.
+func (p *prefixkeys) touch(key string) {
+	p.mu.Lock()
+	if e, ok := p.keys[key]; ok {
+		p.order.MoveToFront(e)
+	}
+	p.mu.Unlock()
+}
+
+func (p *prefixkeys) remove(key string) {
+	p.mu.Lock()
+	if e, ok := p.keys[key]; ok {
+		p.order.Remove(e)
+		delete(p.keys, key)
+	}
+	p.mu.Unlock()
+}
+
+func (p *prefixkeys) removePrefix(prefix string) {
+	p.mu.Lock()
+	for key, e := range p.keys {
+		if strings.HasPrefix(key, prefix) {
+			p.order.Remove(e)
+			delete(p.keys, key)
+		}
+	}
+	p.mu.Unlock()
+}
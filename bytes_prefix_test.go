@@ -0,0 +1,203 @@
+package lru
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestPrefixViewGetSet(t *testing.T) {
+	cache := NewBytesCache(1, 128)
+	foo := cache.WithPrefix([]byte("foo:"))
+	bar := cache.WithPrefix([]byte("bar:"))
+
+	foo.Set([]byte("a"), []byte("1"))
+	bar.Set([]byte("a"), []byte("2"))
+
+	if v, ok := foo.Get([]byte("a")); !ok || string(v) != "1" {
+		t.Fatalf("foo:a should be set to 1: %s", v)
+	}
+	if v, ok := bar.Peek([]byte("a")); !ok || string(v) != "2" {
+		t.Fatalf("bar:a should be set to 2: %s", v)
+	}
+	if v, ok := cache.Get([]byte("foo:a")); !ok || string(v) != "1" {
+		t.Fatalf("foo:a should be set to 1: %s", v)
+	}
+
+	if _, replaced := foo.SetIfAbsent([]byte("a"), []byte("3")); replaced {
+		t.Fatal("should not have replaced")
+	}
+
+	if v := bar.Delete([]byte("a")); string(v) != "2" {
+		t.Fatalf("bar:a should be deleted: %s", v)
+	}
+
+	if v, ok := bar.Get([]byte("a")); ok {
+		t.Fatalf("bar:a should be deleted: %s", v)
+	}
+}
+
+func TestPrefixViewDeletePrefix(t *testing.T) {
+	cache := NewBytesCache(4, 128)
+	foo := cache.WithPrefix([]byte("foo:"))
+	bar := cache.WithPrefix([]byte("bar:"))
+
+	for i := 0; i < 100; i++ {
+		foo.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+		bar.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+
+	if got, want := len(foo.AppendKeys(nil)), 100; got != want {
+		t.Fatalf("foo keys length %v should be %v", got, want)
+	}
+
+	if got, want := foo.DeletePrefix([]byte("1")), 11; got != want {
+		t.Fatalf("foo deleted keys %v should be %v", got, want)
+	}
+
+	if got, want := foo.DeletePrefix(nil), 89; got != want {
+		t.Fatalf("foo deleted keys %v should be %v", got, want)
+	}
+
+	if got, want := cache.Len(), 100; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+
+	for _, key := range bar.AppendKeys(nil) {
+		if v, ok := bar.Get(key); !ok || string(v) != string(key) {
+			t.Fatalf("bar:%s should be set to %s: %s", key, key, v)
+		}
+	}
+}
+
+func TestPrefixViewQuota(t *testing.T) {
+	cache := NewBytesCache(4, 256)
+	foo := cache.WithPrefixQuota([]byte("foo:"), 10)
+	bar := cache.WithPrefix([]byte("bar:"))
+
+	for i := 0; i < 100; i++ {
+		bar.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+	for i := 0; i < 100; i++ {
+		foo.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+
+	if got, want := len(foo.AppendKeys(nil)), 10; got != want {
+		t.Fatalf("foo keys length %v should be %v", got, want)
+	}
+	if got, want := len(bar.AppendKeys(nil)), 100; got != want {
+		t.Fatalf("bar keys length %v should be %v", got, want)
+	}
+
+	if v, ok := foo.Get([]byte("99")); !ok || string(v) != "99" {
+		t.Fatalf("foo:99 should be set to 99: %s", v)
+	}
+	if v, ok := foo.Get([]byte("89")); ok {
+		t.Fatalf("foo:89 should be evicted by quota: %s", v)
+	}
+
+	foo.Get([]byte("90"))
+	foo.Set([]byte("100"), []byte("100"))
+
+	if v, ok := foo.Get([]byte("90")); !ok || string(v) != "90" {
+		t.Fatalf("foo:90 should not be evicted: %s", v)
+	}
+
+	if got, want := foo.DeletePrefix([]byte("9")), 9; got != want {
+		t.Fatalf("foo deleted keys %v should be %v", got, want)
+	}
+
+	for i := 0; i < 5; i++ {
+		foo.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+
+	if got, want := len(foo.AppendKeys(nil)), 6; got != want {
+		t.Fatalf("foo keys length %v should be %v", got, want)
+	}
+}
+
+func TestPrefixGuard(t *testing.T) {
+	cache := NewBytesCache(4, 256)
+	guard := cache.WithPrefixGuard(func(key []byte) []byte {
+		if i := bytes.IndexByte(key, '/'); i >= 0 {
+			return key[:i]
+		}
+		return key
+	}, 5, 1024)
+
+	guard.Set([]byte("good/a"), []byte("1"))
+	guard.Set([]byte("good/a"), []byte("2"))
+	for i := 0; i < 100; i++ {
+		guard.Set([]byte(fmt.Sprintf("evil/%d", i)), []byte("x"))
+	}
+
+	if got, want := cache.Len(), 6; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+
+	if v, ok := guard.Get([]byte("good/a")); !ok || string(v) != "2" {
+		t.Fatalf("good/a should be set to 2: %s", v)
+	}
+
+	for i := 95; i < 100; i++ {
+		if _, ok := guard.Peek([]byte(fmt.Sprintf("evil/%d", i))); !ok {
+			t.Fatalf("evil/%d should be kept", i)
+		}
+	}
+
+	if v := guard.Delete([]byte("good/a")); string(v) != "2" {
+		t.Fatalf("good/a should be deleted: %s", v)
+	}
+}
+
+func TestPrefixGuardDedupe(t *testing.T) {
+	cache := NewBytesCache(1, 256, WithMaxValueSize(4, false))
+	guard := cache.WithPrefixGuard(func(key []byte) []byte { return key[:1] }, 2, 1024)
+
+	guard.Set([]byte("a1"), []byte("1"))
+	guard.Set([]byte("a2"), []byte("oversized"))
+	guard.Set([]byte("a3"), []byte("3"))
+	if _, ok := guard.Peek([]byte("a1")); !ok {
+		t.Fatalf("a1 should not be evicted by the rejected a2")
+	}
+
+	guard.Delete([]byte("a1"))
+	guard.Set([]byte("a1"), []byte("1"))
+	guard.Set([]byte("a1"), []byte("1"))
+	for _, key := range []string{"a1", "a3"} {
+		if _, ok := guard.Peek([]byte(key)); !ok {
+			t.Fatalf("%s should not be evicted by the stale slot of a1", key)
+		}
+	}
+
+	guard.Set([]byte("a4"), []byte("4"))
+	if _, ok := guard.Peek([]byte("a3")); ok {
+		t.Fatalf("a3 should be evicted as the least recent key")
+	}
+	if got, want := cache.Len(), 2; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+}
+
+func TestPrefixViewQuotaEvicted(t *testing.T) {
+	cache := NewBytesCache(1, 128)
+	foo := cache.WithPrefixQuota([]byte("foo:"), 3)
+
+	for i := 0; i < 3; i++ {
+		foo.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+
+	// foo:0 and foo:1 are removed by the cache directly, so they no longer count against the quota.
+	cache.Delete([]byte("foo:0"))
+	cache.Delete([]byte("foo:1"))
+	foo.Set([]byte("3"), []byte("3"))
+	foo.Set([]byte("4"), []byte("4"))
+	if _, ok := foo.Peek([]byte("2")); !ok {
+		t.Fatalf("foo:2 should not be evicted by the quota")
+	}
+
+	foo.Set([]byte("5"), []byte("5"))
+	if _, ok := foo.Peek([]byte("2")); ok {
+		t.Fatalf("foo:2 should be evicted by the quota")
+	}
+}
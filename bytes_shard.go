@@ -3,7 +3,9 @@
 package lru
 
 import (
+	"bytes"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -22,6 +24,21 @@ type bytesbucket struct {
 
 // bytesshard is an LRU partition contains a list and a hash table.
 type bytesshard struct {
+	// stats, keep first for 64-bit atomic alignment on 32-bit platforms
+	statsGetCalls  uint64
+	statsSetCalls  uint64
+	statsMisses    uint64
+	statsEvictions uint64
+
+	// the total bytes of keys and values
+	bytesUsed int64
+
+	// the budget of bytesUsed, nil if the shard is bounded by entry count only
+	budget *bytesbudget
+
+	// padding, which keeps the shard size a multiple of 8 on 32-bit platforms
+	_ [8 - unsafe.Sizeof(uintptr(0))]byte
+
 	mu sync.Mutex
 
 	// the hash table, with 20% extra spacer than the list for fewer conflicts.
@@ -32,32 +49,45 @@ type bytesshard struct {
 	// the list of nodes
 	list []bytesnode
 
-	// stats
-	statsGetCalls uint64
-	statsSetCalls uint64
-	statsMisses   uint64
+	// the histograms of key and value sizes
+	sizes *sizehistograms
+
+	// the value checksums of strict mode, see bytes_strict.go
+	strict map[uint32]uint64
+}
+
+// bytesbudget bounds a shard by the total bytes of its keys and values.
+type bytesbudget struct {
+	max int64
 
-	// padding
-	_ [40]byte
+	// the entry larger than max evicts all other entries instead of being rejected, see OversizeEvictAll
+	evictAll bool
+}
+
+type sizehistograms struct {
+	keys   SizeHistogram
+	values SizeHistogram
 }
 
 func (s *bytesshard) Init(size uint32) {
 	s.listInit(size)
 	s.tableInit(size)
+	s.sizes = new(sizehistograms)
 }
 
 func (s *bytesshard) Get(hash uint32, key []byte) (value []byte, ok bool) {
 	s.mu.Lock()
 
-	s.statsGetCalls++
+	atomic.AddUint64(&s.statsGetCalls, 1)
 
 	if index, exists := s.tableGet(hash, key); exists {
 		s.listMoveToFront(index)
+		s.strictCheck(index)
 		// value = s.list[index].value
 		value = (*bytesnode)(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0]))).value
 		ok = true
 	} else {
-		s.statsMisses++
+		atomic.AddUint64(&s.statsMisses, 1)
 	}
 
 	s.mu.Unlock()
@@ -65,10 +95,30 @@ func (s *bytesshard) Get(hash uint32, key []byte) (value []byte, ok bool) {
 	return
 }
 
+func (s *bytesshard) GetAppend(dst []byte, hash uint32, key []byte) (_ []byte, ok bool) {
+	s.mu.Lock()
+
+	atomic.AddUint64(&s.statsGetCalls, 1)
+
+	if index, exists := s.tableGet(hash, key); exists {
+		s.listMoveToFront(index)
+		s.strictCheck(index)
+		dst = append(dst, s.list[index].value...)
+		ok = true
+	} else {
+		atomic.AddUint64(&s.statsMisses, 1)
+	}
+
+	s.mu.Unlock()
+
+	return dst, ok
+}
+
 func (s *bytesshard) Peek(hash uint32, key []byte) (value []byte, ok bool) {
 	s.mu.Lock()
 
 	if index, exists := s.tableGet(hash, key); exists {
+		s.strictCheck(index)
 		value = s.list[index].value
 		ok = true
 	}
@@ -78,6 +128,14 @@ func (s *bytesshard) Peek(hash uint32, key []byte) (value []byte, ok bool) {
 	return
 }
 
+func (s *bytesshard) Contains(hash uint32, key []byte) (ok bool) {
+	s.mu.Lock()
+	_, ok = s.tableGet(hash, key)
+	s.mu.Unlock()
+
+	return
+}
+
 func (s *bytesshard) SetIfAbsent(hash uint32, key []byte, value []byte) (prev []byte, replaced bool) {
 	s.mu.Lock()
 
@@ -87,20 +145,35 @@ func (s *bytesshard) SetIfAbsent(hash uint32, key []byte, value []byte) (prev []
 		return
 	}
 
-	s.statsSetCalls++
+	atomic.AddUint64(&s.statsSetCalls, 1)
+
+	if s.budget != nil && int64(len(key)+len(value)) > s.budget.max && !s.budget.evictAll {
+		s.mu.Unlock()
+		return
+	}
 
 	// index := s.list_Back()
 	// node := &s.list[index]
 	index := s.list[0].prev
 	node := (*bytesnode)(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0])))
 	evictedValue := node.value
-	s.tableDelete(uint32(wyhashHashbytes(node.key, 0)), node.key)
+
+	// delete the old key if the list is full, note that the list length is size+1
+	if uint32(len(s.list)-1) < s.tableLength+1 && b2s(key) != b2s(node.key) {
+		s.tableDelete(uint32(wyhashHashbytes(node.key, 0)), node.key)
+		s.sizeRemove(node.key, node.value)
+		atomic.AddUint64(&s.statsEvictions, 1)
+	}
 
 	node.key = key
 	node.value = value
+	s.strictSet(index)
 	s.tableSet(hash, key, index)
 	s.listMoveToFront(index)
 	prev = evictedValue
+	if used := s.sizeAdd(key, value); s.budget != nil && used > s.budget.max {
+		s.evictBytes(index)
+	}
 
 	s.mu.Unlock()
 	return
@@ -109,16 +182,28 @@ func (s *bytesshard) SetIfAbsent(hash uint32, key []byte, value []byte) (prev []
 func (s *bytesshard) Set(hash uint32, key []byte, value []byte) (prev []byte, replaced bool) {
 	s.mu.Lock()
 
-	s.statsSetCalls++
+	atomic.AddUint64(&s.statsSetCalls, 1)
+
+	if s.budget != nil && int64(len(key)+len(value)) > s.budget.max && !s.budget.evictAll {
+		// reject the entry larger than the whole budget, and drop the stale value of key.
+		s.deleteLocked(hash, key)
+		s.mu.Unlock()
+		return
+	}
 
 	if index, exists := s.tableGet(hash, key); exists {
 		// node := &s.list[index]
 		node := (*bytesnode)(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0])))
 		previousValue := node.value
 		s.listMoveToFront(index)
+		s.strictCheck(index)
 		node.value = value
+		s.strictSet(index)
 		prev = previousValue
 		replaced = true
+		if used := s.sizeReplace(previousValue, value); s.budget != nil && used > s.budget.max {
+			s.evictBytes(index)
+		}
 
 		s.mu.Unlock()
 		return
@@ -129,13 +214,23 @@ func (s *bytesshard) Set(hash uint32, key []byte, value []byte) (prev []byte, re
 	index := s.list[0].prev
 	node := (*bytesnode)(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0])))
 	evictedValue := node.value
-	s.tableDelete(uint32(wyhashHashbytes(node.key, 0)), node.key)
+
+	// delete the old key if the list is full, note that the list length is size+1
+	if uint32(len(s.list)-1) < s.tableLength+1 && b2s(key) != b2s(node.key) {
+		s.tableDelete(uint32(wyhashHashbytes(node.key, 0)), node.key)
+		s.sizeRemove(node.key, node.value)
+		atomic.AddUint64(&s.statsEvictions, 1)
+	}
 
 	node.key = key
 	node.value = value
+	s.strictSet(index)
 	s.tableSet(hash, key, index)
 	s.listMoveToFront(index)
 	prev = evictedValue
+	if used := s.sizeAdd(key, value); s.budget != nil && used > s.budget.max {
+		s.evictBytes(index)
+	}
 
 	s.mu.Unlock()
 	return
@@ -143,21 +238,47 @@ func (s *bytesshard) Set(hash uint32, key []byte, value []byte) (prev []byte, re
 
 func (s *bytesshard) Delete(hash uint32, key []byte) (v []byte) {
 	s.mu.Lock()
+	v = s.deleteLocked(hash, key)
+	s.mu.Unlock()
+
+	return
+}
 
+// deleteLocked is the same as Delete, but the caller must hold the shard lock.
+func (s *bytesshard) deleteLocked(hash uint32, key []byte) (v []byte) {
 	if index, exists := s.tableGet(hash, key); exists {
 		node := &s.list[index]
 		value := node.value
+		s.sizeRemove(node.key, value)
 		s.listMoveToBack(index)
 		node.value = v
 		s.tableDelete(hash, key)
 		v = value
 	}
 
-	s.mu.Unlock()
-
 	return
 }
 
+// evictBytes evicts entries from the list back until the total bytes fit the budget, except the node keep.
+// The caller must hold the shard lock.
+func (s *bytesshard) evictBytes(keep uint32) {
+	// walk from the list back, skipping the free nodes which are not in the table.
+	for index := s.list[0].prev; index != 0 && s.bytesUsed > s.budget.max; {
+		node := &s.list[index]
+		prev := node.prev
+		if index != keep {
+			hash := uint32(wyhashHashbytes(node.key, 0))
+			if i, exists := s.tableGet(hash, node.key); exists && i == index {
+				s.tableDelete(hash, node.key)
+				s.sizeRemove(node.key, node.value)
+				atomic.AddUint64(&s.statsEvictions, 1)
+				node.value = nil
+			}
+		}
+		index = prev
+	}
+}
+
 func (s *bytesshard) Len() (n uint32) {
 	s.mu.Lock()
 	// inlining s.table_Len()
@@ -180,3 +301,113 @@ func (s *bytesshard) AppendKeys(dst [][]byte) [][]byte {
 
 	return dst
 }
+
+func (s *bytesshard) EvictTo(n uint32) (evicted uint32) {
+	s.mu.Lock()
+	// walk from the list back, skipping the free nodes which are not in the table.
+	for index := s.list[0].prev; index != 0 && s.tableLength > n; {
+		node := &s.list[index]
+		prev := node.prev
+		hash := uint32(wyhashHashbytes(node.key, 0))
+		if i, exists := s.tableGet(hash, node.key); exists && i == index {
+			s.tableDelete(hash, node.key)
+			s.sizeRemove(node.key, node.value)
+			atomic.AddUint64(&s.statsEvictions, 1)
+			node.value = nil
+			evicted++
+		}
+		index = prev
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *bytesshard) ScanKeys(dst [][]byte, pos uint32, count int) ([][]byte, uint32) {
+	s.mu.Lock()
+	for n := uint32(len(s.tableBuckets)); pos < n; pos++ {
+		if count == 0 {
+			s.mu.Unlock()
+			return dst, pos
+		}
+		b := (*bytesbucket)(unsafe.Pointer(&s.tableBuckets[pos]))
+		if b.index == 0 {
+			continue
+		}
+		dst = append(dst, s.list[b.index].key)
+		count--
+	}
+	s.mu.Unlock()
+
+	return dst, 0
+}
+
+func (s *bytesshard) DeletePrefix(prefix []byte) (deleted uint32) {
+	s.mu.Lock()
+	for i := uint32(0); i < uint32(len(s.tableBuckets)); {
+		b := (*bytesbucket)(unsafe.Pointer(&s.tableBuckets[i]))
+		if b.index == 0 || !bytes.HasPrefix(s.list[b.index].key, prefix) {
+			i++
+			continue
+		}
+		node := &s.list[b.index]
+		s.sizeRemove(node.key, node.value)
+		s.listMoveToBack(b.index)
+		node.value = nil
+		// the following buckets are shifted backward, so check i again.
+		s.tableDeleteByIndex(i)
+		deleted++
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *bytesshard) Clear() {
+	s.mu.Lock()
+	s.reset()
+	s.mu.Unlock()
+}
+
+func (s *bytesshard) reset() {
+	for i := range s.list {
+		s.list[i] = bytesnode{}
+	}
+	for i := range s.tableBuckets {
+		s.tableBuckets[i] = 0
+	}
+	atomic.StoreUint32(&s.tableLength, 0)
+	atomic.StoreInt64(&s.bytesUsed, 0)
+	*s.sizes = sizehistograms{}
+	s.listInit(uint32(len(s.list) - 1))
+}
+
+// sizeAdd accounts the sizes of an inserted entry, and returns the total bytes.
+func (s *bytesshard) sizeAdd(key, value []byte) int64 {
+	s.sizes.keys[sizeClass(len(key))]++
+	s.sizes.values[sizeClass(len(value))]++
+	return atomic.AddInt64(&s.bytesUsed, int64(len(key)+len(value)))
+}
+
+// sizeReplace accounts the sizes of a replaced value, and returns the total bytes.
+func (s *bytesshard) sizeReplace(prev, value []byte) int64 {
+	s.sizes.values[sizeClass(len(prev))]--
+	s.sizes.values[sizeClass(len(value))]++
+	return atomic.AddInt64(&s.bytesUsed, int64(len(value)-len(prev)))
+}
+
+// sizeRemove accounts the sizes of a removed entry.
+func (s *bytesshard) sizeRemove(key, value []byte) {
+	s.sizes.keys[sizeClass(len(key))]--
+	s.sizes.values[sizeClass(len(value))]--
+	atomic.AddInt64(&s.bytesUsed, -int64(len(key)+len(value)))
+}
+
+func (s *bytesshard) SizeHistograms(keys, values *SizeHistogram) {
+	s.mu.Lock()
+	for i := range keys {
+		keys[i] += s.sizes.keys[i]
+		values[i] += s.sizes.values[i]
+	}
+	s.mu.Unlock()
+}
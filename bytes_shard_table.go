@@ -6,6 +6,7 @@
 package lru
 
 import (
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -15,7 +16,7 @@ func (s *bytesshard) tableInit(size uint32) {
 		s.tableBuckets = make([]uint64, newsize)
 	}
 	s.tableMask = newsize - 1
-	s.tableLength = 0
+	atomic.StoreUint32(&s.tableLength, 0)
 }
 
 func bytesNewTableSize(size uint32) (newsize uint32) {
@@ -43,7 +44,7 @@ func (s *bytesshard) tableSet(hash uint32, key []byte, index uint32) (prev uint3
 		if b.hdib&maxDIB == 0 {
 			b.hdib = hdib
 			b.index = index
-			s.tableLength++
+			atomic.AddUint32(&s.tableLength, 1)
 			return
 		}
 		if hdib>>dibBitSize == b.hdib>>dibBitSize && b2s((*bytesnode)(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key) == b2s(key) {
@@ -122,5 +123,5 @@ func (s *bytesshard) tableDeleteByIndex(i uint32) {
 		bpi.index = bi.index
 		bpi.hdib = bi.hdib>>dibBitSize<<dibBitSize | (bi.hdib&maxDIB-1)&maxDIB
 	}
-	s.tableLength--
+	atomic.AddUint32(&s.tableLength, ^uint32(0))
 }
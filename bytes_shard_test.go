@@ -8,9 +8,14 @@ import (
 func TestBytesShardPadding(t *testing.T) {
 	var s bytesshard
 
-	if n := unsafe.Sizeof(s); n != 128 {
+	// the shards are padded to a cache line on 64-bit platforms.
+	if n := unsafe.Sizeof(s); unsafe.Sizeof(uintptr(0)) == 8 && n != 128 {
 		t.Errorf("shard size is %d, not 128", n)
 	}
+	// the stats counters at the shard head are 64-bit aligned in arrays on 32-bit platforms.
+	if n := unsafe.Sizeof(s); n%8 != 0 {
+		t.Errorf("shard size is %d, not a multiple of 8", n)
+	}
 }
 
 func TestBytesShardListSet(t *testing.T) {
@@ -0,0 +1,24 @@
+//go:build lru_strict
+// +build lru_strict
+
+package lru
+
+import (
+	"fmt"
+)
+
+// strictSet records the checksum of the value of node index, for the strict mode built with the lru_strict tag.
+func (s *bytesshard) strictSet(index uint32) {
+	if s.strict == nil {
+		s.strict = make(map[uint32]uint64)
+	}
+	s.strict[index] = wyhashHashbytes(s.list[index].value, 0)
+}
+
+// strictCheck panics if the value of node index has been mutated since it was set, by the caller of Set
+// or the caller of Get and Peek which holds the returned slice.
+func (s *bytesshard) strictCheck(index uint32) {
+	if sum, ok := s.strict[index]; ok && sum != wyhashHashbytes(s.list[index].value, 0) {
+		panic(fmt.Sprintf("lru: value of key %q is mutated after it was set", s.list[index].key))
+	}
+}
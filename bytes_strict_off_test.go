@@ -0,0 +1,19 @@
+//go:build !lru_strict
+// +build !lru_strict
+
+package lru
+
+import (
+	"testing"
+)
+
+// TestBytesCacheShareValues mutates a shared value on purpose, which panics in the strict mode.
+func TestBytesCacheShareValues(t *testing.T) {
+	cache := NewBytesCache(1, 128, WithCopyValues(false))
+	cache.Set([]byte("foo"), []byte("bar"))
+	v, _ := cache.Get([]byte("foo"))
+	copy(v, "xxx")
+	if v, ok := cache.Get([]byte("foo")); !ok || string(v) != "xxx" {
+		t.Fatalf("foo should be shared without copy on get: %q", v)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build lru_strict
+// +build lru_strict
+
+package lru
+
+import (
+	"testing"
+)
+
+func TestBytesCacheStrict(t *testing.T) {
+	cache := NewBytesCache(1, 16)
+
+	cache.Set([]byte("a"), []byte("1"))
+	if v, ok := cache.Get([]byte("a")); !ok || string(v) != "1" {
+		t.Fatalf("a should be 1: %s %v", v, ok)
+	}
+
+	v, _ := cache.Peek([]byte("a"))
+	v[0] = '2'
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("mutated value should panic")
+		}
+	}()
+	cache.Get([]byte("a"))
+}
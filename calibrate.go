@@ -0,0 +1,145 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CalibrateOptions specifies the synthetic workload of Calibrate.
+type CalibrateOptions struct {
+	// Size is the cache capacity, the default is 1<<16.
+	Size int
+
+	// Parallelism is the number of goroutines accessing the cache, the default is GOMAXPROCS.
+	Parallelism int
+
+	// Duration is the running time of each candidate, the default is 20ms.
+	Duration time.Duration
+
+	// WriteRatio is the ratio of Set in the accesses, the default is 0.1.
+	WriteRatio float64
+}
+
+// Calibration is the result of Calibrate, apply it by WithShards and WithPromoteAfter.
+type Calibration struct {
+	// Shards is the recommended shards count.
+	Shards uint32
+
+	// PromoteAfter is the recommended promotion threshold.
+	PromoteAfter uint8
+
+	// Throughput is the accesses per second of the recommended options.
+	Throughput float64
+}
+
+// Calibrate runs a short synthetic workload of zipf distributed keys on LRUCache of the current machine, with varying
+// shards counts and promotion thresholds, and returns the options of the highest throughput. It takes about the
+// Duration times the count of candidates, and returns the best calibration so far with ctx.Err() if ctx is done.
+func Calibrate(ctx context.Context, opts CalibrateOptions) (best Calibration, err error) {
+	if opts.Size <= 0 {
+		opts.Size = 1 << 16
+	}
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = runtime.GOMAXPROCS(0)
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = 20 * time.Millisecond
+	}
+	if opts.WriteRatio <= 0 {
+		opts.WriteRatio = 0.1
+	}
+
+	// pre-generate the keys of each goroutine, so the key generation is not measured.
+	keys := make([][]uint64, opts.Parallelism)
+	for i := range keys {
+		zipf := rand.NewZipf(rand.New(rand.NewSource(int64(i))), 1.1, 1, uint64(opts.Size)*4)
+		keys[i] = make([]uint64, 1<<16)
+		for j := range keys[i] {
+			keys[i][j] = zipf.Uint64()
+		}
+	}
+
+	run := func(shards uint32, promote uint8) (float64, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		cache := NewLRUCache[uint64, uint64](opts.Size, WithShards[uint64, uint64](shards), WithPromoteAfter[uint64, uint64](promote))
+		for i := 0; i < opts.Size; i++ {
+			cache.Set(uint64(i), uint64(i))
+		}
+
+		writes := uint64(opts.WriteRatio * 1024)
+		var total uint64
+		var stop uint32
+		var wg sync.WaitGroup
+		wg.Add(opts.Parallelism)
+		start := time.Now()
+		for i := 0; i < opts.Parallelism; i++ {
+			go func(keys []uint64) {
+				defer wg.Done()
+				n := uint64(0)
+				for off := 0; atomic.LoadUint32(&stop) == 0; off = (off + 1024) % len(keys) {
+					// check the stop flag once per batch of accesses.
+					for _, key := range keys[off : off+1024] {
+						if n%1024 < writes {
+							cache.Set(key, n)
+						} else {
+							cache.Get(key)
+						}
+						n++
+					}
+				}
+				atomic.AddUint64(&total, n)
+			}(keys[i])
+		}
+		select {
+		case <-time.After(opts.Duration):
+		case <-ctx.Done():
+		}
+		atomic.StoreUint32(&stop, 1)
+		wg.Wait()
+
+		return float64(total) / time.Since(start).Seconds(), ctx.Err()
+	}
+
+	for i, shards := range calibrateShards(runtime.GOMAXPROCS(0)) {
+		throughput, err := run(shards, 1)
+		if err != nil {
+			return best, err
+		}
+		if i == 0 || throughput > best.Throughput {
+			best = Calibration{Shards: shards, PromoteAfter: 1, Throughput: throughput}
+		}
+	}
+
+	for _, promote := range []uint8{2, 4} {
+		throughput, err := run(best.Shards, promote)
+		if err != nil {
+			return best, err
+		}
+		if throughput > best.Throughput {
+			best.PromoteAfter, best.Throughput = promote, throughput
+		}
+	}
+
+	return best, nil
+}
+
+// calibrateShards returns the shards counts around the default, which is 16 times of GOMAXPROCS. The first count is
+// capped at 512, so that there is always a candidate on machines of more than 512 procs.
+func calibrateShards(procs int) (shards []uint32) {
+	n := nextPowOf2(uint32(procs))
+	if n > 512 {
+		n = 512
+	}
+	for ; n <= 512; n *= 4 {
+		shards = append(shards, n)
+	}
+	return
+}
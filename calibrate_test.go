@@ -0,0 +1,50 @@
+package lru
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCalibrate(t *testing.T) {
+	c, err := Calibrate(context.Background(), CalibrateOptions{Size: 1024, Duration: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("calibrate failed: %+v", err)
+	}
+	if c.Shards == 0 || c.Shards > 512 || c.Shards&(c.Shards-1) != 0 {
+		t.Fatalf("shards %v should be power of two", c.Shards)
+	}
+	if c.PromoteAfter == 0 || c.Throughput <= 0 {
+		t.Fatalf("bad calibration: %+v", c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Calibrate(ctx, CalibrateOptions{Size: 1024}); err != context.Canceled {
+		t.Fatalf("calibrate should be canceled: %+v", err)
+	}
+}
+
+func TestCalibrateShards(t *testing.T) {
+	cases := []struct {
+		procs  int
+		shards []uint32
+	}{
+		{1, []uint32{1, 4, 16, 64, 256}},
+		{6, []uint32{8, 32, 128, 512}},
+		{512, []uint32{512}},
+		{1024, []uint32{512}},
+		{4096, []uint32{512}},
+	}
+	for _, c := range cases {
+		shards := calibrateShards(c.procs)
+		if len(shards) != len(c.shards) {
+			t.Fatalf("calibrateShards(%d) = %v, want %v", c.procs, shards, c.shards)
+		}
+		for i := range shards {
+			if shards[i] != c.shards[i] {
+				t.Fatalf("calibrateShards(%d) = %v, want %v", c.procs, shards, c.shards)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hitwindowBuckets is the count of snapshots kept for the max window.
+const hitwindowBuckets = 60
+
+// hitwindow keeps the snapshots of lifetime get calls and misses in ring buckets, so the hit ratio of a recent window
+// is the delta between the current counters and a snapshot. A snapshot is taken by Get at most once per step.
+type hitwindow struct {
+	next uint32 // the clock of the next snapshot
+	step uint32 // the seconds between snapshots
+
+	mu        sync.Mutex
+	snapshots []hitsnapshot // the ring of snapshots, the clock of empty slots is 0
+	head      int           // the index of the latest snapshot
+}
+
+type hitsnapshot struct {
+	gets   uint64
+	misses uint64
+	clock  uint32
+}
+
+func (w *hitwindow) init(window time.Duration) {
+	clocking()
+
+	w.step = uint32((window/hitwindowBuckets + time.Second - 1) / time.Second)
+	if w.step == 0 {
+		w.step = 1
+	}
+	now := atomic.LoadUint32(&clock)
+	w.snapshots = make([]hitsnapshot, hitwindowBuckets+1)
+	w.snapshots[0] = hitsnapshot{clock: now}
+	w.head = 0
+	w.next = now + w.step
+}
+
+// due reports whether the caller should take a snapshot by record, it returns true once per step.
+func (w *hitwindow) due() bool {
+	now := atomic.LoadUint32(&clock)
+	next := atomic.LoadUint32(&w.next)
+	return now >= next && atomic.CompareAndSwapUint32(&w.next, next, now+w.step)
+}
+
+func (w *hitwindow) record(stats Stats) {
+	now := atomic.LoadUint32(&clock)
+	w.mu.Lock()
+	w.head = (w.head + 1) % len(w.snapshots)
+	w.snapshots[w.head] = hitsnapshot{gets: stats.GetCalls, misses: stats.Misses, clock: now}
+	w.mu.Unlock()
+}
+
+// ratio returns the hit ratio since the latest snapshot taken at least window ago, or the oldest snapshot if
+// the window is longer than the history. ok is false if there is no get call in the window.
+func (w *hitwindow) ratio(window time.Duration, stats Stats) (ratio float64, ok bool) {
+	since := atomic.LoadUint32(&clock)
+	if seconds := uint32(window / time.Second); seconds < since {
+		since -= seconds
+	} else {
+		since = 0
+	}
+
+	var base hitsnapshot
+	w.mu.Lock()
+	for i := 0; i < len(w.snapshots); i++ {
+		s := w.snapshots[(w.head-i+len(w.snapshots))%len(w.snapshots)]
+		if s.clock == 0 {
+			break
+		}
+		base = s
+		if s.clock <= since {
+			break
+		}
+	}
+	w.mu.Unlock()
+
+	gets, misses := statsDelta(stats.GetCalls, base.gets), statsDelta(stats.Misses, base.misses)
+	if gets == 0 {
+		return 0, false
+	}
+	if misses > gets {
+		misses = gets
+	}
+	return float64(gets-misses) / float64(gets), true
+}
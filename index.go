@@ -0,0 +1,170 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// valueindex is a secondary index maps the attribute of values to keys.
+// It is maintained by Set and Delete, the keys removed by eviction or expiration are dropped lazily.
+type valueindex[K comparable, V any] struct {
+	fn func(value V) any
+
+	mu    sync.Mutex
+	keys  map[any]map[K]struct{}
+	attrs map[K]any
+	limit int // prune the keys not in cache when the index grows beyond limit
+}
+
+const minIndexLimit = 1024
+
+func (x *valueindex[K, V]) set(key K, value V) (prune bool) {
+	attr := x.fn(value)
+
+	x.mu.Lock()
+	if x.keys == nil {
+		x.keys = make(map[any]map[K]struct{})
+		x.attrs = make(map[K]any)
+		x.limit = minIndexLimit
+	}
+	if old, ok := x.attrs[key]; !ok || old != attr {
+		x.unlink(key)
+		keys := x.keys[attr]
+		if keys == nil {
+			keys = make(map[K]struct{})
+			x.keys[attr] = keys
+		}
+		keys[key] = struct{}{}
+		x.attrs[key] = attr
+	}
+	prune = len(x.attrs) > x.limit
+	x.mu.Unlock()
+
+	return
+}
+
+func (x *valueindex[K, V]) delete(key K) {
+	x.mu.Lock()
+	x.unlink(key)
+	x.mu.Unlock()
+}
+
+func (x *valueindex[K, V]) get(attr any) (keys []K) {
+	x.mu.Lock()
+	for key := range x.keys[attr] {
+		keys = append(keys, key)
+	}
+	x.mu.Unlock()
+
+	return
+}
+
+// prune drops the keys not in cache, and doubles the limit of live keys.
+func (x *valueindex[K, V]) prune(contains func(key K) bool) {
+	x.mu.Lock()
+	for key := range x.attrs {
+		if !contains(key) {
+			x.unlink(key)
+		}
+	}
+	x.limit = 2 * len(x.attrs)
+	if x.limit < minIndexLimit {
+		x.limit = minIndexLimit
+	}
+	x.mu.Unlock()
+}
+
+func (x *valueindex[K, V]) clear() {
+	x.mu.Lock()
+	x.keys = nil
+	x.attrs = nil
+	x.mu.Unlock()
+}
+
+// unlink removes key from the index, the caller must hold the lock.
+func (x *valueindex[K, V]) unlink(key K) {
+	attr, ok := x.attrs[key]
+	if !ok {
+		return
+	}
+	if keys := x.keys[attr]; keys != nil {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(x.keys, attr)
+		}
+	}
+	delete(x.attrs, key)
+}
+
+func (c *LRUCache[K, V]) indexSet(key K, value V) {
+	for _, x := range c.indexes {
+		if x.set(key, value) {
+			x.prune(c.Contains)
+		}
+	}
+}
+
+func (c *LRUCache[K, V]) indexDelete(key K) {
+	for _, x := range c.indexes {
+		x.delete(key)
+	}
+}
+
+// GetByIndex returns keys and values whose attribute computed by the index named name equals to attr.
+// The attr must be the same type as the index function returns. It does not modify the recency of entries or count stats as Peek.
+func (c *LRUCache[K, V]) GetByIndex(name string, attr any) (keys []K, values []V) {
+	x := c.indexes[name]
+	if x == nil {
+		return
+	}
+	for _, key := range x.get(attr) {
+		// the attributes are computed from the stored values, not the values transformed by WithReadTransform.
+		hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+		if value, ok := c.shards[hash&c.mask].Peek(hash, key, nil); ok && x.fn(value) == attr {
+			if c.transform != nil {
+				value = c.transform(value)
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+		} else if !ok {
+			x.delete(key)
+		}
+	}
+	return
+}
+
+func (c *TTLCache[K, V]) indexSet(key K, value V) {
+	for _, x := range c.indexes {
+		if x.set(key, value) {
+			x.prune(c.Contains)
+		}
+	}
+}
+
+func (c *TTLCache[K, V]) indexDelete(key K) {
+	for _, x := range c.indexes {
+		x.delete(key)
+	}
+}
+
+// GetByIndex returns keys and values whose attribute computed by the index named name equals to attr.
+// The attr must be the same type as the index function returns. It does not modify the recency of entries or count stats,
+// and skips the expired entries and the markers set by SetNegative and Tombstone.
+func (c *TTLCache[K, V]) GetByIndex(name string, attr any) (keys []K, values []V) {
+	x := c.indexes[name]
+	if x == nil {
+		return
+	}
+	for _, key := range x.get(attr) {
+		hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+		if value, ok := c.shards[hash&c.mask].PeekLive(hash, key); ok && x.fn(value) == attr {
+			keys = append(keys, key)
+			values = append(values, value)
+		} else if !ok {
+			x.delete(key)
+		}
+	}
+	return
+}
@@ -0,0 +1,98 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// linkindex is the index of parent to child keys for cascading deletion, its zero value is ready to use.
+// The links of the parents removed by eviction or expiration are dropped when the parents are set again, or pruned lazily.
+type linkindex[K comparable] struct {
+	mu       sync.Mutex
+	parents  int32 // the number of linked parents, read atomically by Set and Delete to skip the lock.
+	children map[K]map[K]struct{}
+	limit    int // prune the parents not in cache when the index grows beyond limit
+}
+
+func (x *linkindex[K]) link(parent, child K) (prune bool) {
+	x.mu.Lock()
+	if x.children == nil {
+		x.children = make(map[K]map[K]struct{})
+		x.limit = minIndexLimit
+	}
+	children := x.children[parent]
+	if children == nil {
+		children = make(map[K]struct{})
+		x.children[parent] = children
+	}
+	children[child] = struct{}{}
+	atomic.StoreInt32(&x.parents, int32(len(x.children)))
+	prune = len(x.children) > x.limit
+	x.mu.Unlock()
+
+	return
+}
+
+// prune drops the links of the parents not in cache, and doubles the limit of live parents.
+func (x *linkindex[K]) prune(contains func(key K) bool) {
+	x.mu.Lock()
+	for parent := range x.children {
+		if !contains(parent) {
+			delete(x.children, parent)
+		}
+	}
+	atomic.StoreInt32(&x.parents, int32(len(x.children)))
+	x.limit = 2 * len(x.children)
+	if x.limit < minIndexLimit {
+		x.limit = minIndexLimit
+	}
+	x.mu.Unlock()
+}
+
+func (x *linkindex[K]) take(parent K) (children []K) {
+	if atomic.LoadInt32(&x.parents) == 0 {
+		return
+	}
+
+	x.mu.Lock()
+	for child := range x.children[parent] {
+		children = append(children, child)
+	}
+	delete(x.children, parent)
+	atomic.StoreInt32(&x.parents, int32(len(x.children)))
+	x.mu.Unlock()
+
+	return
+}
+
+// Link declares child depends on parent, so deleting parent by Delete deletes child as well, and the children of child recursively.
+// The links of parent are dropped once parent is deleted, or evicted and then set again, so link the parents in cache.
+func (c *LRUCache[K, V]) Link(parent, child K) {
+	if c.links.link(parent, child) {
+		c.links.prune(c.Contains)
+	}
+}
+
+// unlinkStale drops the links of key if it is not in cache, which is going to be set as an unrelated parent.
+func (c *LRUCache[K, V]) unlinkStale(key K) {
+	if atomic.LoadInt32(&c.links.parents) != 0 && !c.Contains(key) {
+		c.links.take(key)
+	}
+}
+
+// Link declares child depends on parent, so deleting parent by Delete deletes child as well, and the children of child recursively.
+// The links of parent are dropped once parent is deleted, or evicted or expired and then set again, so link the parents in cache.
+func (c *TTLCache[K, V]) Link(parent, child K) {
+	if c.links.link(parent, child) {
+		c.links.prune(c.Contains)
+	}
+}
+
+// unlinkStale drops the links of key if it is not in cache, which is going to be set as an unrelated parent.
+func (c *TTLCache[K, V]) unlinkStale(key K) {
+	if atomic.LoadInt32(&c.links.parents) != 0 && !c.Contains(key) {
+		c.links.take(key)
+	}
+}
@@ -5,6 +5,9 @@ package lru
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -14,12 +17,40 @@ type LRUCache[K comparable, V any] struct {
 	mask   uint32
 	hasher func(key unsafe.Pointer, seed uintptr) uintptr
 	seed   uintptr
+	equal  func(a, b K) bool
 	loader func(ctx context.Context, key K) (value V, err error)
 	group  singleflightGroup[K, V]
+	policy loaderpolicy
+
+	sampler *missSampler[K]
+	shadow  *shadowcache[K]
+	window  *hitwindow
+
+	locks []sync.Mutex
+
+	links linkindex[K]
+
+	indexes map[string]*valueindex[K, V]
+
+	transform func(value V) V
+
+	// the policy of entries heavier than the weight budget of shards, see WithOversizePolicy
+	oversize OversizePolicy
 }
 
 // NewLRUCache creates lru cache with size capacity.
+// It panics with *SizeError if size is negative or the size of each shard exceeds MaxShardSize.
 func NewLRUCache[K comparable, V any](size int, options ...Option[K, V]) *LRUCache[K, V] {
+	c, err := TryNewLRUCache(size, options...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// TryNewLRUCache creates lru cache with size capacity as NewLRUCache, but returns *SizeError instead of panicking
+// if size is negative or the size of each shard exceeds MaxShardSize.
+func TryNewLRUCache[K comparable, V any](size int, options ...Option[K, V]) (*LRUCache[K, V], error) {
 	j := -1
 	for i, o := range options {
 		if _, ok := o.(*shardsOption[K, V]); ok {
@@ -38,6 +69,11 @@ func NewLRUCache[K comparable, V any](size int, options ...Option[K, V]) *LRUCac
 		o.applyToLRUCache(c)
 	}
 
+	shardsize, err := getShardSize(size, c.mask+1)
+	if err != nil {
+		return nil, err
+	}
+
 	if c.hasher == nil {
 		c.hasher = getRuntimeHasher[K]()
 	}
@@ -45,84 +81,451 @@ func NewLRUCache[K comparable, V any](size int, options ...Option[K, V]) *LRUCac
 		c.seed = uintptr(fastrand64())
 	}
 
+	c.locks = make([]sync.Mutex, c.mask+1)
+
+	if c.shadow != nil {
+		c.shadow.init(size)
+	}
+
 	if isamd64 {
 		// pre-alloc lists and tables for compactness
-		shardsize := (uint32(size) + c.mask) / (c.mask + 1)
-		shardlists := make([]lrunode[K, V], (shardsize+1)*(c.mask+1))
-		tablesize := lruNewTableSize(uint32(shardsize))
-		tablebuckets := make([]uint64, tablesize*(c.mask+1))
-		for i := uint32(0); i <= c.mask; i++ {
-			c.shards[i].list = shardlists[i*(shardsize+1) : (i+1)*(shardsize+1)]
+		// compute the offsets in int, the total of shards may exceed uint32.
+		listsize, tablesize := int(shardsize)+1, int(lruNewTableSize(shardsize))
+		shardlists := make([]lrunode[K, V], listsize*int(c.mask+1))
+		tablebuckets := make([]uint64, tablesize*int(c.mask+1))
+		for i := 0; i <= int(c.mask); i++ {
+			c.shards[i].list = shardlists[i*listsize : (i+1)*listsize]
 			c.shards[i].tableBuckets = tablebuckets[i*tablesize : (i+1)*tablesize]
 			c.shards[i].Init(shardsize, c.hasher, c.seed)
+			c.shards[i].tableEqual = c.equal
 		}
 	} else {
-		shardsize := (uint32(size) + c.mask) / (c.mask + 1)
 		for i := uint32(0); i <= c.mask; i++ {
 			c.shards[i].Init(shardsize, c.hasher, c.seed)
+			c.shards[i].tableEqual = c.equal
 		}
 	}
 
-	return c
+	return c, nil
 }
 
 // Get returns value for key.
 func (c *LRUCache[K, V]) Get(key K) (value V, ok bool) {
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	value, ok = c.get(hash, key)
+	if !ok && c.sampler != nil && c.sampler.sample() {
+		c.sampler.fn(key, c.Stats())
+	}
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
+	if c.window != nil && c.window.due() {
+		c.window.record(c.Stats())
+	}
+	return
+}
+
+// get returns value for key with its hash, which is transformed by WithReadTransform if any.
+func (c *LRUCache[K, V]) get(hash uint32, key K) (value V, ok bool) {
+	if c.transform != nil {
+		return c.shards[hash&c.mask].GetTransformed(hash, key, c.transform)
+	}
 	// return c.shards[hash&c.mask].Get(hash, key)
 	return (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Get(hash, key)
 }
 
+// GetWithGeneration returns value and the generation of its slot for key. The slot generation changes once the entry
+// is evicted or deleted and its slot is reused, so the holders of a value can detect it cheaply by ValidateGeneration.
+func (c *LRUCache[K, V]) GetWithGeneration(key K) (value V, generation uint64, ok bool) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	value, generation, ok = c.shards[hash&c.mask].GetWithGeneration(hash, key, c.transform)
+	if !ok && c.sampler != nil && c.sampler.sample() {
+		c.sampler.fn(key, c.Stats())
+	}
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
+	return
+}
+
+// ValidateGeneration reports whether key is still cached in the slot of generation returned by GetWithGeneration,
+// it does not modify the recency of key. Replacing the value of key by Set keeps its generation.
+func (c *LRUCache[K, V]) ValidateGeneration(key K, generation uint64) bool {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.shards[hash&c.mask].ValidateGeneration(hash, key, generation)
+}
+
+// GetMulti returns the values of keys in cache, it groups keys by shard and locks each shard once.
+func (c *LRUCache[K, V]) GetMulti(keys []K) map[K]V {
+	values := make(map[K]V, len(keys))
+	hashes := make([]uint32, len(keys))
+	for i := range keys {
+		hashes[i] = uint32(c.hasher(noescape(unsafe.Pointer(&keys[i])), c.seed))
+	}
+	order := shardOrder(hashes, c.mask)
+	for i, j := 0, 0; i < len(order); i = j {
+		shard := hashes[order[i]] & c.mask
+		for j = i + 1; j < len(order) && hashes[order[j]]&c.mask == shard; j++ {
+		}
+		c.shards[shard].GetMulti(keys, hashes, order[i:j], values, c.transform)
+	}
+	return values
+}
+
 // GetOrLoad returns value for key, call loader function by singleflight if value was not in cache.
 func (c *LRUCache[K, V]) GetOrLoad(ctx context.Context, key K, loader func(context.Context, K) (V, error)) (value V, err error, ok bool) {
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	value, ok = c.shards[hash&c.mask].Get(hash, key)
+	value, ok = c.get(hash, key)
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
 	if !ok {
-		if loader == nil {
-			loader = c.loader
+		if c.sampler != nil && c.sampler.sample() {
+			c.sampler.fn(key, c.Stats())
 		}
-		if loader == nil {
-			err = ErrLoaderIsNil
+		value, err, ok = c.load(ctx, hash, key, loader)
+	}
+	return
+}
+
+// GetOrLoadChan is the non-blocking GetOrLoad with the loader specified by WithLoader option. The returned channel
+// receives the result once, the value in cache is sent immediately, otherwise the loader is called by singleflight
+// in a new goroutine, so callers could select on the channel with their own timeout.
+func (c *LRUCache[K, V]) GetOrLoadChan(ctx context.Context, key K) <-chan Result[V] {
+	ch := make(chan Result[V], 1)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	value, ok := c.get(hash, key)
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
+	switch {
+	case ok:
+		ch <- Result[V]{Value: value, OK: true}
+	case c.loader == nil:
+		ch <- Result[V]{Err: ErrLoaderIsNil}
+	default:
+		if c.sampler != nil && c.sampler.sample() {
+			c.sampler.fn(key, c.Stats())
+		}
+		c.group.DoChan(key, c.loadCall(ctx, hash, key, c.loader), ch)
+	}
+	return ch
+}
+
+// GetOrLoadBypass calls loader function by singleflight regardless of the cached value, and overwrites the cached value.
+// It is intended for force refreshes, the concurrent loads of key by GetOrLoad and GetOrLoadBypass are collapsed.
+func (c *LRUCache[K, V]) GetOrLoadBypass(ctx context.Context, key K, loader func(context.Context, K) (V, error)) (value V, err error, ok bool) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.load(ctx, hash, key, loader)
+}
+
+// load calls loader function by singleflight and sets the loaded value.
+func (c *LRUCache[K, V]) load(ctx context.Context, hash uint32, key K, loader func(context.Context, K) (V, error)) (value V, err error, ok bool) {
+	if loader == nil {
+		loader = c.loader
+	}
+	if loader == nil {
+		err = ErrLoaderIsNil
+		return
+	}
+	return c.group.Do(key, c.loadCall(ctx, hash, key, loader))
+}
+
+// loadCall returns the singleflight function which calls loader and sets the loaded value.
+func (c *LRUCache[K, V]) loadCall(ctx context.Context, hash uint32, key K, loader func(context.Context, K) (V, error)) func(call *singleflightCall[V]) (V, error) {
+	return func(call *singleflightCall[V]) (V, error) {
+		var v V
+		err := c.policy.do(ctx, func(ctx context.Context) (err error) {
+			v, err = loader(ctx, key)
 			return
+		})
+		if err != nil {
+			return v, err
 		}
-		value, err, ok = c.group.Do(key, func() (V, error) {
-			v, err := loader(ctx, key)
-			if err != nil {
-				return v, err
-			}
+		c.group.commit(call, func() {
+			c.unlinkStale(key)
 			c.shards[hash&c.mask].Set(hash, key, v)
-			return v, nil
+			if c.indexes != nil {
+				c.indexSet(key, v)
+			}
+			if c.shadow != nil {
+				c.shadow.set(key)
+			}
 		})
+		if c.transform != nil {
+			v = c.transform(v)
+		}
+		return v, nil
+	}
+}
+
+// ForgetLoad forgets the in-flight load of key, so the next GetOrLoad of key calls the loader again instead of
+// waiting for it. The forgotten load still returns to its waiters, but its value is not set to cache.
+// Call it before writing a new value of key, so the value is never overwritten by the stale load.
+func (c *LRUCache[K, V]) ForgetLoad(key K) {
+	c.group.Forget(key)
+}
+
+// InflightLoads returns the number of in-flight loads of GetOrLoad.
+func (c *LRUCache[K, V]) InflightLoads() int {
+	return c.group.Len()
+}
+
+// GetMultiOrLoad returns the values of keys in cache, and calls loader once with the missing keys.
+// The entries returned by loader are set to cache by SetMulti and merged into values. The TTL of loaded entries is ignored.
+// If loader fails, the values in cache are returned with the error.
+func (c *LRUCache[K, V]) GetMultiOrLoad(ctx context.Context, keys []K, loader func(context.Context, []K) ([]Entry[K, V], error)) (values map[K]V, err error) {
+	values = c.GetMulti(keys)
+	if len(values) == len(keys) {
+		return
+	}
+
+	var missing []K
+	seen := make(map[K]struct{}, len(keys)-len(values))
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if loader == nil {
+		err = ErrLoaderIsNil
+		return
+	}
+
+	entries, err := loader(ctx, missing)
+	if err != nil {
+		return
+	}
+	c.SetMulti(entries)
+	for _, e := range entries {
+		values[e.Key] = e.Value
 	}
 	return
 }
 
+// GetOrLoadSimple is GetOrLoad with background context and the loader specified by WithLoader option.
+func (c *LRUCache[K, V]) GetOrLoadSimple(key K) (value V, err error, ok bool) {
+	return c.GetOrLoad(context.Background(), key, nil)
+}
+
 // Peek returns value, but does not modify its recency.
 func (c *LRUCache[K, V]) Peek(key K) (value V, ok bool) {
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	// return c.shards[hash&c.mask].Peek(hash, key)
-	return (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Peek(hash, key)
+	// return c.shards[hash&c.mask].Peek(hash, key, c.transform)
+	return (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Peek(hash, key, c.transform)
+}
+
+// Contains reports whether key is in the cache, but does not modify its recency or copy its value.
+func (c *LRUCache[K, V]) Contains(key K) bool {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.shards[hash&c.mask].Contains(hash, key)
 }
 
 // Set inserts key value pair and returns previous value.
 func (c *LRUCache[K, V]) Set(key K, value V) (prev V, replaced bool) {
+	c.unlinkStale(key)
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	// return c.shards[hash&c.mask].Set(hash, key, value)
-	return (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Set(hash, key, value)
+	// prev, replaced = c.shards[hash&c.mask].Set(hash, key, value)
+	prev, replaced = (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Set(hash, key, value)
+	if c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	if c.shadow != nil {
+		c.shadow.set(key)
+	}
+	return
+}
+
+// TrySet inserts key value pair and returns previous value as Set, and returns *OversizeError if the entry is heavier than
+// the weight budget of its shard by WithWeigher and rejected by OversizeReject, in which case the previous value is deleted.
+func (c *LRUCache[K, V]) TrySet(key K, value V) (prev V, replaced bool, err error) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	if w := c.shards[hash&c.mask].weigher; w != nil && !w.evictAll {
+		if weight := int64(w.fn(key, value)); weight > w.max {
+			err = &OversizeError{Size: weight, Budget: w.max}
+		}
+	}
+	prev, replaced = c.Set(key, value)
+	return
+}
+
+// Hash returns the hash of key computed by the cache hasher, it could be cached by callers and passed to the Hashed variants.
+func (c *LRUCache[K, V]) Hash(key K) uint32 {
+	return uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+}
+
+// ShardOf returns the index of the shard which key lands in, it matches the index of ShardStats.
+// The shard of a key is deterministic across caches with the same shards count, hasher and WithSeed.
+func (c *LRUCache[K, V]) ShardOf(key K) int {
+	return int(uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed)) & c.mask)
+}
+
+// GetHashed returns value for key with the hash returned by Hash.
+func (c *LRUCache[K, V]) GetHashed(hash uint32, key K) (value V, ok bool) {
+	value, ok = c.get(hash, key)
+	if !ok && c.sampler != nil && c.sampler.sample() {
+		c.sampler.fn(key, c.Stats())
+	}
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
+	if c.window != nil && c.window.due() {
+		c.window.record(c.Stats())
+	}
+	return
+}
+
+// SetHashed inserts key value pair with the hash returned by Hash and returns previous value.
+func (c *LRUCache[K, V]) SetHashed(hash uint32, key K, value V) (prev V, replaced bool) {
+	c.unlinkStale(key)
+	// prev, replaced = c.shards[hash&c.mask].Set(hash, key, value)
+	prev, replaced = (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Set(hash, key, value)
+	if c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	if c.shadow != nil {
+		c.shadow.set(key)
+	}
+	return
+}
+
+// SetMulti inserts entries, it groups entries by shard and locks each shard once. The TTL of entries is ignored.
+func (c *LRUCache[K, V]) SetMulti(entries []Entry[K, V]) {
+	for i := range entries {
+		c.unlinkStale(entries[i].Key)
+	}
+	hashes := make([]uint32, len(entries))
+	for i := range entries {
+		hashes[i] = uint32(c.hasher(noescape(unsafe.Pointer(&entries[i].Key)), c.seed))
+	}
+	order := shardOrder(hashes, c.mask)
+	for i, j := 0, 0; i < len(order); i = j {
+		shard := hashes[order[i]] & c.mask
+		for j = i + 1; j < len(order) && hashes[order[j]]&c.mask == shard; j++ {
+		}
+		c.shards[shard].SetMulti(entries, hashes, order[i:j])
+	}
+	if c.indexes != nil {
+		for i := range entries {
+			c.indexSet(entries[i].Key, entries[i].Value)
+		}
+	}
 }
 
 // SetIfAbsent inserts key value pair and returns previous value, if key is absent in the cache.
 func (c *LRUCache[K, V]) SetIfAbsent(key K, value V) (prev V, replaced bool) {
+	c.unlinkStale(key)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	// prev, replaced = c.shards[hash&c.mask].SetIfAbsent(hash, key, value)
+	prev, replaced = (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).SetIfAbsent(hash, key, value)
+	if c.indexes != nil {
+		// the value may not be inserted, so index the value in cache.
+		if v, ok := c.shards[hash&c.mask].Peek(hash, key, nil); ok {
+			c.indexSet(key, v)
+		}
+	}
+	return
+}
+
+// GetOrSet returns the existing value for key if present, otherwise it inserts the value and returns it, in the way of sync.Map LoadOrStore.
+// The loaded result is true if the value was loaded, false if stored.
+func (c *LRUCache[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	c.unlinkStale(key)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	actual, loaded = c.shards[hash&c.mask].GetOrSet(hash, key, value, c.transform)
+	if !loaded && c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	return
+}
+
+// SetIf inserts key value pair if cond returns true and reports whether it was inserted.
+// The cond is called with the existing value under the shard lock, so it must not call methods of the cache.
+func (c *LRUCache[K, V]) SetIf(key K, value V, cond func(old V, exists bool) bool) bool {
+	c.unlinkStale(key)
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	// return c.shards[hash&c.mask].SetIfAbsent(hash, key, value)
-	return (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).SetIfAbsent(hash, key, value)
+	ok := c.shards[hash&c.mask].SetIf(hash, key, value, cond)
+	if ok && c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	return ok
+}
+
+// Compute calls fn with the existing value for key under the shard lock, and updates or inserts the value returned by fn if keep is true,
+// otherwise deletes key. It returns the new value and keep. The fn must not call methods of the cache.
+func (c *LRUCache[K, V]) Compute(key K, fn func(old V, exists bool) (new V, keep bool)) (value V, ok bool) {
+	c.unlinkStale(key)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	value, ok = c.shards[hash&c.mask].Compute(hash, key, fn)
+	if c.indexes != nil {
+		if ok {
+			c.indexSet(key, value)
+		} else {
+			c.indexDelete(key)
+		}
+	}
+	return
 }
 
 // Delete method deletes value associated with key and returns deleted value (or empty value if key was not in cache).
 func (c *LRUCache[K, V]) Delete(key K) (prev V) {
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	// return c.shards[hash&c.mask].Delete(hash, key)
-	return (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Delete(hash, key)
+	// prev = c.shards[hash&c.mask].Delete(hash, key)
+	prev = (*lrushard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Delete(hash, key)
+	if c.indexes != nil {
+		c.indexDelete(key)
+	}
+	for _, child := range c.links.take(key) {
+		c.Delete(child)
+	}
+	return
+}
+
+// DeleteIf deletes all entries matching fn and returns the number of deleted entries.
+// The fn is called under the shard lock, so it must not call methods of the cache.
+func (c *LRUCache[K, V]) DeleteIf(fn func(key K, value V) bool) (deleted int) {
+	for i := uint32(0); i <= c.mask; i++ {
+		deleted += int(c.shards[i].DeleteIf(fn))
+	}
+	return
+}
+
+// Pin pins the entry of key, so it is skipped when choosing the eviction victim and the next entry from the list back
+// is evicted instead, until it is unpinned or deleted. It returns false if key is absent.
+// A shard whose entries are all pinned still evicts its least recently used entry to make room for a new key.
+func (c *LRUCache[K, V]) Pin(key K) bool {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.shards[hash&c.mask].Pin(hash, key, true)
+}
+
+// Unpin unpins the entry of key, it returns false if key is absent.
+func (c *LRUCache[K, V]) Unpin(key K) bool {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.shards[hash&c.mask].Pin(hash, key, false)
+}
+
+// KeyLock returns a striped locker for key, which is picked by the same hash as the cache sharding.
+// Keys in the same shard share a locker, so do not hold it while locking another key.
+func (c *LRUCache[K, V]) KeyLock(key K) sync.Locker {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return &c.locks[hash&c.mask]
+}
+
+// Clear deletes all entries in place, the pre-allocated lists and tables are reused.
+func (c *LRUCache[K, V]) Clear() {
+	for i := uint32(0); i <= c.mask; i++ {
+		c.shards[i].Clear()
+	}
+	for _, x := range c.indexes {
+		x.clear()
+	}
 }
 
 // Len returns number of cached nodes.
@@ -134,6 +537,15 @@ func (c *LRUCache[K, V]) Len() int {
 	return int(n)
 }
 
+// Weight returns the total weight of entries computed by the weigher of WithWeigher, or 0 without the option.
+func (c *LRUCache[K, V]) Weight() int64 {
+	var weight int64
+	for i := uint32(0); i <= c.mask; i++ {
+		weight += c.shards[i].Weight()
+	}
+	return weight
+}
+
 // AppendKeys appends all keys to keys and return the keys.
 func (c *LRUCache[K, V]) AppendKeys(keys []K) []K {
 	for i := uint32(0); i <= c.mask; i++ {
@@ -142,16 +554,209 @@ func (c *LRUCache[K, V]) AppendKeys(keys []K) []K {
 	return keys
 }
 
+// EvictToWatermark evicts the least recent used entries of shards proportionally,
+// until the number of cached nodes is not greater than n, and returns the number of evicted nodes.
+// It is intended to be called by memory pressure handlers, e.g. polling runtime/metrics against debug.SetMemoryLimit.
+func (c *LRUCache[K, V]) EvictToWatermark(n int) (evicted int) {
+	if n < 0 {
+		n = 0
+	}
+	total := c.Len()
+	if total <= n {
+		return
+	}
+	for i := uint32(0); i <= c.mask; i++ {
+		s := &c.shards[i]
+		target := uint64(s.Len()) * uint64(n) / uint64(total)
+		evicted += int(s.EvictTo(uint32(target)))
+	}
+	return
+}
+
+// ScanKeys iterates keys incrementally in the way of redis SCAN, it returns at most count keys
+// starting from cursor and the next cursor, iteration is complete when the next cursor is zero.
+// Keys added or deleted during the iteration may be missed or returned twice.
+func (c *LRUCache[K, V]) ScanKeys(cursor uint64, count int) (keys []K, next uint64) {
+	if count < 1 {
+		count = 1
+	}
+	pos := uint32(cursor)
+	for i := uint32(cursor >> 32); i <= c.mask && len(keys) < count; i++ {
+		keys, pos = c.shards[i].ScanKeys(keys, pos, count-len(keys))
+		if pos != 0 {
+			return keys, uint64(i)<<32 | uint64(pos)
+		}
+		if i < c.mask {
+			next = uint64(i+1) << 32
+		} else {
+			next = 0
+		}
+	}
+	return
+}
+
+// Range calls fn for each entry until fn returns false, fn is called out of the shard lock.
+// It copies the entries of at most batch buckets under the shard lock at a time, so the iteration of large shards
+// does not block writers for long, and batch less than 1 means the whole shard. Since the lock is released between batches,
+// entries added or deleted during the iteration may be missed or visited twice, the same as ScanKeys.
+func (c *LRUCache[K, V]) Range(batch int, fn func(key K, value V) bool) {
+	var keys []K
+	var values []V
+	for i := uint32(0); i <= c.mask; i++ {
+		for pos := uint32(0); ; {
+			keys, values, pos = c.shards[i].ScanEntries(keys[:0], values[:0], pos, batch, c.transform)
+			for j := range keys {
+				if !fn(keys[j], values[j]) {
+					return
+				}
+			}
+			if pos == 0 {
+				break
+			}
+		}
+	}
+}
+
+// ForEachParallel calls fn for each entry by bounded workers, which process shards concurrently.
+// fn is called out of the shard lock with a snapshot of the shard entries.
+// It stops on the first error returned by fn or the cancellation of ctx, and returns the error.
+func (c *LRUCache[K, V]) ForEachParallel(ctx context.Context, workers int, fn func(key K, value V) error) (err error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var next uint32
+	var once sync.Once
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			var keys []K
+			var values []V
+			for {
+				i := atomic.AddUint32(&next, 1) - 1
+				if i > c.mask {
+					return
+				}
+				keys, values = c.shards[i].AppendEntries(keys[:0], values[:0], c.transform)
+				for j := range keys {
+					select {
+					case <-ctx.Done():
+						return
+					default:
+					}
+					if e := fn(keys[j], values[j]); e != nil {
+						once.Do(func() { err = e })
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err == nil {
+		// the context is only canceled by parent here
+		err = ctx.Err()
+	}
+	return
+}
+
+// EvictionOrder returns at most max keys in the order they would be evicted.
+// Eviction strictly follows the shard list order, a Get or Set moves the key to the front and a Delete frees its node,
+// so the keys are collected from the back of each shard list, shard by shard. The order is global only for a single shard cache.
+func (c *LRUCache[K, V]) EvictionOrder(max int) (keys []K) {
+	for i := uint32(0); i <= c.mask && len(keys) < max; i++ {
+		keys = c.shards[i].AppendEvictionOrder(keys, max-len(keys))
+	}
+	return
+}
+
+// ShardOldest returns the least recently used entry of the shard, which is the next to be evicted from it.
+// The shard is the index of ShardOf and ShardStats, and ok is false if the shard is empty or out of range.
+// The recency is ordered within a shard only, so the entries of different shards are not comparable.
+func (c *LRUCache[K, V]) ShardOldest(shard int) (key K, value V, ok bool) {
+	if shard < 0 || shard > int(c.mask) {
+		return
+	}
+	return c.shards[shard].Edge(true, c.transform)
+}
+
+// ShardNewest returns the most recently used entry of the shard, in the same way as ShardOldest.
+func (c *LRUCache[K, V]) ShardNewest(shard int) (key K, value V, ok bool) {
+	if shard < 0 || shard > int(c.mask) {
+		return
+	}
+	return c.shards[shard].Edge(false, c.transform)
+}
+
 // Stats returns cache stats.
 func (c *LRUCache[K, V]) Stats() (stats Stats) {
+	c.CollectStats(&stats)
+	return
+}
+
+// CollectStats overwrites stats with cache stats, it reads the shard counters atomically without locking shards,
+// so it is cheap for frequent scraping, and the counters of different shards may be observed at slightly different times.
+func (c *LRUCache[K, V]) CollectStats(stats *Stats) {
+	*stats = Stats{}
 	for i := uint32(0); i <= c.mask; i++ {
 		s := &c.shards[i]
-		s.mu.Lock()
-		stats.EntriesCount += uint64(s.tableLength)
-		stats.GetCalls += s.statsGetCalls
-		stats.SetCalls += s.statsSetCalls
-		stats.Misses += s.statsMisses
-		s.mu.Unlock()
+		stats.EntriesCount += uint64(atomic.LoadUint32(&s.tableLength))
+		stats.GetCalls += atomic.LoadUint64(&s.statsGetCalls)
+		stats.SetCalls += atomic.LoadUint64(&s.statsSetCalls)
+		stats.Misses += atomic.LoadUint64(&s.statsMisses)
+		stats.Evictions += atomic.LoadUint64(&s.removal.evictions)
+	}
+	if c.shadow != nil {
+		stats.ShadowMisses = atomic.LoadUint64(&c.shadow.misses)
+	}
+}
+
+// ShardStats returns the stats of each shard, for finding the imbalance of shards, ShadowMisses is not per shard.
+func (c *LRUCache[K, V]) ShardStats() []Stats {
+	stats := make([]Stats, c.mask+1)
+	for i := range stats {
+		s := &c.shards[i]
+		stats[i] = Stats{
+			GetCalls:     atomic.LoadUint64(&s.statsGetCalls),
+			SetCalls:     atomic.LoadUint64(&s.statsSetCalls),
+			Misses:       atomic.LoadUint64(&s.statsMisses),
+			EntriesCount: uint64(atomic.LoadUint32(&s.tableLength)),
+			Evictions:    atomic.LoadUint64(&s.removal.evictions),
+		}
+	}
+	return stats
+}
+
+// HitRatio returns the hit ratio of Get calls in the last window, which is tracked by WithHitRatioWindow.
+// The window is rounded to the snapshots of the tracker, and ok is false if it is not tracked or there is no Get call.
+func (c *LRUCache[K, V]) HitRatio(window time.Duration) (ratio float64, ok bool) {
+	if c.window == nil {
+		return 0, false
+	}
+	return c.window.ratio(window, c.Stats())
+}
+
+// ResetStats swaps the stats counters to zero and returns their values before reset, EntriesCount is kept as current value.
+// Each counter is swapped atomically, so the counts between two calls are never lost or counted twice, and the returned
+// stats are the counts of the interval since the last reset.
+func (c *LRUCache[K, V]) ResetStats() (stats Stats) {
+	for i := uint32(0); i <= c.mask; i++ {
+		s := &c.shards[i]
+		stats.EntriesCount += uint64(atomic.LoadUint32(&s.tableLength))
+		stats.GetCalls += atomic.SwapUint64(&s.statsGetCalls, 0)
+		stats.SetCalls += atomic.SwapUint64(&s.statsSetCalls, 0)
+		stats.Misses += atomic.SwapUint64(&s.statsMisses, 0)
+		stats.Evictions += atomic.SwapUint64(&s.removal.evictions, 0)
+	}
+	if c.shadow != nil {
+		stats.ShadowMisses = atomic.SwapUint64(&c.shadow.misses, 0)
 	}
 	return
 }
@@ -2,9 +2,11 @@ package lru
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -220,16 +222,44 @@ func TestLRUCacheHasher(t *testing.T) {
 	}
 }
 
-func TestLRUCacheSliding(t *testing.T) {
-	defer func() {
-		if r := recover(); r != nil {
-			if !strings.Contains(fmt.Sprint(r), "not_supported") {
-				t.Errorf("should be not_supported")
+func TestLRUCacheKeyEqual(t *testing.T) {
+	cache := NewLRUCache[string, int](1024,
+		WithHasher[string, int](func(key unsafe.Pointer, seed uintptr) (x uintptr) {
+			x = 5381
+			for _, c := range []byte(strings.ToLower(*(*string)(key))) {
+				x = x*33 + uintptr(c)
 			}
-		}
-	}()
-	_ = NewLRUCache[string, int](1024, WithSliding[string, int](true))
-	t.Errorf("should be panic above")
+			return
+		}),
+		WithKeyEqual[string, int](strings.EqualFold),
+	)
+
+	cache.Set("Foo", 1)
+
+	if v, ok := cache.Get("FOO"); !ok || v != 1 {
+		t.Fatalf("bad returned value: %v != %v", v, 1)
+	}
+
+	if _, replaced := cache.Set("foo", 2); !replaced {
+		t.Fatal("should have replaced")
+	}
+
+	if got, want := cache.Len(), 1; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+
+	if v := cache.Delete("fOO"); v != 2 {
+		t.Fatalf("bad deleted value: %v != %v", v, 2)
+	}
+}
+
+func TestLRUCacheSliding(t *testing.T) {
+	cache := NewLRUCache[string, int](1024, WithSliding[string, int](true))
+
+	cache.Set("a", 1)
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("a should be set to 1: %v", v)
+	}
 }
 
 func TestLRUCacheLoader(t *testing.T) {
@@ -348,6 +378,1557 @@ func TestLRUCacheStats(t *testing.T) {
 	}
 }
 
+func TestLRUCacheMissSampler(t *testing.T) {
+	var sampled []string
+	cache := NewLRUCache[string, int](256, WithShards[string, int](1), WithMissSampler[string, int](2, func(key string, stats Stats) {
+		sampled = append(sampled, fmt.Sprintf("%s:%d", key, stats.Misses))
+	}))
+
+	cache.Set("a", 1)
+	cache.Get("a")
+	cache.Get("x")
+	cache.Get("y")
+	cache.Get("z")
+	cache.Get("w")
+
+	if got, want := strings.Join(sampled, ","), "y:2,w:4"; got != want {
+		t.Fatalf("sampled misses should be %v: %v", want, got)
+	}
+}
+
+func TestLRUCacheKeyLock(t *testing.T) {
+	cache := NewLRUCache[string, int](1024)
+
+	if cache.KeyLock("a") != cache.KeyLock("a") {
+		t.Fatalf("key lock of a should be same")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			mu := cache.KeyLock("a")
+			mu.Lock()
+			v, _ := cache.Get("a")
+			cache.Set("a", v+1)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if v, ok := cache.Get("a"); !ok || v != 10 {
+		t.Fatalf("a should be set to 10: %v", v)
+	}
+}
+
+func TestLRUCacheLoaderBusyTimeout(t *testing.T) {
+	cache := NewLRUCache[string, int](1024,
+		WithLoader[string, int](func(ctx context.Context, key string) (int, error) {
+			time.Sleep(300 * time.Millisecond)
+			return 1, nil
+		}),
+		WithLoaderBusyTimeout[string, int](100*time.Millisecond),
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if v, err, _ := cache.GetOrLoad(context.Background(), "a", nil); err != nil || v != 1 {
+			t.Errorf("a should be loaded to 1: %v, %v", v, err)
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if v, err, _ := cache.GetOrLoad(context.Background(), "a", nil); err != nil || v != 1 {
+			t.Errorf("a should be waited to 1: %v, %v", v, err)
+		}
+	}()
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err, ok := cache.GetOrLoad(context.Background(), "a", nil); ok || err != ErrLoaderBusy {
+		t.Errorf("a should be failed with busy loader: %v, %v", err, ok)
+	}
+	wg.Wait()
+}
+
+func TestLRUCacheEvictToWatermark(t *testing.T) {
+	cache := NewLRUCache[int, int](256, WithShards[int, int](1))
+
+	for i := 0; i < 256; i++ {
+		cache.Set(i, i)
+	}
+	for i := 0; i < 10; i++ {
+		cache.Get(i)
+	}
+
+	if got, want := cache.EvictToWatermark(100), 156; got != want {
+		t.Fatalf("evicted count %v should be %v", got, want)
+	}
+
+	if got, want := cache.Len(), 100; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+
+	for i := 0; i < 10; i++ {
+		if v, ok := cache.Get(i); !ok || v != i {
+			t.Fatalf("key %v should not be evicted", i)
+		}
+	}
+
+	if got, want := cache.EvictToWatermark(200), 0; got != want {
+		t.Fatalf("evicted count %v should be %v", got, want)
+	}
+}
+
+func TestLRUCacheScanKeys(t *testing.T) {
+	cache := NewLRUCache[int, int](1024, WithShards[int, int](4))
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i)
+	}
+
+	seen := make(map[int]bool)
+	var keys []int
+	var cursor uint64
+	for {
+		keys, cursor = cache.ScanKeys(cursor, 7)
+		if len(keys) > 7 {
+			t.Fatalf("scan keys length %v should not be greater than 7", len(keys))
+		}
+		for _, k := range keys {
+			seen[k] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if got, want := len(seen), cache.Len(); got != want {
+		t.Fatalf("scanned keys length %v should be %v", got, want)
+	}
+}
+
+func TestLRUCacheDefaultShards(t *testing.T) {
+	defaultShards := DefaultShards
+	defer func() {
+		DefaultShards = defaultShards
+	}()
+
+	DefaultShards = func() uint32 { return 3 }
+
+	cache := NewLRUCache[int, int](1024)
+	if got, want := cache.mask+1, uint32(4); got != want {
+		t.Fatalf("cache shards %v should be %v", got, want)
+	}
+
+	cache = NewLRUCache[int, int](1024, WithShards[int, int](16))
+	if got, want := cache.mask+1, uint32(16); got != want {
+		t.Fatalf("cache shards %v should be %v", got, want)
+	}
+}
+
+func TestLRUCacheGetOrLoadSimple(t *testing.T) {
+	cache := NewLRUCache[string, int](1024)
+	if v, err, ok := cache.GetOrLoadSimple("a"); ok || err != ErrLoaderIsNil || v != 0 {
+		t.Errorf("cache.GetOrLoadSimple(\"a\") should be return error: %v, %v, %v", v, err, ok)
+	}
+
+	cache = NewLRUCache[string, int](1024, WithLoader[string, int](func(ctx context.Context, key string) (int, error) {
+		return int(key[0] - 'a' + 1), nil
+	}))
+
+	if v, err, ok := cache.GetOrLoadSimple("b"); ok || err != nil || v != 2 {
+		t.Errorf("cache.GetOrLoadSimple(\"b\") should be return 2: %v, %v, %v", v, err, ok)
+	}
+
+	if v, err, ok := cache.GetOrLoadSimple("b"); !ok || err != nil || v != 2 {
+		t.Errorf("cache.GetOrLoadSimple(\"b\") again should be return 2: %v, %v, %v", v, err, ok)
+	}
+}
+
+func TestLRUCacheHashed(t *testing.T) {
+	cache := NewLRUCache[string, int](1024)
+
+	hash := cache.Hash("a")
+	if hash != cache.Hash("a") {
+		t.Fatalf("hash of a should be stable")
+	}
+
+	if v, ok := cache.GetHashed(hash, "a"); ok {
+		t.Fatalf("a should not be set: %v", v)
+	}
+
+	cache.SetHashed(hash, "a", 1)
+
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("a should be set to 1: %v", v)
+	}
+
+	cache.Set("a", 2)
+
+	if v, ok := cache.GetHashed(hash, "a"); !ok || v != 2 {
+		t.Fatalf("a should be set to 2: %v", v)
+	}
+}
+
+func TestLRUCacheForEachParallel(t *testing.T) {
+	cache := NewLRUCache[int, int](1024, WithShards[int, int](16))
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i)
+	}
+
+	var count int64
+	err := cache.ForEachParallel(context.Background(), 4, func(key int, value int) error {
+		if key != value {
+			return fmt.Errorf("bad value of key %v: %v", key, value)
+		}
+		atomic.AddInt64(&count, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("for each parallel error: %v", err)
+	}
+	if got, want := int(count), cache.Len(); got != want {
+		t.Fatalf("for each count %v should be %v", got, want)
+	}
+
+	errStop := fmt.Errorf("stop")
+	err = cache.ForEachParallel(context.Background(), 4, func(key int, value int) error {
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("for each parallel error should be %v: %v", errStop, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = cache.ForEachParallel(ctx, 4, func(key int, value int) error {
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("for each parallel error should be %v: %v", context.Canceled, err)
+	}
+}
+
+func TestLRUCacheEvictionOrder(t *testing.T) {
+	cache := NewLRUCache[int, int](8, WithShards[int, int](1))
+
+	for i := 0; i < 8; i++ {
+		cache.Set(i, i)
+	}
+	cache.Get(0)
+	cache.Delete(1)
+
+	if got, want := fmt.Sprint(cache.EvictionOrder(3)), "[2 3 4]"; got != want {
+		t.Fatalf("eviction order should be %v: %v", want, got)
+	}
+
+	cache.Set(8, 8)
+	cache.Set(9, 9)
+
+	if got, want := fmt.Sprint(cache.EvictionOrder(3)), "[3 4 5]"; got != want {
+		t.Fatalf("eviction order should be %v: %v", want, got)
+	}
+}
+
+func TestLRUCacheShardOldestNewest(t *testing.T) {
+	cache := NewLRUCache[int, int](32, WithShards[int, int](4))
+
+	for shard := -1; shard <= 4; shard++ {
+		if _, _, ok := cache.ShardOldest(shard); ok {
+			t.Fatalf("empty shard %v should have no oldest entry", shard)
+		}
+	}
+
+	// the keys of one shard, in the order they are set.
+	var keys []int
+	for i := 0; len(keys) < 4; i++ {
+		if cache.ShardOf(i) == 2 {
+			keys = append(keys, i)
+		}
+	}
+	for _, i := range keys {
+		cache.Set(i, i*10)
+	}
+	cache.Get(keys[0])
+	cache.Delete(keys[1])
+
+	if k, v, ok := cache.ShardOldest(2); !ok || k != keys[2] || v != keys[2]*10 {
+		t.Fatalf("oldest entry of shard 2 should be %v: %v %v %v", keys[2], k, v, ok)
+	}
+	if k, v, ok := cache.ShardNewest(2); !ok || k != keys[0] || v != keys[0]*10 {
+		t.Fatalf("newest entry of shard 2 should be %v: %v %v %v", keys[0], k, v, ok)
+	}
+	if _, _, ok := cache.ShardNewest(1); ok {
+		t.Fatalf("shard 1 should have no newest entry")
+	}
+}
+
+func TestLRUCachePin(t *testing.T) {
+	for _, policy := range []Policy{PolicyLRU, PolicySLRU} {
+		cache := NewLRUCache[int, int](8, WithShards[int, int](1), WithPolicy[int, int](policy))
+
+		for i := 0; i < 8; i++ {
+			cache.Set(i, i)
+		}
+		if !cache.Pin(0) || !cache.Pin(1) {
+			t.Fatalf("policy %v: 0 and 1 should be pinned", policy)
+		}
+		if cache.Pin(100) {
+			t.Fatalf("policy %v: absent key should not be pinned", policy)
+		}
+
+		for i := 8; i < 64; i++ {
+			cache.Set(i, i)
+		}
+		for i := 0; i < 2; i++ {
+			if v, ok := cache.Peek(i); !ok || v != i {
+				t.Fatalf("policy %v: pinned %v should not be evicted: %v %v", policy, i, v, ok)
+			}
+		}
+
+		cache.Unpin(0)
+		for i := 64; i < 128; i++ {
+			cache.Set(i, i)
+		}
+		if _, ok := cache.Peek(0); ok {
+			t.Fatalf("policy %v: unpinned 0 should be evicted", policy)
+		}
+		if _, ok := cache.Peek(1); !ok {
+			t.Fatalf("policy %v: pinned 1 should not be evicted", policy)
+		}
+	}
+}
+
+func TestLRUCachePinEvictWeight(t *testing.T) {
+	for _, policy := range []Policy{PolicyLRU, PolicySLRU} {
+		cache := NewLRUCache[string, int](4, WithShards[string, int](1), WithPolicy[string, int](policy),
+			WithWeigher[string, int](func(key string, value int) int { return value }, 10))
+
+		cache.Set("a", 3)
+		cache.Pin("a")
+		cache.Set("b", 3)
+		cache.Set("c", 3)
+		cache.Set("d", 5)
+		cache.Set("e", 1)
+
+		if v, ok := cache.Get("a"); !ok || v != 3 {
+			t.Fatalf("policy %v: pinned a should not be evicted: %v %v", policy, v, ok)
+		}
+		if v, ok := cache.Get("e"); !ok || v != 1 {
+			t.Fatalf("policy %v: e should be set: %v %v", policy, v, ok)
+		}
+		keys := cache.AppendKeys(nil)
+		sort.Strings(keys)
+		if len(keys) != cache.Len() || strings.Join(keys, "") != "ade" {
+			t.Fatalf("policy %v: keys should be [a d e]: %v, len %v", policy, keys, cache.Len())
+		}
+	}
+}
+
+func TestLRUCachePinEvictToWatermark(t *testing.T) {
+	for _, policy := range []Policy{PolicyLRU, PolicySLRU} {
+		cache := NewLRUCache[string, int](4, WithShards[string, int](1), WithPolicy[string, int](policy))
+
+		cache.Set("a", 1)
+		cache.Pin("a")
+		cache.Set("b", 2)
+		cache.Set("c", 3)
+		cache.Set("d", 4)
+
+		if n := cache.EvictToWatermark(2); n != 2 {
+			t.Fatalf("policy %v: 2 entries should be evicted: %v", policy, n)
+		}
+		cache.Set("e", 5)
+
+		if v, ok := cache.Get("a"); !ok || v != 1 {
+			t.Fatalf("policy %v: pinned a should not be evicted: %v %v", policy, v, ok)
+		}
+		keys := cache.AppendKeys(nil)
+		sort.Strings(keys)
+		if len(keys) != cache.Len() || strings.Join(keys, "") != "ade" {
+			t.Fatalf("policy %v: keys should be [a d e]: %v, len %v", policy, keys, cache.Len())
+		}
+	}
+}
+
+func TestLRUCacheSetIf(t *testing.T) {
+	cache := NewLRUCache[string, int](128)
+
+	newer := func(version int) func(int, bool) bool {
+		return func(old int, exists bool) bool { return !exists || version > old }
+	}
+
+	if !cache.SetIf("a", 1, newer(1)) {
+		t.Fatalf("a should be set as absent")
+	}
+	if cache.SetIf("a", 0, newer(0)) {
+		t.Fatalf("a should not be overwritten by older version")
+	}
+	if !cache.SetIf("a", 2, newer(2)) {
+		t.Fatalf("a should be overwritten by newer version")
+	}
+	if v, _ := cache.Get("a"); v != 2 {
+		t.Fatalf("a should be 2: %v", v)
+	}
+}
+
+func TestLRUCacheEvictionCallback(t *testing.T) {
+	evicted := make(map[string]int)
+	cache := NewLRUCache[string, int](4, WithShards[string, int](1), WithEvictionCallback(func(key string, value int) {
+		evicted[key] = value
+	}))
+
+	for i := 0; i < 6; i++ {
+		cache.Set(fmt.Sprint(i), i)
+	}
+	cache.Delete("5")
+	cache.Set("5", 5)
+
+	if got, want := fmt.Sprint(evicted), "map[0:0 1:1]"; got != want {
+		t.Fatalf("evicted entries %v should be %v", got, want)
+	}
+
+	cache.EvictToWatermark(3)
+	if got, want := fmt.Sprint(evicted), "map[0:0 1:1 2:2]"; got != want {
+		t.Fatalf("evicted entries %v should be %v", got, want)
+	}
+}
+
+func TestLRUCacheSeed(t *testing.T) {
+	newCache := func() *LRUCache[string, int] {
+		cache := NewLRUCache[string, int](1024, WithShards[string, int](4), WithSeed[string, int](42))
+		for i := 0; i < 1024; i++ {
+			cache.Set(fmt.Sprint(i), i)
+		}
+		return cache
+	}
+
+	if got, want := fmt.Sprint(newCache().AppendKeys(nil)), fmt.Sprint(newCache().AppendKeys(nil)); got != want {
+		t.Fatalf("keys order %v should be %v", got, want)
+	}
+}
+
+func TestLRUCacheClear(t *testing.T) {
+	cache := NewLRUCache[string, int](128, WithShards[string, int](4))
+
+	for i := 0; i < 256; i++ {
+		cache.Set(fmt.Sprint(i), i)
+	}
+	cache.Clear()
+
+	if got, want := cache.Len(), 0; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+	if v, ok := cache.Get("255"); ok {
+		t.Fatalf("255 should be cleared: %v", v)
+	}
+
+	for i := 0; i < 256; i++ {
+		cache.Set(fmt.Sprint(i), i)
+	}
+	for i := uint32(0); i <= cache.mask; i++ {
+		checkLRUShardList(t, &cache.shards[i])
+	}
+	if got, want := cache.Len(), 128; got > want {
+		t.Fatalf("cache length %v should be at most %v", got, want)
+	}
+}
+
+func TestLRUCacheContains(t *testing.T) {
+	cache := NewLRUCache[string, int](2, WithShards[string, int](1))
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+
+	if !cache.Contains("a") {
+		t.Fatalf("a should be contained")
+	}
+
+	// Contains does not promote a, so it is evicted.
+	cache.Set("c", 3)
+	if cache.Contains("a") {
+		t.Fatalf("a should be evicted")
+	}
+}
+
+func TestLRUCacheLink(t *testing.T) {
+	cache := NewLRUCache[string, int](128)
+
+	for i, key := range []string{"page", "header", "footer", "logo", "other"} {
+		cache.Set(key, i)
+	}
+	cache.Link("page", "header")
+	cache.Link("page", "footer")
+	cache.Link("header", "logo")
+	cache.Link("logo", "page")
+
+	cache.Delete("page")
+
+	for key, want := range map[string]bool{"page": false, "header": false, "footer": false, "logo": false, "other": true} {
+		if got := cache.Contains(key); got != want {
+			t.Fatalf("%v should be present %v", key, want)
+		}
+	}
+}
+
+func TestLRUCacheLinkEvicted(t *testing.T) {
+	cache := NewLRUCache[int, int](4, WithShards[int, int](1))
+
+	cache.Set(0, 0)
+	cache.Set(1, 1)
+	cache.Link(0, 1)
+	for i := 2; i < 6; i++ {
+		cache.Set(i, i)
+	}
+	cache.Set(0, 0)
+	cache.Set(1, 1)
+
+	cache.Delete(0)
+	if !cache.Contains(1) {
+		t.Fatalf("1 should not be deleted by the evicted parent")
+	}
+
+	for i := 0; i < 8*minIndexLimit; i++ {
+		cache.Set(i, i)
+		cache.Link(i, i+1)
+	}
+	if got, max := len(cache.links.children), 2*minIndexLimit+1; got > max {
+		t.Fatalf("linked parents %v should be pruned to at most %v", got, max)
+	}
+}
+
+func TestLRUCacheIndex(t *testing.T) {
+	type session struct {
+		user string
+	}
+
+	cache := NewLRUCache[string, session](128, WithIndex[string, session]("user", func(s session) string { return s.user }))
+
+	cache.Set("token1", session{"alice"})
+	cache.Set("token2", session{"bob"})
+	cache.Set("token3", session{"alice"})
+	cache.Set("token3", session{"bob"})
+	cache.Delete("token2")
+
+	if keys, values := cache.GetByIndex("user", "alice"); fmt.Sprint(keys, values) != "[token1] [{alice}]" {
+		t.Fatalf("alice sessions should be token1: %v %v", keys, values)
+	}
+	if keys, _ := cache.GetByIndex("user", "bob"); fmt.Sprint(keys) != "[token3]" {
+		t.Fatalf("bob sessions should be token3: %v", keys)
+	}
+	if keys, _ := cache.GetByIndex("nonexists", "bob"); len(keys) != 0 {
+		t.Fatalf("nonexists index should be empty: %v", keys)
+	}
+
+	// the evicted keys are pruned from the index.
+	for i := 0; i < 10000; i++ {
+		cache.Set(fmt.Sprint(i), session{"carol"})
+	}
+	if n := len(cache.indexes["user"].attrs); n > 2*minIndexLimit {
+		t.Fatalf("index length %v should be pruned", n)
+	}
+}
+
+func TestLRUCacheGetOrSet(t *testing.T) {
+	cache := NewLRUCache[string, int](128)
+
+	var wg sync.WaitGroup
+	var stored int32
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, loaded := cache.GetOrSet("a", i); !loaded {
+				atomic.AddInt32(&stored, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stored != 1 {
+		t.Fatalf("a should be stored once: %v", stored)
+	}
+	v, _ := cache.Get("a")
+	if actual, loaded := cache.GetOrSet("a", 42); !loaded || actual != v {
+		t.Fatalf("a should be loaded as %v: %v %v", v, actual, loaded)
+	}
+}
+
+func TestLRUCacheCollectStats(t *testing.T) {
+	cache := NewLRUCache[string, int](128)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			cache.Set(fmt.Sprint(i), i)
+			cache.Get(fmt.Sprint(i))
+		}
+	}()
+
+	var stats Stats
+	for i := 0; i < 100; i++ {
+		cache.CollectStats(&stats)
+	}
+	wg.Wait()
+
+	cache.CollectStats(&stats)
+	if got, want := stats, cache.Stats(); got != want {
+		t.Fatalf("collected stats %+v should be %+v", got, want)
+	}
+	if got, want := stats.SetCalls, uint64(1000); got != want {
+		t.Fatalf("set calls %v should be %v", got, want)
+	}
+	if n := testing.AllocsPerRun(100, func() { cache.CollectStats(&stats) }); n != 0 {
+		t.Fatalf("CollectStats should not allocate: %v", n)
+	}
+}
+
+func TestLRUCacheCompute(t *testing.T) {
+	cache := NewLRUCache[string, int](128)
+
+	incr := func(old int, exists bool) (int, bool) { return old + 1, true }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cache.Compute("counter", incr)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, ok := cache.Get("counter"); !ok || v != 1000 {
+		t.Fatalf("counter should be 1000: %v %v", v, ok)
+	}
+
+	if _, ok := cache.Compute("counter", func(old int, exists bool) (int, bool) { return 0, false }); ok {
+		t.Fatalf("counter should be deleted")
+	}
+	if cache.Contains("counter") {
+		t.Fatalf("counter should not be contained")
+	}
+}
+
+func TestLRUCacheSizeError(t *testing.T) {
+	for _, size := range []int{-1, MaxShardSize + 1} {
+		func() {
+			defer func() {
+				var err *SizeError
+				if e, ok := recover().(error); !ok || !errors.As(e, &err) || err.Size != size {
+					t.Fatalf("NewLRUCache(%v) should panic with SizeError: %v", size, e)
+				}
+			}()
+			NewLRUCache[int, int](size, WithShards[int, int](1))
+		}()
+	}
+}
+
+func TestLRUCacheTryNew(t *testing.T) {
+	for _, size := range []int{-1, MaxShardSize + 1} {
+		cache, err := TryNewLRUCache[int, int](size, WithShards[int, int](1))
+		var serr *SizeError
+		if cache != nil || !errors.As(err, &serr) || serr.Size != size || serr.Shards != 1 {
+			t.Fatalf("TryNewLRUCache(%v) should return SizeError: %v %v", size, cache, err)
+		}
+	}
+
+	cache, err := TryNewLRUCache[int, int](1024, WithShards[int, int](4))
+	if err != nil {
+		t.Fatalf("TryNewLRUCache(1024) should not return error: %v", err)
+	}
+	cache.Set(1, 10)
+	if v, ok := cache.Get(1); !ok || v != 10 {
+		t.Fatalf("cache.Get(1) should return 10: %v %v", v, ok)
+	}
+}
+
+func TestLRUCacheGetMulti(t *testing.T) {
+	cache := NewLRUCache[string, int](1024, WithShards[string, int](8))
+
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprint(i))
+		if i%2 == 0 {
+			cache.Set(keys[i], i)
+		}
+	}
+
+	values := cache.GetMulti(keys)
+	if got, want := len(values), 50; got != want {
+		t.Fatalf("values length %v should be %v", got, want)
+	}
+	for i, key := range keys {
+		if v, ok := values[key]; ok != (i%2 == 0) || (ok && v != i) {
+			t.Fatalf("value of %v should be %v: %v %v", key, i, v, ok)
+		}
+	}
+	if got, want := cache.Stats().GetCalls, uint64(100); got != want {
+		t.Fatalf("get calls %v should be %v", got, want)
+	}
+}
+
+func TestLRUCacheGetOrLoadBypass(t *testing.T) {
+	var loads int32
+	loader := func(ctx context.Context, key string) (int, error) {
+		n := atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return int(n), nil
+	}
+
+	cache := NewLRUCache[string, int](128, WithLoader[string, int](loader))
+
+	if v, err, _ := cache.GetOrLoad(context.Background(), "a", nil); err != nil || v != 1 {
+		t.Fatalf("a should be loaded: %v %+v", v, err)
+	}
+	if v, err, _ := cache.GetOrLoadBypass(context.Background(), "a", nil); err != nil || v != 2 {
+		t.Fatalf("a should be reloaded: %v %+v", v, err)
+	}
+	if v, ok := cache.Get("a"); !ok || v != 2 {
+		t.Fatalf("a should be overwritten: %v %v", v, ok)
+	}
+
+	// concurrent bypasses are collapsed.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.GetOrLoadBypass(context.Background(), "a", nil)
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&loads); got < 3 || got > 4 {
+		t.Fatalf("loads %v should be collapsed", got)
+	}
+}
+
+func TestLRUCacheGetMultiOrLoad(t *testing.T) {
+	cache := NewLRUCache[string, int](1024, WithShards[string, int](8))
+
+	cache.Set("a", 1)
+
+	var loaded []string
+	values, err := cache.GetMultiOrLoad(context.Background(), []string{"a", "b", "c"}, func(ctx context.Context, keys []string) (entries []Entry[string, int], err error) {
+		loaded = append(loaded, keys...)
+		for _, key := range keys {
+			entries = append(entries, Entry[string, int]{Key: key, Value: int(key[0] - 'a' + 1)})
+		}
+		return
+	})
+	if err != nil {
+		t.Fatalf("get multi or load error: %+v", err)
+	}
+	if got, want := fmt.Sprint(loaded), "[b c]"; got != want {
+		t.Fatalf("loaded keys %v should be %v", got, want)
+	}
+	if got, want := fmt.Sprint(values), "map[a:1 b:2 c:3]"; got != want {
+		t.Fatalf("values %v should be %v", got, want)
+	}
+	if v, ok := cache.Get("c"); !ok || v != 3 {
+		t.Fatalf("c should be set: %v %v", v, ok)
+	}
+}
+
+func TestLRUCacheSetMulti(t *testing.T) {
+	cache := NewLRUCache[string, int](1024, WithShards[string, int](8))
+
+	var entries []Entry[string, int]
+	for i := 0; i < 100; i++ {
+		entries = append(entries, Entry[string, int]{Key: fmt.Sprint(i), Value: i})
+	}
+	entries = append(entries, Entry[string, int]{Key: "0", Value: 100})
+	cache.SetMulti(entries)
+
+	if got, want := cache.Len(), 100; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+	if v, _ := cache.Get("0"); v != 100 {
+		t.Fatalf("the later entry of 0 should win: %v", v)
+	}
+	if v, _ := cache.Get("99"); v != 99 {
+		t.Fatalf("99 should be set: %v", v)
+	}
+}
+
+func TestLRUCacheDeleteIf(t *testing.T) {
+	cache := NewLRUCache[string, int](1024, WithShards[string, int](4))
+
+	for i := 0; i < 500; i++ {
+		cache.Set(fmt.Sprintf("tenant%d:%d", i%5, i), i)
+	}
+
+	deleted := cache.DeleteIf(func(key string, value int) bool {
+		return strings.HasPrefix(key, "tenant1:")
+	})
+	if got, want := deleted, 100; got != want {
+		t.Fatalf("deleted %v should be %v", got, want)
+	}
+	if got, want := cache.Len(), 400; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+	for _, key := range cache.AppendKeys(nil) {
+		if strings.HasPrefix(key, "tenant1:") {
+			t.Fatalf("%v should be deleted", key)
+		}
+	}
+	for i := uint32(0); i <= cache.mask; i++ {
+		checkLRUShardList(t, &cache.shards[i])
+	}
+}
+
+func TestLRUCacheShadowPolicy(t *testing.T) {
+	for _, policy := range []ShadowPolicy{ShadowSIEVE, ShadowFIFO} {
+		cache := NewLRUCache[int, int](100, WithShards[int, int](1), WithShadowPolicy[int, int](policy))
+
+		// a hot set accessed by rounds, interleaved with one-time scans which flush the lru.
+		scan := 1000
+		for round := 0; round < 20; round++ {
+			for i := 0; i < 100; i++ {
+				if _, ok := cache.Get(i % 50); !ok {
+					cache.Set(i%50, i%50)
+				}
+			}
+			for i := 0; i < 100; i++ {
+				if _, ok := cache.Get(scan); !ok {
+					cache.Set(scan, scan)
+				}
+				scan++
+			}
+		}
+
+		stats := cache.Stats()
+		if got, want := stats.Misses, uint64(20*150); got != want {
+			t.Fatalf("lru misses %v should be %v", got, want)
+		}
+		switch policy {
+		case ShadowSIEVE:
+			if stats.ShadowMisses >= stats.Misses {
+				t.Fatalf("sieve misses %v should be less than lru %v", stats.ShadowMisses, stats.Misses)
+			}
+		case ShadowFIFO:
+			if stats.ShadowMisses != stats.Misses {
+				t.Fatalf("fifo misses %v should be equal to lru %v", stats.ShadowMisses, stats.Misses)
+			}
+		}
+	}
+}
+
+func TestLRUCacheWeigher(t *testing.T) {
+	var evicted []string
+	cache := NewLRUCache[string, string](100,
+		WithShards[string, string](1),
+		WithWeigher(func(key string, value string) int { return len(value) }, 10),
+		WithEvictionCallback(func(key string, value string) { evicted = append(evicted, key) }),
+	)
+
+	cache.Set("a", "aaa")
+	cache.Set("b", "bbb")
+	cache.Set("c", "ccc")
+	cache.Set("d", "ddd")
+	cache.Get("b")
+	cache.Set("e", "eeee")
+	if got, want := fmt.Sprint(evicted), "[a c]"; got != want {
+		t.Fatalf("evicted keys %v should be %v", got, want)
+	}
+	if got, want := cache.Weight(), int64(10); got != want {
+		t.Fatalf("cache weight %v should be %v", got, want)
+	}
+
+	cache.Set("e", "e")
+	if got, want := cache.Weight(), int64(7); got != want {
+		t.Fatalf("cache weight %v should be %v after replacing", got, want)
+	}
+
+	// the entry heavier than the budget is rejected, and the previous value is deleted.
+	cache.Set("f", "fffffffffff")
+	cache.Set("d", "ddddddddddd")
+	if cache.Contains("f") || cache.Contains("d") {
+		t.Fatalf("heavy entries should be rejected")
+	}
+	if got, want := cache.Weight(), int64(4); got != want {
+		t.Fatalf("cache weight %v should be %v after rejecting", got, want)
+	}
+
+	cache.Delete("b")
+	if got, want := cache.Weight(), int64(1); got != want {
+		t.Fatalf("cache weight %v should be %v after deleting", got, want)
+	}
+	checkLRUShardList(t, &cache.shards[0])
+
+	cache.Clear()
+	if got, want := cache.Weight(), int64(0); got != want {
+		t.Fatalf("cache weight %v should be %v after clearing", got, want)
+	}
+}
+
+func TestLRUCacheOversizePolicy(t *testing.T) {
+	weigher := func(key string, value string) int { return len(value) }
+
+	cache := NewLRUCache[string, string](100, WithShards[string, string](1), WithWeigher(weigher, 10))
+	cache.Set("a", "aaa")
+	if _, _, err := cache.TrySet("b", "bbb"); err != nil {
+		t.Fatalf("b should be set: %v", err)
+	}
+	_, _, err := cache.TrySet("a", "aaaaaaaaaaa")
+	if e, ok := err.(*OversizeError); !ok || e.Size != 11 || e.Budget != 10 {
+		t.Fatalf("heavy a should be rejected by *OversizeError: %v", err)
+	}
+	if cache.Contains("a") || !cache.Contains("b") {
+		t.Fatalf("heavy a should be deleted and b should be kept")
+	}
+
+	cache = NewLRUCache[string, string](100, WithShards[string, string](1), WithOversizePolicy[string, string](OversizeEvictAll), WithWeigher(weigher, 10))
+	cache.Set("a", "aaa")
+	cache.Set("b", "bbb")
+	if _, _, err := cache.TrySet("c", "ccccccccccc"); err != nil {
+		t.Fatalf("heavy c should be set: %v", err)
+	}
+	if !cache.Contains("c") || cache.Len() != 1 {
+		t.Fatalf("heavy c should evict all other entries: %v", cache.AppendKeys(nil))
+	}
+	if got, want := cache.Weight(), int64(11); got != want {
+		t.Fatalf("cache weight %v should be %v", got, want)
+	}
+	cache.Set("d", "ddd")
+	if cache.Contains("c") || !cache.Contains("d") {
+		t.Fatalf("heavy c should be evicted by d")
+	}
+}
+
+func TestLRUCacheTinyLFU(t *testing.T) {
+	for _, c := range []struct {
+		options []Option[int, int]
+		hot     int
+	}{
+		{[]Option[int, int]{WithShards[int, int](1)}, 0},
+		{[]Option[int, int]{WithShards[int, int](1), WithTinyLFU[int, int]()}, 90},
+	} {
+		cache := NewLRUCache[int, int](100, c.options...)
+
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 100; i++ {
+				if _, ok := cache.Get(i); !ok {
+					cache.Set(i, i)
+				}
+			}
+		}
+		// one-hit keys
+		for i := 100; i < 1000; i++ {
+			cache.Set(i, i)
+		}
+
+		hot := 0
+		for i := 0; i < 100; i++ {
+			if cache.Contains(i) {
+				hot++
+			}
+		}
+		if hot < c.hot || (c.hot == 0 && hot != 0) {
+			t.Fatalf("hot keys %v should be at least %v", hot, c.hot)
+		}
+		checkLRUShardList(t, &cache.shards[0])
+	}
+
+	cache := NewLRUCache[int, int](100, WithShards[int, int](1), WithTinyLFU[int, int]())
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+	// a new key is admitted after it becomes more frequent than the victim.
+	for i := 0; i < 4 && !cache.Contains(1000); i++ {
+		if _, ok := cache.Get(1000); !ok {
+			cache.Set(1000, 1000)
+		}
+	}
+	if !cache.Contains(1000) {
+		t.Fatalf("frequent key should be admitted")
+	}
+}
+
+func TestLRUCacheSLRU(t *testing.T) {
+	for _, c := range []struct {
+		policy Policy
+		hot    int
+	}{
+		{PolicyLRU, 0},
+		{PolicySLRU, 50},
+	} {
+		cache := NewLRUCache[int, int](100, WithShards[int, int](1), WithPolicy[int, int](c.policy))
+
+		for i := 0; i < 50; i++ {
+			cache.Set(i, i)
+			cache.Get(i)
+		}
+		// scan of one-off keys
+		for i := 100; i < 1000; i++ {
+			cache.Set(i, i)
+			if i%100 == 0 {
+				cache.Delete(i - 1)
+			}
+		}
+
+		hot := 0
+		for i := 0; i < 50; i++ {
+			if cache.Contains(i) {
+				hot++
+			}
+		}
+		if hot != c.hot {
+			t.Fatalf("hot keys of policy %v %v should be %v", c.policy, hot, c.hot)
+		}
+		checkLRUShardList(t, &cache.shards[0])
+	}
+
+	cache := NewLRUCache[int, int](10, WithShards[int, int](1), WithPolicy[int, int](PolicySLRU))
+	for i := 0; i < 100; i++ {
+		cache.Set(rand.Intn(20), i)
+		cache.Get(rand.Intn(20))
+		if i%10 == 0 {
+			cache.Delete(rand.Intn(20))
+		}
+	}
+	// the protected segment is the list front.
+	s := &cache.shards[0]
+	index := s.list[0].next
+	for n := uint32(0); n < s.policy.count; n++ {
+		if !s.policy.isProtected(index) {
+			t.Fatalf("list node %v at %v should be protected", index, n)
+		}
+		if n == s.policy.count-1 && index != s.policy.boundary {
+			t.Fatalf("protected boundary %v should be %v", s.policy.boundary, index)
+		}
+		index = s.list[index].next
+	}
+	for ; index != 0; index = s.list[index].next {
+		if s.policy.isProtected(index) {
+			t.Fatalf("list node %v should be in probation", index)
+		}
+	}
+	checkLRUShardList(t, s)
+}
+
+func TestLRUCacheRange(t *testing.T) {
+	cache := NewLRUCache[string, int](1024, WithShards[string, int](4))
+
+	for i := 0; i < 500; i++ {
+		cache.Set(fmt.Sprint(i), i)
+	}
+
+	for _, batch := range []int{0, 1, 7, 4096} {
+		seen := make(map[string]int)
+		cache.Range(batch, func(key string, value int) bool {
+			seen[key] = value
+			return true
+		})
+		if got, want := len(seen), 500; got != want {
+			t.Fatalf("range with batch %v visited %v entries, should be %v", batch, got, want)
+		}
+		for key, value := range seen {
+			if fmt.Sprint(value) != key {
+				t.Fatalf("value of %v should be %v", key, value)
+			}
+		}
+	}
+
+	n := 0
+	cache.Range(7, func(key string, value int) bool {
+		n++
+		return n < 10
+	})
+	if got, want := n, 10; got != want {
+		t.Fatalf("range should stop after %v entries, got %v", want, got)
+	}
+}
+
+func TestLRUCachePromoteAfter(t *testing.T) {
+	cache := NewLRUCache[int, int](4, WithShards[int, int](1), WithPromoteAfter[int, int](2))
+
+	for i := 1; i <= 4; i++ {
+		cache.Set(i, i)
+	}
+	// the first hits do not move the entries
+	cache.Get(1)
+	cache.Get(2)
+	if got, want := fmt.Sprint(cache.EvictionOrder(10)), "[1 2 3 4]"; got != want {
+		t.Fatalf("eviction order %v should be %v", got, want)
+	}
+
+	// the second hit promotes 1, and the later hits keep moving it
+	cache.Get(1)
+	if got, want := fmt.Sprint(cache.EvictionOrder(10)), "[2 3 4 1]"; got != want {
+		t.Fatalf("eviction order %v should be %v", got, want)
+	}
+	cache.Get(4)
+	cache.Get(1)
+	if got, want := fmt.Sprint(cache.EvictionOrder(10)), "[2 3 4 1]"; got != want {
+		t.Fatalf("eviction order %v should be %v", got, want)
+	}
+
+	// the hit count of a reused node starts over
+	cache.Set(5, 5)
+	cache.Get(4)
+	cache.Get(5)
+	if got, want := fmt.Sprint(cache.EvictionOrder(10)), "[3 1 5 4]"; got != want {
+		t.Fatalf("eviction order %v should be %v", got, want)
+	}
+	checkLRUShardList(t, &cache.shards[0])
+}
+
+func TestLRUCacheResetStats(t *testing.T) {
+	cache := NewLRUCache[string, int](128)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			cache.Set(fmt.Sprint(i), i)
+			cache.Get(fmt.Sprint(i))
+		}
+	}()
+
+	var total Stats
+	for i := 0; i < 100; i++ {
+		stats := cache.ResetStats()
+		total.GetCalls += stats.GetCalls
+		total.SetCalls += stats.SetCalls
+	}
+	wg.Wait()
+
+	stats := cache.ResetStats()
+	total.GetCalls += stats.GetCalls
+	total.SetCalls += stats.SetCalls
+	if got, want := total.SetCalls, uint64(1000); got != want {
+		t.Fatalf("set calls %v should be %v", got, want)
+	}
+	if got, want := total.GetCalls, uint64(1000); got != want {
+		t.Fatalf("get calls %v should be %v", got, want)
+	}
+	if got, want := stats.EntriesCount, uint64(cache.Len()); got != want {
+		t.Fatalf("entries count %v should be %v", got, want)
+	}
+	if got, want := cache.Stats(), (Stats{EntriesCount: stats.EntriesCount}); got != want {
+		t.Fatalf("stats after reset %+v should be %+v", got, want)
+	}
+}
+
+func TestLRUCacheShardStats(t *testing.T) {
+	cache := NewLRUCache[string, int](1024, WithShards[string, int](4))
+
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprint(i), i)
+		cache.Get(fmt.Sprint(i * 2))
+	}
+
+	shards := cache.ShardStats()
+	if got, want := len(shards), 4; got != want {
+		t.Fatalf("shard stats length %v should be %v", got, want)
+	}
+	var total Stats
+	for _, stats := range shards {
+		total.GetCalls += stats.GetCalls
+		total.SetCalls += stats.SetCalls
+		total.Misses += stats.Misses
+		total.EntriesCount += stats.EntriesCount
+	}
+	if got, want := total, cache.Stats(); got != want {
+		t.Fatalf("total of shard stats %+v should be %+v", got, want)
+	}
+}
+
+func TestLRUCacheReadTransform(t *testing.T) {
+	var calls int
+	cache := NewLRUCache[string, string](128, WithReadTransform[string, string](func(value string) string {
+		calls++
+		return strings.ToUpper(value)
+	}))
+
+	cache.Set("a", "foo")
+	for i := 0; i < 3; i++ {
+		if value, ok := cache.Get("a"); !ok || value != "FOO" {
+			t.Fatalf("a should be FOO: %v %v", value, ok)
+		}
+	}
+	if got, want := calls, 1; got != want {
+		t.Fatalf("transform calls should be %v: %v", want, got)
+	}
+	if value, _ := cache.Peek("a"); value != "FOO" {
+		t.Fatalf("peek a should be FOO: %v", value)
+	}
+
+	// setting the value again transforms it again.
+	cache.Set("a", "bar")
+	if value, _ := cache.Peek("a"); value != "BAR" {
+		t.Fatalf("peek a should be BAR: %v", value)
+	}
+	if value, ok := cache.GetHashed(cache.Hash("a"), "a"); !ok || value != "BAR" {
+		t.Fatalf("a should be BAR: %v %v", value, ok)
+	}
+	if got, want := calls, 2; got != want {
+		t.Fatalf("transform calls should be %v: %v", want, got)
+	}
+
+	if _, ok := cache.Get("b"); ok {
+		t.Fatalf("b should not exist")
+	}
+}
+
+func TestLRUCacheReadTransformReads(t *testing.T) {
+	var evicted []string
+	cache := NewLRUCache[string, string](1, WithShards[string, string](1),
+		WithReadTransform[string, string](func(value string) string { return value + "!" }),
+		WithEvictionCallback[string, string](func(key string, value string) { evicted = append(evicted, value) }))
+
+	loader := func(ctx context.Context, key string) (string, error) {
+		return "loaded", nil
+	}
+
+	cache.Set("a", "x")
+	for i := 0; i < 2; i++ {
+		// the reads return the same value before and after the first Get.
+		if value, ok := cache.Peek("a"); !ok || value != "x!" {
+			t.Fatalf("peek a should be x!: %v %v", value, ok)
+		}
+		if value, err, _ := cache.GetOrLoad(context.Background(), "a", loader); err != nil || value != "x!" {
+			t.Fatalf("get or load a should be x!: %v %v", value, err)
+		}
+		if values := cache.GetMulti([]string{"a"}); values["a"] != "x!" {
+			t.Fatalf("get multi a should be x!: %v", values)
+		}
+		if value, _, ok := cache.GetWithGeneration("a"); !ok || value != "x!" {
+			t.Fatalf("get with generation a should be x!: %v %v", value, ok)
+		}
+		if value, loaded := cache.GetOrSet("a", "y"); !loaded || value != "x!" {
+			t.Fatalf("get or set a should be x!: %v %v", value, loaded)
+		}
+		if value, ok := cache.Get("a"); !ok || value != "x!" {
+			t.Fatalf("a should be x!: %v %v", value, ok)
+		}
+	}
+
+	// the writes and the eviction callback see the stored value.
+	if prev, replaced := cache.Set("a", "z"); !replaced || prev != "x" {
+		t.Fatalf("previous a should be x: %v %v", prev, replaced)
+	}
+	if value, err, _ := cache.GetOrLoad(context.Background(), "b", loader); err != nil || value != "loaded!" {
+		t.Fatalf("loaded b should be loaded!: %v %v", value, err)
+	}
+	if got, want := fmt.Sprint(evicted), "[z]"; got != want {
+		t.Fatalf("evicted values %v should be %v", got, want)
+	}
+	if value, loaded := cache.GetOrSet("c", "w"); loaded || value != "w!" {
+		t.Fatalf("stored c should be w!: %v %v", value, loaded)
+	}
+}
+
+func TestLRUCacheShardOf(t *testing.T) {
+	cache := NewLRUCache[string, int](1024, WithShards[string, int](8), WithSeed[string, int](42))
+	other := NewLRUCache[string, int](1024, WithShards[string, int](8), WithSeed[string, int](42))
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		shard := cache.ShardOf(key)
+		if shard < 0 || shard >= 8 {
+			t.Fatalf("shard of %v should be in [0, 8): %v", key, shard)
+		}
+		if got, want := other.ShardOf(key), shard; got != want {
+			t.Fatalf("shard of %v should be deterministic %v: %v", key, want, got)
+		}
+
+		before := cache.ShardStats()[shard].SetCalls
+		cache.Set(key, i)
+		if got, want := cache.ShardStats()[shard].SetCalls, before+1; got != want {
+			t.Fatalf("shard %v set calls should be %v: %v", shard, want, got)
+		}
+	}
+}
+
+func TestLRUCacheHitRatio(t *testing.T) {
+	cache := NewLRUCache[string, int](128, WithHitRatioWindow[string, int](time.Minute))
+
+	if _, ok := cache.HitRatio(time.Minute); ok {
+		t.Fatalf("hit ratio should be absent without get calls")
+	}
+
+	cache.Set("a", 1)
+	for i := 0; i < 10; i++ {
+		cache.Get("a")
+	}
+	if ratio, ok := cache.HitRatio(time.Minute); !ok || ratio != 1 {
+		t.Fatalf("hit ratio should be 1: %v %v", ratio, ok)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		cache.Get("b")
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	if ratio, ok := cache.HitRatio(time.Second); !ok || ratio != 0 {
+		t.Fatalf("hit ratio of last second should be 0: %v %v", ratio, ok)
+	}
+	if ratio, ok := cache.HitRatio(time.Minute); !ok || ratio != 0.5 {
+		t.Fatalf("hit ratio of last minute should be 0.5: %v %v", ratio, ok)
+	}
+
+	if _, ok := NewLRUCache[string, int](128).HitRatio(time.Minute); ok {
+		t.Fatalf("hit ratio should be absent without tracking")
+	}
+}
+
+func TestLRUCacheLoaderRetry(t *testing.T) {
+	var calls int
+	cache := NewLRUCache[string, int](128, WithLoaderRetry[string, int](3, time.Millisecond))
+
+	loader := func(ctx context.Context, key string) (int, error) {
+		if calls++; calls < 3 {
+			return 0, errors.New("unavailable")
+		}
+		return 42, nil
+	}
+	if value, err, _ := cache.GetOrLoad(context.Background(), "a", loader); err != nil || value != 42 {
+		t.Fatalf("a should be loaded by retries: %v %v", value, err)
+	}
+	if got, want := calls, 3; got != want {
+		t.Fatalf("loader calls should be %v: %v", want, got)
+	}
+
+	calls = -10
+	if _, err, _ := cache.GetOrLoad(context.Background(), "b", loader); err == nil {
+		t.Fatalf("b should fail after attempts")
+	}
+	if got, want := calls, -7; got != want {
+		t.Fatalf("loader calls should be %v: %v", want, got)
+	}
+}
+
+func TestLRUCacheLoaderTimeout(t *testing.T) {
+	cache := NewLRUCache[string, int](128, WithLoaderTimeout[string, int](10*time.Millisecond))
+
+	start := time.Now()
+	_, err, _ := cache.GetOrLoad(context.Background(), "a", func(ctx context.Context, key string) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("loader should be timeout: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("loader should be timeout in 10ms: %v", elapsed)
+	}
+}
+
+func TestLRUCacheForgetLoad(t *testing.T) {
+	cache := NewLRUCache[string, int](128)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value, err, _ := cache.GetOrLoad(context.Background(), "a", func(ctx context.Context, key string) (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+		if err != nil || value != 1 {
+			t.Errorf("forgotten load should return to its caller: %v %v", value, err)
+		}
+	}()
+	<-started
+
+	if got, want := cache.InflightLoads(), 1; got != want {
+		t.Fatalf("inflight loads should be %v: %v", want, got)
+	}
+
+	cache.ForgetLoad("a")
+	cache.Set("a", 2)
+	if got, want := cache.InflightLoads(), 0; got != want {
+		t.Fatalf("inflight loads should be %v: %v", want, got)
+	}
+
+	// the next load runs again instead of waiting for the forgotten one.
+	value, err, _ := cache.GetOrLoadBypass(context.Background(), "a", func(ctx context.Context, key string) (int, error) {
+		return 3, nil
+	})
+	if err != nil || value != 3 {
+		t.Fatalf("a should be loaded again: %v %v", value, err)
+	}
+
+	cache.Set("a", 2)
+	close(release)
+	<-done
+	if value, _ := cache.Get("a"); value != 2 {
+		t.Fatalf("forgotten load should not overwrite a: %v", value)
+	}
+	if got, want := cache.InflightLoads(), 0; got != want {
+		t.Fatalf("inflight loads should be %v: %v", want, got)
+	}
+}
+
+func TestLRUCacheLoadCommitUnlocked(t *testing.T) {
+	var cache *LRUCache[string, int]
+	cache = NewLRUCache[string, int](128, WithIndex[string, int]("inflight", func(value int) int {
+		// the commit of the load must not hold the singleflight mutex.
+		return cache.InflightLoads()
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value, err, _ := cache.GetOrLoad(context.Background(), "a", func(ctx context.Context, key string) (int, error) {
+			return 1, nil
+		})
+		if err != nil || value != 1 {
+			t.Errorf("a should be loaded: %v %v", value, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the commit of the load should not block InflightLoads")
+	}
+	if value, ok := cache.Get("a"); !ok || value != 1 {
+		t.Fatalf("a should be set by the load: %v %v", value, ok)
+	}
+}
+
+func TestLRUCacheForgetLoadCommitting(t *testing.T) {
+	committing, release := make(chan struct{}), make(chan struct{})
+	cache := NewLRUCache[string, int](128, WithIndex[string, int]("block", func(value int) int {
+		if value == 1 {
+			close(committing)
+			<-release
+		}
+		return value
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.GetOrLoad(context.Background(), "a", func(ctx context.Context, key string) (int, error) {
+			return 1, nil
+		})
+	}()
+	<-committing
+
+	forgotten := make(chan struct{})
+	go func() {
+		cache.ForgetLoad("a")
+		close(forgotten)
+	}()
+	select {
+	case <-forgotten:
+		t.Fatalf("ForgetLoad should wait for the committing load")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-forgotten
+	cache.Set("a", 2)
+	<-done
+	if value, _ := cache.Get("a"); value != 2 {
+		t.Fatalf("the load committed before ForgetLoad should not overwrite a: %v", value)
+	}
+}
+
+func TestLRUCacheLoaderPanicRecovery(t *testing.T) {
+	cache := NewLRUCache[string, int](128)
+
+	loader := func(ctx context.Context, key string) (int, error) {
+		panic("boom")
+	}
+	for i := 0; i < 2; i++ {
+		_, err, _ := cache.GetOrLoad(context.Background(), "a", loader)
+		if e, ok := err.(*PanicError); !ok || e.Value != "boom" {
+			t.Fatalf("loader panic should be returned as error: %v", err)
+		}
+		if r := <-cache.GetOrLoadChan(context.Background(), "a"); r.Err != ErrLoaderIsNil {
+			t.Fatalf("loader should be nil: %+v", r)
+		}
+	}
+	if got, want := cache.InflightLoads(), 0; got != want {
+		t.Fatalf("inflight loads should be %v: %v", want, got)
+	}
+
+	value, err, _ := cache.GetOrLoad(context.Background(), "a", func(ctx context.Context, key string) (int, error) {
+		return 1, nil
+	})
+	if err != nil || value != 1 {
+		t.Fatalf("a should be loaded after panic: %v %v", value, err)
+	}
+}
+
+func TestLRUCacheStatsEvictions(t *testing.T) {
+	cache := NewLRUCache[string, int](4, WithShards[string, int](1))
+
+	for i, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		cache.Set(key, i)
+	}
+	if got, want := cache.Stats().Evictions, uint64(2); got != want {
+		t.Fatalf("cache evictions should be %v: %v", want, got)
+	}
+	if got, want := cache.EvictToWatermark(1), 3; got != want {
+		t.Fatalf("cache evicted should be %v: %v", want, got)
+	}
+	if got, want := cache.Stats().Evictions, uint64(5); got != want {
+		t.Fatalf("cache evictions should be %v: %v", want, got)
+	}
+}
+
+func TestLRUCacheGeneration(t *testing.T) {
+	cache := NewLRUCache[string, int](2, WithShards[string, int](1))
+
+	cache.Set("a", 1)
+	value, gen, ok := cache.GetWithGeneration("a")
+	if !ok || value != 1 {
+		t.Fatalf("a should be 1: %v %v", value, ok)
+	}
+	if !cache.ValidateGeneration("a", gen) {
+		t.Fatalf("generation of a should be valid")
+	}
+
+	cache.Set("a", 2)
+	if !cache.ValidateGeneration("a", gen) {
+		t.Fatalf("generation of a should be kept by replacing")
+	}
+
+	// evict a and reuse its slot, then bring a back to another slot
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+	cache.Set("a", 1)
+	if cache.ValidateGeneration("a", gen) {
+		t.Fatalf("generation of a should be invalid after eviction")
+	}
+
+	cache.Delete("a")
+	if cache.ValidateGeneration("a", gen) {
+		t.Fatalf("generation of a should be invalid after deletion")
+	}
+	if _, _, ok := cache.GetWithGeneration("a"); ok {
+		t.Fatalf("a should be deleted")
+	}
+
+	cache.Set("a", 1)
+	_, gen, _ = cache.GetWithGeneration("a")
+	cache.Clear()
+	cache.Set("a", 1)
+	if cache.ValidateGeneration("a", gen) {
+		t.Fatalf("generation of a should be invalid after clear")
+	}
+}
+
 func BenchmarkLRUCacheRand(b *testing.B) {
 	cache := NewLRUCache[int64, int64](8192)
 
@@ -0,0 +1,127 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+// Policy is the eviction policy of LRUCache shards.
+type Policy uint8
+
+const (
+	// PolicyLRU moves an entry to the list front on every hit, and evicts the list back.
+	PolicyLRU Policy = iota
+	// PolicySLRU splits the list into a protected segment at the front and a probation segment behind it.
+	// New entries enter the probation head, and only entries hit again are promoted to the protected segment,
+	// so a scan of one-off keys evicts the probation entries only and does not flush the working set.
+	PolicySLRU
+)
+
+// lrupolicy is the state of the optional admission and eviction policies of a shard.
+// It is not thread safe, the caller must hold the shard lock.
+type lrupolicy struct {
+	// the admission filter, nil if all new keys are admitted
+	admission *tinylfu
+
+	// the segmented lru, protected is the bitset of the nodes in the protected segment,
+	// and boundary is the last node of the protected segment, 0 if it is empty.
+	slru      bool
+	protected []uint64
+	boundary  uint32
+	count     uint32
+	max       uint32
+
+	// the promotion threshold, hits is the hit count of nodes since they were set, saturated at promote.
+	promote uint8
+	hits    []uint8
+}
+
+func (p *lrupolicy) init(size uint32) {
+	if p.admission != nil {
+		p.admission.init(size)
+	}
+	if p.slru {
+		p.protected = make([]uint64, (size+1+63)/64)
+		p.boundary = 0
+		p.count = 0
+		p.max = uint32(uint64(size) * 8 / 10)
+	}
+	if p.promote > 1 {
+		p.hits = make([]uint8, size+1)
+	}
+}
+
+func (p *lrupolicy) isProtected(index uint32) bool {
+	return p.protected[index/64]&(1<<(index%64)) != 0
+}
+
+// access records an access of hash, and moves the node of index forward by policy, index is 0 for a miss.
+func (s *lrushard[K, V]) access(hash uint32, index uint32) {
+	p := s.policy
+	if p.admission != nil {
+		p.admission.increment(hash)
+	}
+	if index == 0 {
+		return
+	}
+	if p.hits != nil && p.hits[index] < p.promote {
+		// the node is not moved until it is hit promote times.
+		if p.hits[index]++; p.hits[index] < p.promote {
+			return
+		}
+	}
+	if !p.slru {
+		s.listMoveToFront(index)
+		return
+	}
+
+	if p.isProtected(index) {
+		if index == p.boundary && p.count > 1 {
+			p.boundary = s.list[index].prev
+		}
+		s.listMoveToFront(index)
+		return
+	}
+
+	// promote the probation node to the protected segment.
+	p.protected[index/64] |= 1 << (index % 64)
+	if p.count++; p.count == 1 {
+		p.boundary = index
+	}
+	s.listMoveToFront(index)
+
+	// demote the protected tail to the probation head, which is the same position of the list.
+	if p.count > p.max {
+		b := p.boundary
+		p.protected[b/64] &^= 1 << (b % 64)
+		p.count--
+		p.boundary = s.list[b].prev
+	}
+}
+
+// place moves the new node of index to the list front, or the probation head of segmented lru.
+func (s *lrushard[K, V]) place(index uint32) {
+	p := s.policy
+	if p.hits != nil {
+		p.hits[index] = 0
+	}
+	if p.slru && p.boundary != 0 {
+		s.listMoveAfter(index, p.boundary)
+		return
+	}
+	s.listMoveToFront(index)
+}
+
+// unlink updates the policy state before the node of index is removed, while it is still linked in the list.
+func (s *lrushard[K, V]) unlink(index uint32) {
+	p := s.policy
+	if !p.slru || !p.isProtected(index) {
+		return
+	}
+	p.protected[index/64] &^= 1 << (index % 64)
+	p.count--
+	if index == p.boundary {
+		if p.count == 0 {
+			p.boundary = 0
+		} else {
+			p.boundary = s.list[index].prev
+		}
+	}
+}
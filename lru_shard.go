@@ -4,15 +4,20 @@ package lru
 
 import (
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
 // lrunode is a list of lru node, storing key-value pairs and related information
 type lrunode[K comparable, V any] struct {
-	key   K
-	next  uint32
-	prev  uint32
-	value V
+	key    K
+	next   uint32
+	prev   uint32
+	gen    uint32 // bumped when the node is reused for a new entry
+	xform  bool   // xvalue is the value transformed by the read transform
+	pinned bool   // the node is skipped when choosing the eviction victim
+	value  V
+	xvalue V
 }
 
 type lrubucket struct {
@@ -22,56 +27,201 @@ type lrubucket struct {
 
 // lrushard is an LRU partition contains a list and a hash table.
 type lrushard[K comparable, V any] struct {
+	// stats, keep first for 64-bit atomic alignment on 32-bit platforms
+	statsGetCalls uint64
+	statsSetCalls uint64
+	statsMisses   uint64
+
+	// padding, which keeps the shard size a multiple of 8 on 32-bit platforms
+	_ [8 - unsafe.Sizeof(uintptr(0))]byte
+
 	mu sync.Mutex
 
 	// the hash table, with 20% extra spacer than the list for fewer conflicts.
 	tableBuckets []uint64 // []lrubucket
 	tableMask    uint32
 	tableLength  uint32
-	tableHasher  func(key unsafe.Pointer, seed uintptr) uintptr
-	tableSeed    uintptr
+	tableHasher  func(key unsafe.Pointer) uintptr // seeded
+	tableEqual   func(a, b K) bool                // nil for ==
 
 	// the list of nodes
 	list []lrunode[K, V]
 
-	// stats
-	statsGetCalls uint64
-	statsSetCalls uint64
-	statsMisses   uint64
+	// eviction callback and removal counters
+	removal *shardremoval[K, V]
+
+	// the weight budget, nil if the shard is bounded by entry count only
+	weigher *shardweigher[K, V]
 
-	// padding
-	_ [24]byte
+	// the optional admission and eviction policies, nil for plain lru
+	policy *lrupolicy
+}
+
+// shardweigher bounds a shard by the total weight of its entries.
+type shardweigher[K comparable, V any] struct {
+	fn     func(key K, value V) int
+	max    int64
+	weight int64
+
+	// the entry heavier than max evicts all other entries instead of being rejected, see OversizeEvictAll
+	evictAll bool
 }
 
 func (s *lrushard[K, V]) Init(size uint32, hasher func(key unsafe.Pointer, seed uintptr) uintptr, seed uintptr) {
 	s.listInit(size)
 	s.tableInit(size, hasher, seed)
+	if s.policy != nil {
+		s.policy.init(size)
+	}
+	if s.removal == nil {
+		s.removal = new(shardremoval[K, V])
+	}
+}
+
+// evict counts the node which is evicted and calls the eviction callback, the caller must hold the shard lock.
+func (s *lrushard[K, V]) evict(node *lrunode[K, V]) {
+	atomic.AddUint64(&s.removal.evictions, 1)
+	if s.removal.evicted != nil {
+		s.removal.evicted(node.key, node.value)
+	}
 }
 
 func (s *lrushard[K, V]) Get(hash uint32, key K) (value V, ok bool) {
 	s.mu.Lock()
 
-	s.statsGetCalls++
+	atomic.AddUint64(&s.statsGetCalls, 1)
 
 	if index, exists := s.tableGet(hash, key); exists {
-		s.listMoveToFront(index)
+		if s.policy != nil {
+			s.access(hash, index)
+		} else {
+			s.listMoveToFront(index)
+		}
 		// value = s.list[index].value
 		value = (*lrunode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0]))).value
 		ok = true
 	} else {
-		s.statsMisses++
+		if s.policy != nil {
+			s.access(hash, 0)
+		}
+		atomic.AddUint64(&s.statsMisses, 1)
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *lrushard[K, V]) GetWithGeneration(hash uint32, key K, fn func(value V) V) (value V, generation uint64, ok bool) {
+	s.mu.Lock()
+
+	atomic.AddUint64(&s.statsGetCalls, 1)
+
+	if index, exists := s.tableGet(hash, key); exists {
+		if s.policy != nil {
+			s.access(hash, index)
+		} else {
+			s.listMoveToFront(index)
+		}
+		node := &s.list[index]
+		value = s.read(node, fn)
+		generation = uint64(index)<<32 | uint64(node.gen)
+		ok = true
+	} else {
+		if s.policy != nil {
+			s.access(hash, 0)
+		}
+		atomic.AddUint64(&s.statsMisses, 1)
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+// GetTransformed is the same as Get, but returns the value transformed by fn as read.
+func (s *lrushard[K, V]) GetTransformed(hash uint32, key K, fn func(value V) V) (value V, ok bool) {
+	s.mu.Lock()
+
+	atomic.AddUint64(&s.statsGetCalls, 1)
+
+	if index, exists := s.tableGet(hash, key); exists {
+		if s.policy != nil {
+			s.access(hash, index)
+		} else {
+			s.listMoveToFront(index)
+		}
+		value = s.read(&s.list[index], fn)
+		ok = true
+	} else {
+		if s.policy != nil {
+			s.access(hash, 0)
+		}
+		atomic.AddUint64(&s.statsMisses, 1)
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+// read returns the value of node, which is transformed by fn if fn is not nil. The transformed value is memoized
+// in the node beside the stored value until the value is set again, so fn is called once per set value.
+// The caller must hold the shard lock.
+func (s *lrushard[K, V]) read(node *lrunode[K, V], fn func(value V) V) V {
+	if fn == nil {
+		return node.value
+	}
+	if !node.xform {
+		node.xvalue = fn(node.value)
+		node.xform = true
 	}
+	return node.xvalue
+}
+
+// untransform drops the memoized transformed value of node, the caller must hold the shard lock.
+func (node *lrunode[K, V]) untransform() {
+	var zero V
+	node.xvalue = zero
+	node.xform = false
+}
 
+func (s *lrushard[K, V]) ValidateGeneration(hash uint32, key K, generation uint64) (ok bool) {
+	s.mu.Lock()
+	if index, exists := s.tableGet(hash, key); exists {
+		ok = uint64(index)<<32|uint64(s.list[index].gen) == generation
+	}
 	s.mu.Unlock()
 
 	return
 }
 
-func (s *lrushard[K, V]) Peek(hash uint32, key K) (value V, ok bool) {
+func (s *lrushard[K, V]) GetMulti(keys []K, hashes []uint32, order []int, values map[K]V, fn func(value V) V) {
+	s.mu.Lock()
+	for _, i := range order {
+		atomic.AddUint64(&s.statsGetCalls, 1)
+		if index, exists := s.tableGet(hashes[i], keys[i]); exists {
+			if s.policy != nil {
+				s.access(hashes[i], index)
+			} else {
+				s.listMoveToFront(index)
+			}
+			values[keys[i]] = s.read(&s.list[index], fn)
+		} else {
+			if s.policy != nil {
+				s.access(hashes[i], 0)
+			}
+			atomic.AddUint64(&s.statsMisses, 1)
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *lrushard[K, V]) Peek(hash uint32, key K, fn func(value V) V) (value V, ok bool) {
 	s.mu.Lock()
 
 	if index, exists := s.tableGet(hash, key); exists {
-		value = s.list[index].value
+		value = s.read(&s.list[index], fn)
 		ok = true
 	}
 
@@ -80,6 +230,14 @@ func (s *lrushard[K, V]) Peek(hash uint32, key K) (value V, ok bool) {
 	return
 }
 
+func (s *lrushard[K, V]) Contains(hash uint32, key K) (ok bool) {
+	s.mu.Lock()
+	_, ok = s.tableGet(hash, key)
+	s.mu.Unlock()
+
+	return
+}
+
 func (s *lrushard[K, V]) SetIfAbsent(hash uint32, key K, value V) (prev V, replaced bool) {
 	s.mu.Lock()
 
@@ -89,17 +247,35 @@ func (s *lrushard[K, V]) SetIfAbsent(hash uint32, key K, value V) (prev V, repla
 		return
 	}
 
-	s.statsSetCalls++
+	if s.weigher != nil || s.policy != nil {
+		prev, replaced = s.setLocked(hash, key, value)
+		s.mu.Unlock()
+		return
+	}
+
+	atomic.AddUint64(&s.statsSetCalls, 1)
+
+	if uint32(len(s.list)-1) < s.tableLength+1 {
+		s.skipPinned()
+	}
 
 	// index := s.list_Back()
 	// node := &s.list[index]
 	index := s.list[0].prev
 	node := (*lrunode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0])))
 	evictedValue := node.value
-	s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)), s.tableSeed)), node.key)
+
+	// delete the old key if the list is full, note that the list length is size+1
+	if uint32(len(s.list)-1) < s.tableLength+1 && key != node.key {
+		s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key)
+		s.evict(node)
+	}
 
 	node.key = key
+	node.gen++
+	node.pinned = false
 	node.value = value
+	node.untransform()
 	s.tableSet(hash, key, index)
 	s.listMoveToFront(index)
 	prev = evictedValue
@@ -111,7 +287,13 @@ func (s *lrushard[K, V]) SetIfAbsent(hash uint32, key K, value V) (prev V, repla
 func (s *lrushard[K, V]) Set(hash uint32, key K, value V) (prev V, replaced bool) {
 	s.mu.Lock()
 
-	s.statsSetCalls++
+	if s.weigher != nil || s.policy != nil {
+		prev, replaced = s.setLocked(hash, key, value)
+		s.mu.Unlock()
+		return
+	}
+
+	atomic.AddUint64(&s.statsSetCalls, 1)
 
 	if index, exists := s.tableGet(hash, key); exists {
 		// node := &s.list[index]
@@ -119,6 +301,7 @@ func (s *lrushard[K, V]) Set(hash uint32, key K, value V) (prev V, replaced bool
 		previousValue := node.value
 		s.listMoveToFront(index)
 		node.value = value
+		node.untransform()
 		prev = previousValue
 		replaced = true
 
@@ -126,6 +309,10 @@ func (s *lrushard[K, V]) Set(hash uint32, key K, value V) (prev V, replaced bool
 		return
 	}
 
+	if uint32(len(s.list)-1) < s.tableLength+1 {
+		s.skipPinned()
+	}
+
 	// index := s.list_Back()
 	// node := &s.list[index]
 	index := s.list[0].prev
@@ -134,11 +321,15 @@ func (s *lrushard[K, V]) Set(hash uint32, key K, value V) (prev V, replaced bool
 
 	// delete the old key if the list is full, note that the list length is size+1
 	if uint32(len(s.list)-1) < s.tableLength+1 && key != node.key {
-		s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)), s.tableSeed)), node.key)
+		s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key)
+		s.evict(node)
 	}
 
 	node.key = key
+	node.gen++
+	node.pinned = false
 	node.value = value
+	node.untransform()
 	s.tableSet(hash, key, index)
 	s.listMoveToFront(index)
 	prev = evictedValue
@@ -147,20 +338,243 @@ func (s *lrushard[K, V]) Set(hash uint32, key K, value V) (prev V, replaced bool
 	return
 }
 
+func (s *lrushard[K, V]) SetIf(hash uint32, key K, value V, cond func(old V, exists bool) bool) (ok bool) {
+	s.mu.Lock()
+
+	var old V
+	index, exists := s.tableGet(hash, key)
+	if exists {
+		old = s.list[index].value
+	}
+	if ok = cond(old, exists); ok {
+		s.setLocked(hash, key, value)
+	}
+
+	s.mu.Unlock()
+	return
+}
+
+func (s *lrushard[K, V]) GetOrSet(hash uint32, key K, value V, fn func(value V) V) (actual V, loaded bool) {
+	s.mu.Lock()
+
+	atomic.AddUint64(&s.statsGetCalls, 1)
+
+	if index, exists := s.tableGet(hash, key); exists {
+		if s.policy != nil {
+			s.access(hash, index)
+		} else {
+			s.listMoveToFront(index)
+		}
+		actual = s.read(&s.list[index], fn)
+		loaded = true
+	} else {
+		atomic.AddUint64(&s.statsMisses, 1)
+		s.setLocked(hash, key, value)
+		if index, exists := s.tableGet(hash, key); exists {
+			actual = s.read(&s.list[index], fn)
+		} else if actual = value; fn != nil {
+			// the value rejected by the weigher is not memoized.
+			actual = fn(value)
+		}
+	}
+
+	s.mu.Unlock()
+	return
+}
+
+func (s *lrushard[K, V]) Compute(hash uint32, key K, fn func(old V, exists bool) (new V, keep bool)) (value V, ok bool) {
+	s.mu.Lock()
+
+	var old V
+	index, exists := s.tableGet(hash, key)
+	if exists {
+		old = s.list[index].value
+	}
+	if value, ok = fn(old, exists); ok {
+		s.setLocked(hash, key, value)
+	} else if exists {
+		var zero V
+		if s.weigher != nil {
+			s.weigher.weight -= int64(s.weigher.fn(key, old))
+		}
+		if s.policy != nil {
+			s.unlink(index)
+		}
+		s.listMoveToBack(index)
+		s.list[index].value = zero
+		s.list[index].untransform()
+		s.tableDelete(hash, key)
+		value = zero
+	}
+
+	s.mu.Unlock()
+	return
+}
+
+func (s *lrushard[K, V]) SetMulti(entries []Entry[K, V], hashes []uint32, order []int) {
+	s.mu.Lock()
+	for _, i := range order {
+		s.setLocked(hashes[i], entries[i].Key, entries[i].Value)
+	}
+	s.mu.Unlock()
+}
+
+// setLocked is the same as Set, but the caller must hold the shard lock.
+func (s *lrushard[K, V]) setLocked(hash uint32, key K, value V) (prev V, replaced bool) {
+	atomic.AddUint64(&s.statsSetCalls, 1)
+
+	var weight int64
+	if s.weigher != nil {
+		if weight = int64(s.weigher.fn(key, value)); weight > s.weigher.max && !s.weigher.evictAll {
+			// reject the entry heavier than the whole budget, and drop the stale value of key.
+			s.deleteLocked(hash, key)
+			return
+		}
+	}
+
+	if index, exists := s.tableGet(hash, key); exists {
+		node := &s.list[index]
+		prev = node.value
+		if s.policy != nil {
+			s.access(hash, index)
+		} else {
+			s.listMoveToFront(index)
+		}
+		node.value = value
+		node.untransform()
+		replaced = true
+		if s.weigher != nil {
+			s.weigher.weight += weight - int64(s.weigher.fn(key, prev))
+			s.evictWeight(index)
+		}
+		return
+	}
+
+	if s.policy != nil {
+		s.access(hash, 0)
+	}
+
+	if uint32(len(s.list)-1) < s.tableLength+1 {
+		s.skipPinned()
+	}
+
+	index := s.list[0].prev
+	node := &s.list[index]
+
+	// delete the old key if the list is full, note that the list length is size+1
+	if uint32(len(s.list)-1) < s.tableLength+1 && key != node.key {
+		victim := uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key))))
+		if s.policy != nil {
+			if s.policy.admission != nil && !s.policy.admission.admit(hash, victim) {
+				// reject the new key which is less frequent than the victim.
+				return
+			}
+			s.unlink(index)
+		}
+		s.tableDelete(victim, node.key)
+		if s.weigher != nil {
+			s.weigher.weight -= int64(s.weigher.fn(node.key, node.value))
+		}
+		s.evict(node)
+	}
+
+	prev = node.value
+	node.key = key
+	node.gen++
+	node.pinned = false
+	node.value = value
+	node.untransform()
+	s.tableSet(hash, key, index)
+	if s.policy != nil {
+		s.place(index)
+	} else {
+		s.listMoveToFront(index)
+	}
+	if s.weigher != nil {
+		s.weigher.weight += weight
+		s.evictWeight(index)
+	}
+	return
+}
+
+// evictWeight evicts entries from the list back until the total weight fits the budget, except the node keep.
+// The caller must hold the shard lock.
+func (s *lrushard[K, V]) evictWeight(keep uint32) {
+	var zero V
+
+	// walk from the list back, skipping the free nodes which are not in the table.
+	for index := s.list[0].prev; index != 0 && s.weigher.weight > s.weigher.max; {
+		node := &s.list[index]
+		prev := node.prev
+		if index != keep && !node.pinned {
+			hash := uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key))))
+			if i, exists := s.tableGet(hash, node.key); exists && i == index {
+				if s.policy != nil {
+					s.unlink(index)
+				}
+				s.tableDelete(hash, node.key)
+				s.weigher.weight -= int64(s.weigher.fn(node.key, node.value))
+				s.evict(node)
+				node.value = zero
+				node.untransform()
+				// keep the free nodes at the list back, behind the pinned nodes skipped.
+				s.listMoveToBack(index)
+			}
+		}
+		index = prev
+	}
+}
+
+// skipPinned moves the pinned nodes at the list back to the front, so the list back is the eviction victim
+// unless all nodes are pinned. The caller must hold the shard lock.
+func (s *lrushard[K, V]) skipPinned() {
+	for n, index := s.tableLength, s.list[0].prev; n > 0 && index != 0 && s.list[index].pinned; n, index = n-1, s.list[0].prev {
+		if s.policy != nil {
+			s.unlink(index)
+			s.place(index)
+		} else {
+			s.listMoveToFront(index)
+		}
+	}
+}
+
+func (s *lrushard[K, V]) Pin(hash uint32, key K, pinned bool) (ok bool) {
+	s.mu.Lock()
+	if index, exists := s.tableGet(hash, key); exists {
+		s.list[index].pinned = pinned
+		ok = true
+	}
+	s.mu.Unlock()
+
+	return
+}
+
 func (s *lrushard[K, V]) Delete(hash uint32, key K) (v V) {
 	s.mu.Lock()
+	v = s.deleteLocked(hash, key)
+	s.mu.Unlock()
+
+	return
+}
 
+// deleteLocked is the same as Delete, but the caller must hold the shard lock.
+func (s *lrushard[K, V]) deleteLocked(hash uint32, key K) (v V) {
 	if index, exists := s.tableGet(hash, key); exists {
 		node := &s.list[index]
 		value := node.value
+		if s.weigher != nil {
+			s.weigher.weight -= int64(s.weigher.fn(key, value))
+		}
+		if s.policy != nil {
+			s.unlink(index)
+		}
 		s.listMoveToBack(index)
 		node.value = v
+		node.untransform()
 		s.tableDelete(hash, key)
 		v = value
 	}
 
-	s.mu.Unlock()
-
 	return
 }
 
@@ -186,3 +600,197 @@ func (s *lrushard[K, V]) AppendKeys(dst []K) []K {
 
 	return dst
 }
+
+func (s *lrushard[K, V]) EvictTo(n uint32) (evicted uint32) {
+	var zero V
+
+	s.mu.Lock()
+	// walk from the list back, skipping the free nodes which are not in the table.
+	for index := s.list[0].prev; index != 0 && s.tableLength > n; {
+		node := &s.list[index]
+		prev := node.prev
+		hash := uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key))))
+		if i, exists := s.tableGet(hash, node.key); exists && i == index && !node.pinned {
+			if s.policy != nil {
+				s.unlink(index)
+			}
+			s.tableDelete(hash, node.key)
+			if s.weigher != nil {
+				s.weigher.weight -= int64(s.weigher.fn(node.key, node.value))
+			}
+			s.evict(node)
+			node.value = zero
+			node.untransform()
+			// keep the free nodes at the list back, behind the pinned nodes skipped.
+			s.listMoveToBack(index)
+			evicted++
+		}
+		index = prev
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *lrushard[K, V]) ScanKeys(dst []K, pos uint32, count int) ([]K, uint32) {
+	s.mu.Lock()
+	for n := uint32(len(s.tableBuckets)); pos < n; pos++ {
+		if count == 0 {
+			s.mu.Unlock()
+			return dst, pos
+		}
+		b := (*lrubucket)(unsafe.Pointer(&s.tableBuckets[pos]))
+		if b.index == 0 {
+			continue
+		}
+		dst = append(dst, s.list[b.index].key)
+		count--
+	}
+	s.mu.Unlock()
+
+	return dst, 0
+}
+
+// ScanEntries appends the entries in at most count buckets starting from pos, and returns the next pos,
+// which is 0 if the table is done. The count less than 1 means all buckets.
+func (s *lrushard[K, V]) ScanEntries(keys []K, values []V, pos uint32, count int, fn func(value V) V) ([]K, []V, uint32) {
+	s.mu.Lock()
+	n := uint32(len(s.tableBuckets))
+	end := n
+	if count > 0 && uint64(pos)+uint64(count) < uint64(n) {
+		end = pos + uint32(count)
+	}
+	for ; pos < end; pos++ {
+		b := (*lrubucket)(unsafe.Pointer(&s.tableBuckets[pos]))
+		if b.index == 0 {
+			continue
+		}
+		node := &s.list[b.index]
+		keys = append(keys, node.key)
+		values = append(values, s.read(node, fn))
+	}
+	s.mu.Unlock()
+
+	if pos == n {
+		pos = 0
+	}
+	return keys, values, pos
+}
+
+func (s *lrushard[K, V]) AppendEntries(keys []K, values []V, fn func(value V) V) ([]K, []V) {
+	s.mu.Lock()
+	for _, bucket := range s.tableBuckets {
+		b := (*lrubucket)(unsafe.Pointer(&bucket))
+		if b.index == 0 {
+			continue
+		}
+		node := &s.list[b.index]
+		keys = append(keys, node.key)
+		values = append(values, s.read(node, fn))
+	}
+	s.mu.Unlock()
+
+	return keys, values
+}
+
+func (s *lrushard[K, V]) AppendEvictionOrder(dst []K, max int) []K {
+	s.mu.Lock()
+	// walk from the list back, skipping the free nodes which are not in the table.
+	for index := s.list[0].prev; index != 0 && max > 0; index = s.list[index].prev {
+		node := &s.list[index]
+		if i, exists := s.tableGet(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key); exists && i == index {
+			dst = append(dst, node.key)
+			max--
+		}
+	}
+	s.mu.Unlock()
+
+	return dst
+}
+
+// Edge returns the least recently used entry from the list back if back is true,
+// or the most recently used entry from the list front otherwise.
+func (s *lrushard[K, V]) Edge(back bool, fn func(value V) V) (key K, value V, ok bool) {
+	step := func(index uint32) uint32 {
+		if back {
+			return s.list[index].prev
+		}
+		return s.list[index].next
+	}
+
+	s.mu.Lock()
+	// skip the free nodes which are not in the table.
+	for index := step(0); index != 0; index = step(index) {
+		node := &s.list[index]
+		if i, exists := s.tableGet(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key); exists && i == index {
+			key, value, ok = node.key, s.read(node, fn), true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *lrushard[K, V]) DeleteIf(fn func(key K, value V) bool) (deleted uint32) {
+	var zero V
+
+	s.mu.Lock()
+	for i := uint32(0); i < uint32(len(s.tableBuckets)); {
+		b := (*lrubucket)(unsafe.Pointer(&s.tableBuckets[i]))
+		if b.index == 0 || !fn(s.list[b.index].key, s.list[b.index].value) {
+			i++
+			continue
+		}
+		node := &s.list[b.index]
+		if s.weigher != nil {
+			s.weigher.weight -= int64(s.weigher.fn(node.key, node.value))
+		}
+		if s.policy != nil {
+			s.unlink(b.index)
+		}
+		s.listMoveToBack(b.index)
+		node.value = zero
+		node.untransform()
+		// the following buckets are shifted backward, so check i again.
+		s.tableDeleteByIndex(i)
+		deleted++
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *lrushard[K, V]) Clear() {
+	s.mu.Lock()
+	s.reset()
+	s.mu.Unlock()
+}
+
+func (s *lrushard[K, V]) reset() {
+	for i := range s.list {
+		// keep the generations, so the slots reused after reset are still detected.
+		s.list[i] = lrunode[K, V]{gen: s.list[i].gen}
+	}
+	for i := range s.tableBuckets {
+		s.tableBuckets[i] = 0
+	}
+	atomic.StoreUint32(&s.tableLength, 0)
+	s.listInit(uint32(len(s.list) - 1))
+	if s.weigher != nil {
+		s.weigher.weight = 0
+	}
+	if s.policy != nil {
+		s.policy.init(uint32(len(s.list) - 1))
+	}
+}
+
+func (s *lrushard[K, V]) Weight() (weight int64) {
+	s.mu.Lock()
+	if s.weigher != nil {
+		weight = s.weigher.weight
+	}
+	s.mu.Unlock()
+
+	return
+}
@@ -59,3 +59,20 @@ func (s *lrushard[K, V]) listMoveToBack(i uint32) {
 	((*lrunode[K, V])(unsafe.Add(base, uintptr(j)*unsafe.Sizeof(s.list[0])))).next = i
 	((*lrunode[K, V])(unsafe.Add(base, uintptr(nodei.next)*unsafe.Sizeof(s.list[0])))).prev = i
 }
+
+func (s *lrushard[K, V]) listMoveAfter(i, at uint32) {
+	if i == at || s.list[at].next == i {
+		return
+	}
+
+	nodei, nodeat := &s.list[i], &s.list[at]
+
+	s.list[nodei.prev].next = nodei.next
+	s.list[nodei.next].prev = nodei.prev
+
+	nodei.prev = at
+	nodei.next = nodeat.next
+
+	s.list[nodeat.next].prev = i
+	nodeat.next = i
+}
@@ -6,6 +6,7 @@
 package lru
 
 import (
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -23,9 +24,8 @@ func (s *lrushard[K, V]) tableInit(size uint32, hasher func(key unsafe.Pointer,
 		s.tableBuckets = make([]uint64, newsize)
 	}
 	s.tableMask = newsize - 1
-	s.tableLength = 0
-	s.tableHasher = hasher
-	s.tableSeed = seed
+	atomic.StoreUint32(&s.tableLength, 0)
+	s.tableHasher = func(key unsafe.Pointer) uintptr { return hasher(key, seed) }
 }
 
 func lruNewTableSize(size uint32) (newsize uint32) {
@@ -39,6 +39,14 @@ func lruNewTableSize(size uint32) (newsize uint32) {
 	return
 }
 
+// tableKeyEqual reports whether the keys are equal, by the equality of WithKeyEqual if any.
+func (s *lrushard[K, V]) tableKeyEqual(a, b K) bool {
+	if s.tableEqual != nil {
+		return s.tableEqual(a, b)
+	}
+	return a == b
+}
+
 // Set assigns an index to a key.
 // Returns the previous index, or false when no index was assigned.
 func (s *lrushard[K, V]) tableSet(hash uint32, key K, index uint32) (prev uint32, ok bool) {
@@ -53,10 +61,10 @@ func (s *lrushard[K, V]) tableSet(hash uint32, key K, index uint32) (prev uint32
 		if b.hdib&maxDIB == 0 {
 			b.hdib = hdib
 			b.index = index
-			s.tableLength++
+			atomic.AddUint32(&s.tableLength, 1)
 			return
 		}
-		if hdib>>dibBitSize == b.hdib>>dibBitSize && (*lrunode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key == key {
+		if hdib>>dibBitSize == b.hdib>>dibBitSize && s.tableKeyEqual((*lrunode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key, key) {
 			prev = b.index
 			b.hdib = hdib
 			b.index = index
@@ -85,7 +93,7 @@ func (s *lrushard[K, V]) tableGet(hash uint32, key K) (index uint32, ok bool) {
 		if b.hdib&maxDIB == 0 {
 			return
 		}
-		if b.hdib>>dibBitSize == subhash && (*lrunode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key == key {
+		if b.hdib>>dibBitSize == subhash && s.tableKeyEqual((*lrunode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key, key) {
 			return b.index, true
 		}
 		i = (i + 1) & mask
@@ -105,7 +113,7 @@ func (s *lrushard[K, V]) tableDelete(hash uint32, key K) (index uint32, ok bool)
 		if b.hdib&maxDIB == 0 {
 			return
 		}
-		if b.hdib>>dibBitSize == subhash && (*lrunode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key == key {
+		if b.hdib>>dibBitSize == subhash && s.tableKeyEqual((*lrunode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key, key) {
 			old := b.index
 			s.tableDeleteByIndex(i)
 			return old, true
@@ -132,5 +140,5 @@ func (s *lrushard[K, V]) tableDeleteByIndex(i uint32) {
 		bpi.index = bi.index
 		bpi.hdib = bi.hdib>>dibBitSize<<dibBitSize | (bi.hdib&maxDIB-1)&maxDIB
 	}
-	s.tableLength--
+	atomic.AddUint32(&s.tableLength, ^uint32(0))
 }
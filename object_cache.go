@@ -0,0 +1,86 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+// Codec marshals and unmarshals values of ObjectCache, and keys and values of TTLCache snapshots.
+type Codec[V any] interface {
+	Marshal(value V) ([]byte, error)
+	Unmarshal(data []byte, value *V) error
+}
+
+// ObjectCache implements typed Cache over BytesCache, values are stored in marshaled bytes.
+type ObjectCache[V any] struct {
+	cache *BytesCache
+	codec Codec[V]
+}
+
+// NewObjectCache creates object cache with size capacity and codec.
+func NewObjectCache[V any](shards uint8, shardsize uint32, codec Codec[V]) *ObjectCache[V] {
+	return &ObjectCache[V]{
+		cache: NewBytesCache(shards, shardsize),
+		codec: codec,
+	}
+}
+
+// Get returns value for key.
+func (c *ObjectCache[V]) Get(key []byte) (value V, err error, ok bool) {
+	data, ok := c.cache.Get(key)
+	if ok {
+		err = c.codec.Unmarshal(data, &value)
+	}
+	return
+}
+
+// Peek returns value, but does not modify its recency.
+func (c *ObjectCache[V]) Peek(key []byte) (value V, err error, ok bool) {
+	data, ok := c.cache.Peek(key)
+	if ok {
+		err = c.codec.Unmarshal(data, &value)
+	}
+	return
+}
+
+// Contains reports whether key is in the cache, but does not modify its recency or unmarshal its value.
+func (c *ObjectCache[V]) Contains(key []byte) bool {
+	return c.cache.Contains(key)
+}
+
+// Set inserts key value pair, returns error if value cannot be marshaled.
+func (c *ObjectCache[V]) Set(key []byte, value V) error {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.cache.Set(key, data)
+	return nil
+}
+
+// SetIfAbsent inserts key value pair if key is absent in the cache, returns error if value cannot be marshaled.
+func (c *ObjectCache[V]) SetIfAbsent(key []byte, value V) error {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.cache.SetIfAbsent(key, data)
+	return nil
+}
+
+// Delete method deletes value associated with key.
+func (c *ObjectCache[V]) Delete(key []byte) {
+	c.cache.Delete(key)
+}
+
+// Len returns number of cached nodes.
+func (c *ObjectCache[V]) Len() int {
+	return c.cache.Len()
+}
+
+// AppendKeys appends all keys to keys and return the keys.
+func (c *ObjectCache[V]) AppendKeys(keys [][]byte) [][]byte {
+	return c.cache.AppendKeys(keys)
+}
+
+// Stats returns cache stats.
+func (c *ObjectCache[V]) Stats() Stats {
+	return c.cache.Stats()
+}
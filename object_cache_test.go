@@ -0,0 +1,63 @@
+package lru
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type jsonCodec[V any] struct{}
+
+func (jsonCodec[V]) Marshal(value V) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec[V]) Unmarshal(data []byte, value *V) error {
+	return json.Unmarshal(data, value)
+}
+
+type objectCacheUser struct {
+	Name string
+	Age  int
+}
+
+func TestObjectCacheGetSet(t *testing.T) {
+	cache := NewObjectCache[objectCacheUser](1, 128, jsonCodec[objectCacheUser]{})
+
+	if v, err, ok := cache.Get([]byte("a")); ok || err != nil {
+		t.Fatalf("bad returned value: %v, %v", v, err)
+	}
+
+	if err := cache.Set([]byte("a"), objectCacheUser{"foo", 42}); err != nil {
+		t.Fatalf("set a error: %v", err)
+	}
+
+	if v, err, ok := cache.Get([]byte("a")); !ok || err != nil || v.Name != "foo" || v.Age != 42 {
+		t.Fatalf("bad returned value: %v, %v", v, err)
+	}
+
+	if err := cache.SetIfAbsent([]byte("a"), objectCacheUser{"bar", 24}); err != nil {
+		t.Fatalf("set a error: %v", err)
+	}
+
+	if v, err, ok := cache.Peek([]byte("a")); !ok || err != nil || v.Name != "foo" {
+		t.Fatalf("bad returned value: %v, %v", v, err)
+	}
+
+	cache.Delete([]byte("a"))
+
+	if v, err, ok := cache.Get([]byte("a")); ok || err != nil {
+		t.Fatalf("bad returned value: %v, %v", v, err)
+	}
+
+	if got, want := cache.Len(), 0; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+}
+
+func TestObjectCacheCodecError(t *testing.T) {
+	cache := NewObjectCache[chan int](1, 128, jsonCodec[chan int]{})
+
+	if err := cache.Set([]byte("a"), make(chan int)); err == nil {
+		t.Fatalf("set a should be failed")
+	}
+}
@@ -3,7 +3,11 @@ package lru
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"runtime"
+	"sort"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -19,6 +23,12 @@ func WithShards[K comparable, V any](count uint32) Option[K, V] {
 	return &shardsOption[K, V]{count: count}
 }
 
+// DefaultShards returns the shards count of cache when WithShards is absent or zero,
+// it can be replaced at program startup to tune the default for a whole fleet.
+var DefaultShards = func() uint32 {
+	return uint32(runtime.GOMAXPROCS(0) * 16)
+}
+
 type shardsOption[K comparable, V any] struct {
 	count uint32
 }
@@ -26,7 +36,7 @@ type shardsOption[K comparable, V any] struct {
 func (o *shardsOption[K, V]) getcount(maxcount uint32) uint32 {
 	var shardcount uint32
 	if o.count == 0 {
-		shardcount = nextPowOf2(uint32(runtime.GOMAXPROCS(0) * 16))
+		shardcount = nextPowOf2(DefaultShards())
 	} else {
 		shardcount = nextPowOf2(o.count)
 	}
@@ -61,7 +71,45 @@ func (o *hasherOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
 	c.hasher = o.hasher
 }
 
-// WithSliding specifies that use sliding cache or not.
+// WithKeyEqual specifies the key equality of cache, which is == when it is absent,
+// e.g. for case-insensitive strings. Keys equal by eq must have the same hash,
+// so it needs a hasher specified by WithHasher as well.
+func WithKeyEqual[K comparable, V any](eq func(a, b K) bool) Option[K, V] {
+	return &keyEqualOption[K, V]{eq: eq}
+}
+
+type keyEqualOption[K comparable, V any] struct {
+	eq func(a, b K) bool
+}
+
+func (o *keyEqualOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.equal = o.eq
+}
+
+func (o *keyEqualOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.equal = o.eq
+}
+
+// WithSeed specifies the hash seed of cache, which is random when it is absent or zero.
+// A fixed seed makes the shard placement and the table probing reproducible in a process,
+// and across processes as well if the hasher specified by WithHasher is deterministic.
+func WithSeed[K comparable, V any](seed uint64) Option[K, V] {
+	return &seedOption[K, V]{seed: seed}
+}
+
+type seedOption[K comparable, V any] struct {
+	seed uint64
+}
+
+func (o *seedOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.seed = uintptr(o.seed)
+}
+
+func (o *seedOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.seed = uintptr(o.seed)
+}
+
+// WithSliding specifies that use sliding cache or not, a sliding TTLCache extends the expiration of entries by Get.
 func WithSliding[K comparable, V any](sliding bool) Option[K, V] {
 	return &slidingOption[K, V]{sliding: sliding}
 }
@@ -71,7 +119,6 @@ type slidingOption[K comparable, V any] struct {
 }
 
 func (o *slidingOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
-	panic("not_supported")
 }
 
 func (o *slidingOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
@@ -80,8 +127,51 @@ func (o *slidingOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
 	}
 }
 
+// WithMissSampler specifies a function called with the missing key and current stats for every Nth cache miss.
+func WithMissSampler[K comparable, V any](every uint64, fn func(key K, stats Stats)) Option[K, V] {
+	if every == 0 {
+		every = 1
+	}
+	return &missSamplerOption[K, V]{every: every, fn: fn}
+}
+
+type missSamplerOption[K comparable, V any] struct {
+	every uint64
+	fn    func(key K, stats Stats)
+}
+
+func (o *missSamplerOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.sampler = &missSampler[K]{every: o.every, fn: o.fn}
+}
+
+func (o *missSamplerOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.sampler = &missSampler[K]{every: o.every, fn: o.fn}
+}
+
+type missSampler[K comparable] struct {
+	count uint64 // keep first for 64-bit atomic alignment
+	every uint64
+	fn    func(key K, stats Stats)
+}
+
+func (m *missSampler[K]) sample() bool {
+	return atomic.AddUint64(&m.count, 1)%m.every == 0
+}
+
 var ErrLoaderIsNil = errors.New("loader is nil")
 
+var ErrLoaderBusy = errors.New("loader is busy")
+
+// PanicError is returned by GetOrLoad to the caller and the waiters of a load, if the loader panics.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // the stack trace of the loader goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("lru: loader panicked: %v\n\n%s", e.Value, e.Stack)
+}
+
 // WithLoader specifies that loader function of LoadingCache.
 func WithLoader[K comparable, V any, Loader ~func(ctx context.Context, key K) (value V, err error) | ~func(ctx context.Context, key K) (value V, ttl time.Duration, err error)](loader Loader) Option[K, V] {
 	return &loaderOption[K, V]{loader: loader}
@@ -97,7 +187,6 @@ func (o *loaderOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
 		panic("not_supported")
 	}
 	c.loader = loader
-	c.group = singleflightGroup[K, V]{}
 }
 
 func (o *loaderOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
@@ -106,7 +195,584 @@ func (o *loaderOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
 		panic("not_supported")
 	}
 	c.loader = loader
-	c.group = singleflightGroup[K, V]{}
+}
+
+// WithLoaderBusyTimeout specifies that GetOrLoad returns ErrLoaderBusy immediately instead of waiting,
+// if the in-flight loading of the same key has been running longer than timeout.
+func WithLoaderBusyTimeout[K comparable, V any](timeout time.Duration) Option[K, V] {
+	return &loaderBusyTimeoutOption[K, V]{timeout: timeout}
+}
+
+type loaderBusyTimeoutOption[K comparable, V any] struct {
+	timeout time.Duration
+}
+
+func (o *loaderBusyTimeoutOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.group.busy = o.timeout
+}
+
+func (o *loaderBusyTimeoutOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.group.busy = o.timeout
+}
+
+// WithLoaderTimeout specifies the deadline of each loader call by GetOrLoad, the loader should return when its context is
+// done, so a slow loader does not hold the waiters of the same key indefinitely.
+func WithLoaderTimeout[K comparable, V any](timeout time.Duration) Option[K, V] {
+	return &loaderTimeoutOption[K, V]{timeout: timeout}
+}
+
+type loaderTimeoutOption[K comparable, V any] struct {
+	timeout time.Duration
+}
+
+func (o *loaderTimeoutOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.policy.timeout = o.timeout
+}
+
+func (o *loaderTimeoutOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.policy.timeout = o.timeout
+}
+
+// WithLoaderRetry specifies that GetOrLoad calls the loader up to attempts times until it succeeds, sleeping backoff
+// before the first retry and doubling it before each of the next. The retries stop once the context of GetOrLoad is done.
+func WithLoaderRetry[K comparable, V any](attempts int, backoff time.Duration) Option[K, V] {
+	return &loaderRetryOption[K, V]{attempts: attempts, backoff: backoff}
+}
+
+type loaderRetryOption[K comparable, V any] struct {
+	attempts int
+	backoff  time.Duration
+}
+
+func (o *loaderRetryOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.policy.attempts = o.attempts
+	c.policy.backoff = o.backoff
+}
+
+func (o *loaderRetryOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.policy.attempts = o.attempts
+	c.policy.backoff = o.backoff
+}
+
+// loaderpolicy wraps the loader calls with the timeout and retries specified by options.
+type loaderpolicy struct {
+	timeout  time.Duration
+	attempts int
+	backoff  time.Duration
+}
+
+// do calls fn with the timeout, and retries it with the backoff until it succeeds, the attempts are used up or ctx is done.
+func (p *loaderpolicy) do(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		if p.timeout > 0 {
+			tctx, cancel := context.WithTimeout(ctx, p.timeout)
+			err = fn(tctx)
+			cancel()
+		} else {
+			err = fn(ctx)
+		}
+		if err == nil || attempt >= p.attempts || ctx.Err() != nil {
+			return
+		}
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			backoff *= 2
+		}
+	}
+}
+
+// WithMaxLifetime specifies the max lifetime of entries since set, which caps the expiration extended by sliding.
+// The lifetime is rounded up to whole seconds, and a zero or negative lifetime leaves the sliding uncapped.
+// It has no effect without WithSliding.
+func WithMaxLifetime[K comparable, V any](lifetime time.Duration) Option[K, V] {
+	return &maxLifetimeOption[K, V]{lifetime: lifetime}
+}
+
+type maxLifetimeOption[K comparable, V any] struct {
+	lifetime time.Duration
+}
+
+func (o *maxLifetimeOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+}
+
+func (o *maxLifetimeOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	var lifetime uint32
+	if o.lifetime > 0 {
+		seconds := (o.lifetime-1)/time.Second + 1
+		if seconds > math.MaxUint32 {
+			seconds = math.MaxUint32
+		}
+		lifetime = uint32(seconds)
+	}
+	for i := uint32(0); i <= c.mask; i++ {
+		c.shards[i].lifetime = lifetime
+	}
+}
+
+// WithActiveExpiry specifies the interval of a background sweep, which deletes the expired entries in a bounded
+// number of table buckets of one shard each tick, so that the entries never read again do not hold memory or count in Len.
+// The sweeping goroutine keeps the cache reachable until it is stopped by Close.
+// Only TTLCache starts the sweep.
+func WithActiveExpiry[K comparable, V any](interval time.Duration) Option[K, V] {
+	return &activeExpiryOption[K, V]{interval: interval}
+}
+
+type activeExpiryOption[K comparable, V any] struct {
+	interval time.Duration
+}
+
+func (o *activeExpiryOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+}
+
+func (o *activeExpiryOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.expiry = o.interval
+}
+
+// WithLoadCostTTL specifies the ttl floor of entries loaded by GetOrLoad, which is factor times the load time if it is longer than threshold,
+// so that expensive values are not reloaded as often as cheap ones. The entries without ttl are not affected.
+func WithLoadCostTTL[K comparable, V any](threshold time.Duration, factor float64) Option[K, V] {
+	return &loadCostTTLOption[K, V]{threshold: threshold, factor: factor}
+}
+
+type loadCostTTLOption[K comparable, V any] struct {
+	threshold time.Duration
+	factor    float64
+}
+
+func (o *loadCostTTLOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+}
+
+func (o *loadCostTTLOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.loadCostThreshold = o.threshold
+	c.loadCostFactor = o.factor
+}
+
+// WithShadowPolicy specifies an eviction policy simulated key-only alongside the cache, with the same capacity and the calls of
+// Get, GetHashed, GetOrLoad, Set and SetHashed. Its misses are reported as ShadowMisses of Stats for A/B policy evaluation.
+// The simulation takes a global lock on these calls, so it is intended for evaluation rather than permanent use.
+func WithShadowPolicy[K comparable, V any](policy ShadowPolicy) Option[K, V] {
+	return &shadowPolicyOption[K, V]{policy: policy}
+}
+
+type shadowPolicyOption[K comparable, V any] struct {
+	policy ShadowPolicy
+}
+
+func (o *shadowPolicyOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.shadow = &shadowcache[K]{policy: o.policy}
+}
+
+func (o *shadowPolicyOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.shadow = &shadowcache[K]{policy: o.policy}
+}
+
+// WithHitRatioWindow tracks the hit ratio of Get calls up to the last window for HitRatio, which is not hidden by the
+// lifetime counters of Stats. The tracker takes snapshots of the stats counters in 60 ring buckets of at least 1 second,
+// a snapshot is taken by Get at most once per bucket, so it costs an atomic load on Get otherwise.
+func WithHitRatioWindow[K comparable, V any](window time.Duration) Option[K, V] {
+	return &hitRatioWindowOption[K, V]{window: window}
+}
+
+type hitRatioWindowOption[K comparable, V any] struct {
+	window time.Duration
+}
+
+func (o *hitRatioWindowOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.window = new(hitwindow)
+	c.window.init(o.window)
+}
+
+func (o *hitRatioWindowOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.window = new(hitwindow)
+	c.window.init(o.window)
+}
+
+// WithWeigher bounds LRUCache by the total weight of entries computed by fn, besides the entry count capacity.
+// The maxWeight is split evenly among shards, and the least recent entries of a shard are evicted until its weight fits.
+// An entry heavier than the budget of its shard is handled by WithOversizePolicy, which rejects it by default.
+// The fn must be deterministic for the same key and value.
+func WithWeigher[K comparable, V any](fn func(key K, value V) int, maxWeight int64) Option[K, V] {
+	return &weigherOption[K, V]{fn: fn, maxWeight: maxWeight}
+}
+
+type weigherOption[K comparable, V any] struct {
+	fn        func(key K, value V) int
+	maxWeight int64
+}
+
+func (o *weigherOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	max := (o.maxWeight + int64(c.mask)) / int64(c.mask+1)
+	for i := uint32(0); i <= c.mask; i++ {
+		c.shards[i].weigher = &shardweigher[K, V]{fn: o.fn, max: max, evictAll: c.oversize == OversizeEvictAll}
+	}
+}
+
+func (o *weigherOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+}
+
+// OversizePolicy is the policy of an entry heavier than the weight budget of its shard by WithWeigher,
+// or larger than the bytes budget of its shard by NewBytesCacheWithBudget.
+type OversizePolicy uint8
+
+const (
+	// OversizeReject rejects the entry and deletes the previous value of its key, which is the default.
+	// TrySet reports the rejection by *OversizeError.
+	OversizeReject OversizePolicy = iota
+	// OversizeEvictAll stores the entry and evicts all other entries of its shard, except the pinned ones,
+	// so the shard is over its budget until the entry is evicted by the next entry set to it.
+	OversizeEvictAll
+)
+
+// OversizeError is returned by TrySet when the entry is rejected by OversizeReject, or by WithMaxValueSize of BytesCache.
+type OversizeError struct {
+	Size   int64 // the weight or bytes of the entry
+	Budget int64 // the budget of the shard, or the max value size
+}
+
+func (e *OversizeError) Error() string {
+	return fmt.Sprintf("lru: entry size %d exceeds the budget %d", e.Size, e.Budget)
+}
+
+// WithOversizePolicy specifies the policy of an entry heavier than the weight budget of its shard by WithWeigher.
+// BytesCache takes WithBytesOversizePolicy instead.
+func WithOversizePolicy[K comparable, V any](policy OversizePolicy) Option[K, V] {
+	return &oversizePolicyOption[K, V]{policy: policy}
+}
+
+type oversizePolicyOption[K comparable, V any] struct {
+	policy OversizePolicy
+}
+
+func (o *oversizePolicyOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.oversize = o.policy
+	for i := uint32(0); i <= c.mask; i++ {
+		if c.shards[i].weigher != nil {
+			c.shards[i].weigher.evictAll = o.policy == OversizeEvictAll
+		}
+	}
+}
+
+func (o *oversizePolicyOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+}
+
+// WithTinyLFU specifies a TinyLFU admission filter in front of each shard of LRUCache, which estimates the access
+// frequency of keys by a count-min sketch and a doorkeeper. When a shard is full, a new key is set only if it is
+// accessed more frequently than the least recent entry it would evict, so one-hit keys do not flush the hot entries.
+func WithTinyLFU[K comparable, V any]() Option[K, V] {
+	return &tinyLFUOption[K, V]{}
+}
+
+type tinyLFUOption[K comparable, V any] struct{}
+
+func (o *tinyLFUOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	for i := uint32(0); i <= c.mask; i++ {
+		if c.shards[i].policy == nil {
+			c.shards[i].policy = new(lrupolicy)
+		}
+		c.shards[i].policy.admission = new(tinylfu)
+	}
+}
+
+func (o *tinyLFUOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+}
+
+// WithPolicy specifies the eviction policy of LRUCache shards, the default is PolicyLRU.
+func WithPolicy[K comparable, V any](policy Policy) Option[K, V] {
+	return &policyOption[K, V]{policy: policy}
+}
+
+type policyOption[K comparable, V any] struct {
+	policy Policy
+}
+
+func (o *policyOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	for i := uint32(0); i <= c.mask; i++ {
+		if o.policy != PolicyLRU && c.shards[i].policy == nil {
+			c.shards[i].policy = new(lrupolicy)
+		}
+		if c.shards[i].policy != nil {
+			c.shards[i].policy.slru = o.policy == PolicySLRU
+		}
+	}
+}
+
+func (o *policyOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+}
+
+// WithPromoteAfter specifies that an entry of LRUCache is moved to the list front only after it is hit n times since set,
+// so the streaming one-off reads do not disturb the hot end of the list, while the reused entries are still recognized.
+// The default n is 1, which moves an entry on every hit.
+func WithPromoteAfter[K comparable, V any](n uint8) Option[K, V] {
+	return &promoteAfterOption[K, V]{n: n}
+}
+
+type promoteAfterOption[K comparable, V any] struct {
+	n uint8
+}
+
+func (o *promoteAfterOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	for i := uint32(0); i <= c.mask; i++ {
+		if o.n > 1 && c.shards[i].policy == nil {
+			c.shards[i].policy = new(lrupolicy)
+		}
+		if c.shards[i].policy != nil {
+			c.shards[i].policy.promote = o.n
+		}
+	}
+}
+
+func (o *promoteAfterOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+}
+
+// WithReadTransform specifies the function which transforms the values read from LRUCache, e.g. decoding the
+// stored bytes. All the reads, including Peek, GetOrLoad, GetMulti, GetOrSet and Range, return the transformed value,
+// which is memoized beside the stored value until the key is set again, so fn is called once per set value under
+// the shard lock. The writes, the weigher and the eviction callback see the stored value.
+func WithReadTransform[K comparable, V any](fn func(value V) V) Option[K, V] {
+	return &readTransformOption[K, V]{fn: fn}
+}
+
+type readTransformOption[K comparable, V any] struct {
+	fn func(value V) V
+}
+
+func (o *readTransformOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	c.transform = o.fn
+}
+
+func (o *readTransformOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+}
+
+// WithEvictionCallback specifies the function called when an entry is evicted by capacity pressure or EvictToWatermark.
+// It is called under the shard lock, so it must be fast and must not call methods of the cache.
+// The expired entries and the markers set by SetNegative and Tombstone of TTLCache are not reported,
+// use WithRemovalListener to observe the expired entries.
+func WithEvictionCallback[K comparable, V any](fn func(key K, value V)) Option[K, V] {
+	return &evictionCallbackOption[K, V]{fn: fn}
+}
+
+type evictionCallbackOption[K comparable, V any] struct {
+	fn func(key K, value V)
+}
+
+func (o *evictionCallbackOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	for i := uint32(0); i <= c.mask; i++ {
+		if c.shards[i].removal == nil {
+			c.shards[i].removal = new(shardremoval[K, V])
+		}
+		c.shards[i].removal.evicted = o.fn
+	}
+}
+
+func (o *evictionCallbackOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	for i := uint32(0); i <= c.mask; i++ {
+		if c.shards[i].removal == nil {
+			c.shards[i].removal = new(shardremoval[K, V])
+		}
+		c.shards[i].removal.evicted = o.fn
+	}
+}
+
+// RemovalReason is the reason why an entry is removed from cache.
+type RemovalReason uint8
+
+const (
+	// RemovalExpired means the entry is expired by ttl or BumpEpoch.
+	RemovalExpired RemovalReason = iota + 1
+	// RemovalEvicted means the entry is evicted by capacity pressure or EvictToWatermark.
+	RemovalEvicted
+	// RemovalDeleted means the entry is deleted by Delete or InvalidateBefore.
+	RemovalDeleted
+	// RemovalReplaced means the entry value is replaced by Set.
+	RemovalReplaced
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovalExpired:
+		return "Expired"
+	case RemovalEvicted:
+		return "Evicted"
+	case RemovalDeleted:
+		return "Deleted"
+	case RemovalReplaced:
+		return "Replaced"
+	}
+	return "Unknown"
+}
+
+// WithRemovalListener specifies the function called when an entry is removed from TTLCache, with the removal reason.
+// It is called under the shard lock, so it must be fast and must not call methods of the cache.
+// The expired entries are reclaimed lazily, so they are reported when accessed, overwritten or evicted.
+// It is a no-op for LRUCache, use WithEvictionCallback instead.
+func WithRemovalListener[K comparable, V any](fn func(key K, value V, reason RemovalReason)) Option[K, V] {
+	return &removalListenerOption[K, V]{fn: fn}
+}
+
+type removalListenerOption[K comparable, V any] struct {
+	fn func(key K, value V, reason RemovalReason)
+}
+
+func (o *removalListenerOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+}
+
+func (o *removalListenerOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	for i := uint32(0); i <= c.mask; i++ {
+		if c.shards[i].removal == nil {
+			c.shards[i].removal = new(shardremoval[K, V])
+		}
+		c.shards[i].removal.listener = o.fn
+	}
+}
+
+// WithIndex specifies a secondary index named name, which maps the attribute of values computed by fn to keys for GetByIndex.
+// The index is maintained by Set and Delete, and the keys removed by eviction or expiration are dropped lazily.
+// The attributes are map keys, so if I is an interface type, fn must return comparable dynamic types, e.g. not slices,
+// otherwise Set panics as comparing or hashing them does.
+func WithIndex[K comparable, V any, I comparable](name string, fn func(value V) I) Option[K, V] {
+	return &indexOption[K, V]{name: name, fn: func(value V) any { return fn(value) }}
+}
+
+type indexOption[K comparable, V any] struct {
+	name string
+	fn   func(value V) any
+}
+
+func (o *indexOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+	if c.indexes == nil {
+		c.indexes = make(map[string]*valueindex[K, V])
+	}
+	c.indexes[o.name] = &valueindex[K, V]{fn: o.fn}
+}
+
+func (o *indexOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	if c.indexes == nil {
+		c.indexes = make(map[string]*valueindex[K, V])
+	}
+	c.indexes[o.name] = &valueindex[K, V]{fn: o.fn}
+}
+
+// WithStrictExpiry specifies that AppendKeys, ScanKeys, ForEachParallel and StartRevalidator of TTLCache treat an entry
+// as expired at the second of its expiration, the same cutoff as Get, instead of until the second passes.
+// Len still counts the expired entries not removed yet, see AllKeys and LiveKeys.
+func WithStrictExpiry[K comparable, V any](strict bool) Option[K, V] {
+	return &strictExpiryOption[K, V]{strict: strict}
+}
+
+type strictExpiryOption[K comparable, V any] struct {
+	strict bool
+}
+
+func (o *strictExpiryOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+}
+
+func (o *strictExpiryOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.strictExpiry = o.strict
+}
+
+// WithTTLFromValue specifies the function deriving ttl from the value itself, it applies when Set or loader of TTLCache is given a zero ttl.
+func WithTTLFromValue[K comparable, V any](fn func(value V) time.Duration) Option[K, V] {
+	return &ttlFromValueOption[K, V]{fn: fn}
+}
+
+type ttlFromValueOption[K comparable, V any] struct {
+	fn func(value V) time.Duration
+}
+
+func (o *ttlFromValueOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+}
+
+func (o *ttlFromValueOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.ttlFromValue = o.fn
+}
+
+// WithDefaultTTL specifies the ttl of TTLCache entries set with a zero ttl, which is never expiring when it is absent.
+// The ttl derived by WithTTLFromValue takes precedence, and a negative ttl still sets an entry never expiring.
+func WithDefaultTTL[K comparable, V any](ttl time.Duration) Option[K, V] {
+	return &defaultTTLOption[K, V]{ttl: ttl}
+}
+
+type defaultTTLOption[K comparable, V any] struct {
+	ttl time.Duration
+}
+
+func (o *defaultTTLOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+}
+
+func (o *defaultTTLOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.defaultTTL = o.ttl
+}
+
+// WithMaxTTL specifies the max ttl of TTLCache entries, a longer ttl given to Set, SetNegative and Tombstone or returned by the loader
+// is clamped silently, and so are the entries which would never expire and a later expiration given to SetExpires.
+func WithMaxTTL[K comparable, V any](ttl time.Duration) Option[K, V] {
+	return &maxTTLOption[K, V]{ttl: ttl}
+}
+
+type maxTTLOption[K comparable, V any] struct {
+	ttl time.Duration
+}
+
+func (o *maxTTLOption[K, V]) applyToLRUCache(c *LRUCache[K, V]) {
+}
+
+func (o *maxTTLOption[K, V]) applyToTTLCache(c *TTLCache[K, V]) {
+	c.maxTTL = o.ttl
+}
+
+// MaxShardSize is the max number of entries in a shard, the node indexes and the table size of shards are uint32.
+const MaxShardSize = 1 << 30
+
+// SizeError is the error of an invalid cache size, it is returned by TryNewLRUCache and TryNewTTLCache,
+// and panicked by the other cache constructors.
+type SizeError struct {
+	Size   int
+	Shards uint32
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("lru: invalid size %d for %d shards, the size of each shard must be in [0, %d]", e.Size, e.Shards, MaxShardSize)
+}
+
+// getShardSize returns the size of each shard, or *SizeError if the size is out of range.
+func getShardSize(size int, shards uint32) (uint32, error) {
+	if size < 0 || (uint64(size)+uint64(shards)-1)/uint64(shards) > MaxShardSize {
+		return 0, &SizeError{Size: size, Shards: shards}
+	}
+	return uint32((uint64(size) + uint64(shards) - 1) / uint64(shards)), nil
+}
+
+// Result is the result of GetOrLoadChan, its fields are the same as the results of GetOrLoad.
+type Result[V any] struct {
+	Value V
+	Err   error
+	OK    bool
+}
+
+// Entry is a key value pair with ttl for batch operations, the TTL is ignored by LRUCache.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+	TTL   time.Duration
+}
+
+// shardOrder returns the indexes of hashes sorted by shard, for grouping batch operations by shard.
+func shardOrder(hashes []uint32, mask uint32) []int {
+	order := make([]int, len(hashes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return hashes[order[i]]&mask < hashes[order[j]]&mask
+	})
+	return order
 }
 
 func nextPowOf2(n uint32) uint32 {
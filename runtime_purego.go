@@ -0,0 +1,127 @@
+// This file is the fallback of runtime.go, for the purego build tag, toolchains
+// without go:linkname (e.g. tinygo and gccgo) and go versions whose runtime
+// internals are not yet known to runtime.go.
+
+//go:build purego || go1.25
+
+package lru
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+// fastrand64 returns a random uint64, by the random seed of a zero maphash.Hash.
+func fastrand64() uint64 {
+	var h maphash.Hash
+	return h.Sum64()
+}
+
+var puregoSeed = maphash.MakeSeed()
+
+// getRuntimeHasher returns a hasher for type K built on hash/maphash, which
+// hashes the key memory directly for the integer-like types and walks the
+// other types by reflection. It is slower than the hasher of the runtime but
+// hashes keys equal by == to the same value as well.
+func getRuntimeHasher[K comparable]() func(key unsafe.Pointer, seed uintptr) uintptr {
+	typ := reflect.TypeOf((*K)(nil)).Elem()
+	switch typ.Kind() {
+	case reflect.String:
+		return func(key unsafe.Pointer, seed uintptr) uintptr {
+			var h maphash.Hash
+			h.SetSeed(puregoSeed)
+			puregoWriteUint64(&h, uint64(seed))
+			h.WriteString(*(*string)(key))
+			return uintptr(h.Sum64())
+		}
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Chan, reflect.Pointer, reflect.UnsafePointer:
+		size := typ.Size()
+		return func(key unsafe.Pointer, seed uintptr) uintptr {
+			var h maphash.Hash
+			h.SetSeed(puregoSeed)
+			puregoWriteUint64(&h, uint64(seed))
+			h.Write(unsafe.Slice((*byte)(key), size))
+			return uintptr(h.Sum64())
+		}
+	}
+	return func(key unsafe.Pointer, seed uintptr) uintptr {
+		var h maphash.Hash
+		h.SetSeed(puregoSeed)
+		puregoWriteUint64(&h, uint64(seed))
+		puregoWriteValue(&h, reflect.NewAt(typ, key).Elem())
+		return uintptr(h.Sum64())
+	}
+}
+
+func puregoWriteUint64(h *maphash.Hash, x uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], x)
+	h.Write(b[:])
+}
+
+// puregoWriteFloat64 writes f with -0 as +0, since they are equal by ==.
+func puregoWriteFloat64(h *maphash.Hash, f float64) {
+	if f == 0 {
+		f = 0
+	}
+	puregoWriteUint64(h, math.Float64bits(f))
+}
+
+// puregoWriteValue writes v of a comparable type, the way == compares it.
+func puregoWriteValue(h *maphash.Hash, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		h.WriteString(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			h.WriteByte(1)
+		} else {
+			h.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		puregoWriteUint64(h, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		puregoWriteUint64(h, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		puregoWriteFloat64(h, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		puregoWriteFloat64(h, real(c))
+		puregoWriteFloat64(h, imag(c))
+	case reflect.Chan, reflect.Pointer, reflect.UnsafePointer:
+		puregoWriteUint64(h, uint64(v.Pointer()))
+	case reflect.Interface:
+		if !v.IsNil() {
+			puregoWriteValue(h, v.Elem())
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			puregoWriteValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			// blank fields are ignored by ==
+			if t.Field(i).Name != "_" {
+				puregoWriteValue(h, v.Field(i))
+			}
+		}
+	default:
+		panic("lru: unhashable type " + v.Type().String())
+	}
+}
+
+// noescape is the identity function, which does not hide p from escape
+// analysis as runtime.go does.
+func noescape(p unsafe.Pointer) unsafe.Pointer {
+	return p
+}
+
+func b2s(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
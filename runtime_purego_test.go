@@ -0,0 +1,55 @@
+//go:build purego || go1.25
+
+package lru
+
+import (
+	"math"
+	"testing"
+	"unsafe"
+)
+
+func TestPuregoHasher(t *testing.T) {
+	type key struct {
+		s string
+		f float64
+		i [2]int32
+		_ int
+	}
+
+	hasher := getRuntimeHasher[key]()
+	hash := func(k key, seed uintptr) uintptr {
+		return hasher(unsafe.Pointer(&k), seed)
+	}
+
+	a := key{s: "a", f: 0, i: [2]int32{1, 2}}
+	b := key{s: "a", f: math.Copysign(0, -1), i: [2]int32{1, 2}}
+	if a != b {
+		t.Fatalf("%v should be equal to %v", a, b)
+	}
+	if hash(a, 42) != hash(b, 42) {
+		t.Errorf("equal keys %v and %v should have the same hash", a, b)
+	}
+	if hash(a, 42) == hash(key{s: "b", i: [2]int32{1, 2}}, 42) {
+		t.Errorf("keys %v and %v should not have the same hash", a, key{s: "b", i: [2]int32{1, 2}})
+	}
+	if hash(a, 42) == hash(a, 43) {
+		t.Errorf("key %v should have different hashes for different seeds", a)
+	}
+
+	cache := NewLRUCache[key, int](128)
+	cache.Set(a, 1)
+	if v, ok := cache.Get(b); !ok || v != 1 {
+		t.Fatalf("bad returned value: %v != %v", v, 1)
+	}
+}
+
+func TestPuregoHasherInt(t *testing.T) {
+	hasher := getRuntimeHasher[int]()
+	x, y := 1, 1
+	if hasher(unsafe.Pointer(&x), 0) != hasher(unsafe.Pointer(&y), 0) {
+		t.Errorf("equal keys %v and %v should have the same hash", x, y)
+	}
+	if y = 2; hasher(unsafe.Pointer(&x), 0) == hasher(unsafe.Pointer(&y), 0) {
+		t.Errorf("keys %v and %v should not have the same hash", x, y)
+	}
+}
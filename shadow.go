@@ -0,0 +1,112 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ShadowPolicy is the eviction policy simulated by the shadow cache.
+type ShadowPolicy uint8
+
+const (
+	// ShadowSIEVE simulates SIEVE, which keeps a visited bit per key and evicts the first unvisited key from the hand.
+	ShadowSIEVE ShadowPolicy = iota + 1
+	// ShadowFIFO simulates FIFO, which evicts the oldest inserted key.
+	ShadowFIFO
+)
+
+// shadowcache simulates another eviction policy key-only alongside the live cache, and counts its misses.
+type shadowcache[K comparable] struct {
+	misses uint64 // keep first for 64-bit atomic alignment
+	policy ShadowPolicy
+
+	mu    sync.Mutex
+	index map[K]uint32
+	nodes []shadownode[K] // nodes[0] is the sentinel of the queue, the head is nodes[0].next
+	free  uint32
+	hand  uint32
+}
+
+type shadownode[K comparable] struct {
+	key     K
+	next    uint32
+	prev    uint32
+	visited bool
+}
+
+func (s *shadowcache[K]) init(size int) {
+	if size < 1 {
+		size = 1
+	}
+	s.index = make(map[K]uint32, size)
+	s.nodes = make([]shadownode[K], size+1)
+	s.free = 1
+}
+
+// get simulates a Get of key, and counts the miss.
+func (s *shadowcache[K]) get(key K) {
+	s.mu.Lock()
+	if i, ok := s.index[key]; ok {
+		s.nodes[i].visited = true
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+	s.mu.Unlock()
+}
+
+// set simulates a Set of key.
+func (s *shadowcache[K]) set(key K) {
+	s.mu.Lock()
+	if i, ok := s.index[key]; ok {
+		s.nodes[i].visited = true
+		s.mu.Unlock()
+		return
+	}
+
+	var i uint32
+	if s.free < uint32(len(s.nodes)) {
+		i = s.free
+		s.free++
+	} else {
+		i = s.evict()
+	}
+
+	// insert to the head
+	node := &s.nodes[i]
+	node.key = key
+	node.visited = false
+	node.prev = 0
+	node.next = s.nodes[0].next
+	s.nodes[node.next].prev = i
+	s.nodes[0].next = i
+	s.index[key] = i
+
+	s.mu.Unlock()
+}
+
+// evict removes a key by policy and returns its node, the caller must hold the lock.
+func (s *shadowcache[K]) evict() (i uint32) {
+	// the tail is nodes[0].prev
+	i = s.nodes[0].prev
+	if s.policy == ShadowSIEVE {
+		if s.hand != 0 {
+			i = s.hand
+		}
+		for s.nodes[i].visited {
+			s.nodes[i].visited = false
+			if i = s.nodes[i].prev; i == 0 {
+				i = s.nodes[0].prev
+			}
+		}
+		s.hand = s.nodes[i].prev
+	}
+
+	node := &s.nodes[i]
+	s.nodes[node.prev].next = node.next
+	s.nodes[node.next].prev = node.prev
+	delete(s.index, node.key)
+
+	return
+}
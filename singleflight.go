@@ -4,7 +4,15 @@
 
 package lru
 
-import "sync"
+import (
+	"errors"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// errGoexit is returned to the waiters if the loader calls runtime.Goexit.
+var errGoexit = errors.New("lru: loader called runtime.Goexit")
 
 // singleflightCall is an in-flight or completed singleflight.Do singleflightCall
 type singleflightCall[T any] struct {
@@ -18,7 +26,17 @@ type singleflightCall[T any] struct {
 	// These fields are read and written with the singleflight
 	// mutex held before the WaitGroup is done, and are read but
 	// not written after the WaitGroup is done.
-	dups int
+	dups  int
+	chans []chan<- Result[T]
+
+	// forgotten is set by Forget with the singleflight mutex held.
+	forgotten bool
+
+	// committed is set by commit with the singleflight mutex held, and closed once its fn returns.
+	committed chan struct{}
+
+	// start is the time when the call began, only recorded if the group has a busy threshold.
+	start time.Time
 }
 
 // Group represents a class of work and forms a namespace in
@@ -26,6 +44,8 @@ type singleflightCall[T any] struct {
 type singleflightGroup[K comparable, V any] struct {
 	mu sync.Mutex                 // protects m
 	m  map[K]*singleflightCall[V] // lazily initialized
+
+	busy time.Duration // duplicates fail fast if the call has been running longer than busy
 }
 
 // Do executes and returns the results of the given function, making
@@ -33,12 +53,18 @@ type singleflightGroup[K comparable, V any] struct {
 // time. If a duplicate comes in, the duplicate singleflight_caller waits for the
 // original to complete and receives the same results.
 // The return value shared indicates whether v was given to multiple singleflight_callers.
-func (g *singleflightGroup[K, V]) Do(key K, fn func() (V, error)) (v V, err error, shared bool) {
+// The fn is passed its call for commit.
+func (g *singleflightGroup[K, V]) Do(key K, fn func(c *singleflightCall[V]) (V, error)) (v V, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[K]*singleflightCall[V])
 	}
 	if c, ok := g.m[key]; ok {
+		if g.busy > 0 && time.Since(c.start) > g.busy {
+			g.mu.Unlock()
+			err = ErrLoaderBusy
+			return
+		}
 		c.dups++
 		g.mu.Unlock()
 		c.wg.Wait()
@@ -46,6 +72,9 @@ func (g *singleflightGroup[K, V]) Do(key K, fn func() (V, error)) (v V, err erro
 	}
 	c := new(singleflightCall[V])
 	c.wg.Add(1)
+	if g.busy > 0 {
+		c.start = time.Now()
+	}
 	g.m[key] = c
 	g.mu.Unlock()
 
@@ -53,12 +82,99 @@ func (g *singleflightGroup[K, V]) Do(key K, fn func() (V, error)) (v V, err erro
 	return c.val, c.err, c.dups > 0
 }
 
+// DoChan is like Do but sends the result to ch instead of blocking, ch must be buffered.
+func (g *singleflightGroup[K, V]) DoChan(key K, fn func(c *singleflightCall[V]) (V, error), ch chan<- Result[V]) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[K]*singleflightCall[V])
+	}
+	if c, ok := g.m[key]; ok {
+		if g.busy > 0 && time.Since(c.start) > g.busy {
+			g.mu.Unlock()
+			ch <- Result[V]{Err: ErrLoaderBusy}
+			return
+		}
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return
+	}
+	c := &singleflightCall[V]{chans: []chan<- Result[V]{ch}}
+	c.wg.Add(1)
+	if g.busy > 0 {
+		c.start = time.Now()
+	}
+	g.m[key] = c
+	g.mu.Unlock()
+
+	go g.doCall(c, key, fn)
+}
+
 // doCall handles the single singleflightCall for a key.
-func (g *singleflightGroup[K, V]) doCall(c *singleflightCall[V], key K, fn func() (V, error)) {
-	c.val, c.err = fn()
-	c.wg.Done()
+// A panic of fn is recovered and returned to all waiters as *PanicError, and the call is removed either way.
+func (g *singleflightGroup[K, V]) doCall(c *singleflightCall[V], key K, fn func(c *singleflightCall[V]) (V, error)) {
+	normalReturn := false
+	defer func() {
+		if !normalReturn {
+			if r := recover(); r != nil {
+				c.err = &PanicError{Value: r, Stack: debug.Stack()}
+			} else {
+				c.err = errGoexit
+			}
+		}
+		c.wg.Done()
+
+		g.mu.Lock()
+		if !c.forgotten {
+			delete(g.m, key)
+		}
+		for _, ch := range c.chans {
+			ch <- Result[V]{Value: c.val, Err: c.err, OK: c.dups > 0}
+		}
+		g.mu.Unlock()
+	}()
+
+	c.val, c.err = fn(c)
+	normalReturn = true
+}
+
+// commit calls fn unless the call is forgotten. The fn runs without the singleflight mutex held,
+// and Forget waits for it, so a call never commits its result after Forget returns.
+func (g *singleflightGroup[K, V]) commit(c *singleflightCall[V], fn func()) {
+	g.mu.Lock()
+	if c.forgotten {
+		g.mu.Unlock()
+		return
+	}
+	c.committed = make(chan struct{})
+	g.mu.Unlock()
+
+	defer close(c.committed)
+	fn()
+}
+
+// Forget tells the singleflight to forget about a key. Future calls
+// to Do for this key will call the function rather than waiting for
+// an earlier call to complete. If the call is committing its result, Forget waits for the commit.
+func (g *singleflightGroup[K, V]) Forget(key K) {
+	var committed chan struct{}
+	g.mu.Lock()
+	if c, ok := g.m[key]; ok {
+		c.forgotten = true
+		committed = c.committed
+		delete(g.m, key)
+	}
+	g.mu.Unlock()
+
+	if committed != nil {
+		<-committed
+	}
+}
 
+// Len returns the number of in-flight calls.
+func (g *singleflightGroup[K, V]) Len() (n int) {
 	g.mu.Lock()
-	delete(g.m, key)
+	n = len(g.m)
 	g.mu.Unlock()
+	return
 }
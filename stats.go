@@ -1,5 +1,10 @@
 package lru
 
+import (
+	"math/bits"
+	"time"
+)
+
 // Stats represents cache stats.
 type Stats struct {
 	// GetCalls is the number of Get calls.
@@ -13,4 +18,79 @@ type Stats struct {
 
 	// EntriesCount is the current number of entries in the cache.
 	EntriesCount uint64
+
+	// Evictions is the number of unexpired entries evicted by capacity pressure or EvictToWatermark.
+	Evictions uint64
+
+	// Expired is the number of expired entries reclaimed, at lookup or eviction.
+	Expired uint64
+
+	// ShadowMisses is the number of misses of the policy simulated by WithShadowPolicy,
+	// comparing it with Misses tells which policy has a higher hit ratio.
+	ShadowMisses uint64
+
+	// BytesUsed is the current total length of keys and values in the cache, it is only reported by BytesCache.
+	BytesUsed uint64
+
+	// Rejections is the number of values rejected or truncated by WithMaxValueSize, it is only reported by BytesCache.
+	Rejections uint64
+}
+
+// Delta returns the stats counters increased since prev, EntriesCount and BytesUsed are kept as current values.
+// A counter less than its previous value is treated as being reset, the current value is returned for it.
+func (s Stats) Delta(prev Stats) Stats {
+	return Stats{
+		GetCalls:     statsDelta(s.GetCalls, prev.GetCalls),
+		SetCalls:     statsDelta(s.SetCalls, prev.SetCalls),
+		Misses:       statsDelta(s.Misses, prev.Misses),
+		EntriesCount: s.EntriesCount,
+		Evictions:    statsDelta(s.Evictions, prev.Evictions),
+		Expired:      statsDelta(s.Expired, prev.Expired),
+		ShadowMisses: statsDelta(s.ShadowMisses, prev.ShadowMisses),
+		BytesUsed:    s.BytesUsed,
+		Rejections:   statsDelta(s.Rejections, prev.Rejections),
+	}
+}
+
+// shardremoval holds the removal callbacks and counters of a shard, it is kept out of the shard to fit the cache line.
+type shardremoval[K comparable, V any] struct {
+	evictions uint64 // keep first for 64-bit atomic alignment
+	expired   uint64
+
+	evicted  func(key K, value V)
+	listener func(key K, value V, reason RemovalReason)
+}
+
+// Snapshot returns the stats with current timestamp.
+func (s Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{Stats: s, Time: time.Now()}
+}
+
+func statsDelta(current, prev uint64) uint64 {
+	if current < prev {
+		return current
+	}
+	return current - prev
+}
+
+// StatsSnapshot represents cache stats taken at a point in time.
+type StatsSnapshot struct {
+	Stats Stats
+	Time  time.Time
+}
+
+// Delta returns the stats delta and the elapsed time since prev, for computing per-interval rates.
+func (s StatsSnapshot) Delta(prev StatsSnapshot) (delta Stats, elapsed time.Duration) {
+	return s.Stats.Delta(prev.Stats), s.Time.Sub(prev.Time)
+}
+
+// SizeHistogram counts sizes in power of two classes, the class i counts the sizes in [1<<i>>1, 1<<i),
+// so the class 0 counts the size 0, and the last class also counts all larger sizes.
+type SizeHistogram [32]uint64
+
+func sizeClass(size int) int {
+	if n := bits.Len(uint(size)); n < len(SizeHistogram{}) {
+		return n
+	}
+	return len(SizeHistogram{}) - 1
 }
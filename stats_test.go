@@ -0,0 +1,39 @@
+package lru
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsDelta(t *testing.T) {
+	prev := Stats{GetCalls: 10, SetCalls: 5, Misses: 3, EntriesCount: 5, Evictions: 2, Expired: 1}
+	stats := Stats{GetCalls: 15, SetCalls: 9, Misses: 4, EntriesCount: 7, Evictions: 4, Expired: 1}
+
+	if got, want := stats.Delta(prev), (Stats{GetCalls: 5, SetCalls: 4, Misses: 1, EntriesCount: 7, Evictions: 2}); got != want {
+		t.Fatalf("stats delta should be %+v: %+v", want, got)
+	}
+
+	reset := Stats{GetCalls: 2, SetCalls: 1, Misses: 1, EntriesCount: 7}
+	if got, want := reset.Delta(stats), reset; got != want {
+		t.Fatalf("stats delta after reset should be %+v: %+v", want, got)
+	}
+}
+
+func TestStatsSnapshotDelta(t *testing.T) {
+	cache := NewLRUCache[string, int](256)
+
+	cache.Set("a", 1)
+	prev := cache.Stats().Snapshot()
+
+	cache.Get("a")
+	cache.Get("b")
+	time.Sleep(10 * time.Millisecond)
+
+	delta, elapsed := cache.Stats().Snapshot().Delta(prev)
+	if delta.GetCalls != 2 || delta.SetCalls != 0 || delta.Misses != 1 || delta.EntriesCount != 1 {
+		t.Fatalf("bad stats delta: %+v", delta)
+	}
+	if elapsed < 10*time.Millisecond {
+		t.Fatalf("bad elapsed time: %v", elapsed)
+	}
+}
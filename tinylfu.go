@@ -0,0 +1,98 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+// tinylfu is the admission filter of TinyLFU, which estimates the access frequency of keys by a count-min sketch
+// of 4-bit counters behind a doorkeeper bloom filter, and halves the counters periodically to age out old accesses.
+// It is not thread safe, the caller must hold the shard lock.
+type tinylfu struct {
+	sketch []uint64 // 16 counters of 4-bit per word
+	door   []uint64 // the doorkeeper bits
+	adds   uint32
+	sample uint32 // halve the counters after sample additions
+}
+
+func (f *tinylfu) init(size uint32) {
+	// bound the sketch memory of huge shards.
+	if size > 1<<26 {
+		size = 1 << 26
+	}
+	counters := nextPowOf2(size * 16)
+	if counters < 64 {
+		counters = 64
+	}
+	f.sketch = make([]uint64, counters/16)
+	f.door = make([]uint64, counters/64)
+	f.adds = 0
+	f.sample = size * 10
+}
+
+// mix spreads the hash bits, since the low bits of hashes in a shard are the same shard index.
+func (f *tinylfu) mix(hash uint32) uint32 {
+	hash ^= hash >> 16
+	hash *= 0x45d9f3b
+	hash ^= hash >> 16
+	return hash
+}
+
+// increment records an access of hash, the first access in a sample period only sets the doorkeeper.
+func (f *tinylfu) increment(hash uint32) {
+	h := f.mix(hash)
+
+	bits := uint32(len(f.door)*64 - 1)
+	d1, d2 := h&bits, (h>>16|h<<16)&bits
+	if f.door[d1/64]&(1<<(d1%64)) == 0 || f.door[d2/64]&(1<<(d2%64)) == 0 {
+		f.door[d1/64] |= 1 << (d1 % 64)
+		f.door[d2/64] |= 1 << (d2 % 64)
+	} else {
+		counters := uint32(len(f.sketch)*16 - 1)
+		for i, step := uint32(0), h>>16|1; i < 4; i++ {
+			c := (h + i*step) & counters
+			word, shift := &f.sketch[c/16], (c%16)*4
+			if (*word>>shift)&0xf < 15 {
+				*word += 1 << shift
+			}
+		}
+	}
+
+	if f.adds++; f.adds >= f.sample {
+		f.reset()
+	}
+}
+
+// estimate returns the access frequency of hash.
+func (f *tinylfu) estimate(hash uint32) (n uint32) {
+	h := f.mix(hash)
+
+	n = 15
+	counters := uint32(len(f.sketch)*16 - 1)
+	for i, step := uint32(0), h>>16|1; i < 4; i++ {
+		c := (h + i*step) & counters
+		if v := uint32(f.sketch[c/16]>>((c%16)*4)) & 0xf; v < n {
+			n = v
+		}
+	}
+
+	bits := uint32(len(f.door)*64 - 1)
+	d1, d2 := h&bits, (h>>16|h<<16)&bits
+	if f.door[d1/64]&(1<<(d1%64)) != 0 && f.door[d2/64]&(1<<(d2%64)) != 0 {
+		n++
+	}
+	return
+}
+
+// admit reports whether the candidate is more frequent than the victim which it would evict.
+func (f *tinylfu) admit(candidate, victim uint32) bool {
+	return f.estimate(candidate) > f.estimate(victim)
+}
+
+// reset halves the counters and clears the doorkeeper.
+func (f *tinylfu) reset() {
+	for i := range f.sketch {
+		f.sketch[i] = (f.sketch[i] >> 1) & 0x7777777777777777
+	}
+	for i := range f.door {
+		f.door[i] = 0
+	}
+	f.adds /= 2
+}
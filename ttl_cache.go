@@ -4,6 +4,8 @@ package lru
 
 import (
 	"context"
+	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -15,12 +17,53 @@ type TTLCache[K comparable, V any] struct {
 	mask   uint32
 	hasher func(key unsafe.Pointer, seed uintptr) uintptr
 	seed   uintptr
+	equal  func(a, b K) bool
 	loader func(ctx context.Context, key K) (value V, ttl time.Duration, err error)
 	group  singleflightGroup[K, V]
+	policy loaderpolicy
+
+	sampler *missSampler[K]
+	shadow  *shadowcache[K]
+	window  *hitwindow
+
+	locks []sync.Mutex
+
+	ttlFromValue func(value V) time.Duration
+	defaultTTL   time.Duration
+	maxTTL       time.Duration
+
+	// the ttl floor of loaded entries, factor times the load time if it is longer than threshold.
+	loadCostThreshold time.Duration
+	loadCostFactor    float64
+
+	tags  tagindex[K]
+	links linkindex[K]
+
+	indexes map[string]*valueindex[K, V]
+
+	// the key iteration treats entries as expired at their expires second as Get, see WithStrictExpiry.
+	strictExpiry bool
+
+	// the interval of the background sweep of expired entries, 0 if it is disabled.
+	expiry time.Duration
+	// the sweep returns once stop is closed by Close, and closes done.
+	stop, done chan struct{}
+	closed     sync.Once
 }
 
 // NewTTLCache creates lru cache with size capacity.
+// It panics with *SizeError if size is negative or the size of each shard exceeds MaxShardSize.
 func NewTTLCache[K comparable, V any](size int, options ...Option[K, V]) *TTLCache[K, V] {
+	c, err := TryNewTTLCache(size, options...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// TryNewTTLCache creates lru cache with size capacity as NewTTLCache, but returns *SizeError instead of panicking
+// if size is negative or the size of each shard exceeds MaxShardSize.
+func TryNewTTLCache[K comparable, V any](size int, options ...Option[K, V]) (*TTLCache[K, V], error) {
 	clocking()
 
 	j := -1
@@ -41,6 +84,11 @@ func NewTTLCache[K comparable, V any](size int, options ...Option[K, V]) *TTLCac
 		o.applyToTTLCache(c)
 	}
 
+	shardsize, err := getShardSize(size, c.mask+1)
+	if err != nil {
+		return nil, err
+	}
+
 	if c.hasher == nil {
 		c.hasher = getRuntimeHasher[K]()
 	}
@@ -48,59 +96,277 @@ func NewTTLCache[K comparable, V any](size int, options ...Option[K, V]) *TTLCac
 		c.seed = uintptr(fastrand64())
 	}
 
+	c.locks = make([]sync.Mutex, c.mask+1)
+
+	if c.shadow != nil {
+		c.shadow.init(size)
+	}
+
 	if isamd64 {
 		// pre-alloc lists and tables for compactness
-		shardsize := (uint32(size) + c.mask) / (c.mask + 1)
-		shardlists := make([]ttlnode[K, V], (shardsize+1)*(c.mask+1))
-		tablesize := ttlNewTableSize(uint32(shardsize))
-		tablebuckets := make([]uint64, tablesize*(c.mask+1))
-		for i := uint32(0); i <= c.mask; i++ {
-			c.shards[i].list = shardlists[i*(shardsize+1) : (i+1)*(shardsize+1)]
+		// compute the offsets in int, the total of shards may exceed uint32.
+		listsize, tablesize := int(shardsize)+1, int(ttlNewTableSize(shardsize))
+		shardlists := make([]ttlnode[K, V], listsize*int(c.mask+1))
+		tablebuckets := make([]uint64, tablesize*int(c.mask+1))
+		for i := 0; i <= int(c.mask); i++ {
+			c.shards[i].list = shardlists[i*listsize : (i+1)*listsize]
 			c.shards[i].tableBuckets = tablebuckets[i*tablesize : (i+1)*tablesize]
 			c.shards[i].Init(shardsize, c.hasher, c.seed)
+			c.shards[i].tableEqual = c.equal
 		}
 	} else {
-		shardsize := (uint32(size) + c.mask) / (c.mask + 1)
 		for i := uint32(0); i <= c.mask; i++ {
 			c.shards[i].Init(shardsize, c.hasher, c.seed)
+			c.shards[i].tableEqual = c.equal
 		}
 	}
 
-	return c
+	if c.expiry > 0 {
+		c.stop, c.done = make(chan struct{}), make(chan struct{})
+		go c.sweep(c.expiry)
+	}
+
+	return c, nil
+}
+
+// activeExpiryBuckets is the number of table buckets swept by each tick of the active expiry.
+const activeExpiryBuckets = 1024
+
+// sweep deletes the expired entries in activeExpiryBuckets buckets each tick of interval, walking the shards in turn.
+// It returns when c.stop is closed.
+func (c *TTLCache[K, V]) sweep(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pos uint32
+	for i := uint32(0); ; {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		if pos, _ = c.shards[i].DeleteExpired(pos, activeExpiryBuckets, atomic.LoadUint32(&clock)); pos == 0 {
+			i = (i + 1) & c.mask
+		}
+	}
+}
+
+// Close stops the background sweep started by WithActiveExpiry and waits for it to return, so the cache could be
+// garbage collected. The cache is still usable after Close, and its expired entries are reclaimed lazily as without
+// WithActiveExpiry. It is safe to call Close more than once, and it does nothing for a cache without WithActiveExpiry.
+func (c *TTLCache[K, V]) Close() {
+	c.closed.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+			<-c.done
+		}
+	})
 }
 
 // Get returns value for key.
 func (c *TTLCache[K, V]) Get(key K) (value V, ok bool) {
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	// return c.shards[hash&c.mask].Get(hash, key)
-	return (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Get(hash, key)
+	// value, ok = c.shards[hash&c.mask].Get(hash, key)
+	value, ok = (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Get(hash, key)
+	if !ok && c.sampler != nil && c.sampler.sample() {
+		c.sampler.fn(key, c.Stats())
+	}
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
+	if c.window != nil && c.window.due() {
+		c.window.record(c.Stats())
+	}
+	return
+}
+
+// Lookup returns value for key like Get, and reports whether the key is a negative entry set by SetNegative.
+// Get treats negative entries as misses.
+func (c *TTLCache[K, V]) Lookup(key K) (value V, negative bool, ok bool) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.shards[hash&c.mask].Lookup(hash, key)
+}
+
+// GetMulti returns the values of keys in cache, it groups keys by shard and locks each shard once.
+func (c *TTLCache[K, V]) GetMulti(keys []K) map[K]V {
+	values := make(map[K]V, len(keys))
+	hashes := make([]uint32, len(keys))
+	for i := range keys {
+		hashes[i] = uint32(c.hasher(noescape(unsafe.Pointer(&keys[i])), c.seed))
+	}
+	order := shardOrder(hashes, c.mask)
+	for i, j := 0, 0; i < len(order); i = j {
+		shard := hashes[order[i]] & c.mask
+		for j = i + 1; j < len(order) && hashes[order[j]]&c.mask == shard; j++ {
+		}
+		c.shards[shard].GetMulti(keys, hashes, order[i:j], values)
+	}
+	return values
 }
 
 // GetOrLoad returns value for key, call loader function by singleflight if value was not in cache.
+// A key with a tombstone is a miss which calls no loader, and a load finishing after Tombstone does not set its value.
 func (c *TTLCache[K, V]) GetOrLoad(ctx context.Context, key K, loader func(context.Context, K) (V, time.Duration, error)) (value V, err error, ok bool) {
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
 	// value, ok = c.shards[hash&c.mask].Get(hash, key)
 	value, ok = (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Get(hash, key)
-	if !ok {
-		if loader == nil {
-			loader = c.loader
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
+	if !ok && !c.shards[hash&c.mask].Tombstoned(hash, key) {
+		if c.sampler != nil && c.sampler.sample() {
+			c.sampler.fn(key, c.Stats())
+		}
+		value, err, ok = c.load(ctx, hash, key, loader)
+	}
+	return
+}
+
+// GetOrLoadChan is the non-blocking GetOrLoad with the loader specified by WithLoader option. The returned channel
+// receives the result once, the value in cache is sent immediately, otherwise the loader is called by singleflight
+// in a new goroutine, so callers could select on the channel with their own timeout.
+func (c *TTLCache[K, V]) GetOrLoadChan(ctx context.Context, key K) <-chan Result[V] {
+	ch := make(chan Result[V], 1)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	value, ok := c.shards[hash&c.mask].Get(hash, key)
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
+	switch {
+	case ok:
+		ch <- Result[V]{Value: value, OK: true}
+	case c.shards[hash&c.mask].Tombstoned(hash, key):
+		ch <- Result[V]{}
+	case c.loader == nil:
+		ch <- Result[V]{Err: ErrLoaderIsNil}
+	default:
+		if c.sampler != nil && c.sampler.sample() {
+			c.sampler.fn(key, c.Stats())
 		}
-		if loader == nil {
-			err = ErrLoaderIsNil
+		c.group.DoChan(key, c.loadCall(ctx, hash, key, c.loader), ch)
+	}
+	return ch
+}
+
+// GetOrLoadBypass calls loader function by singleflight regardless of the cached value, and overwrites the cached value.
+// It is intended for force refreshes, the concurrent loads of key by GetOrLoad and GetOrLoadBypass are collapsed.
+func (c *TTLCache[K, V]) GetOrLoadBypass(ctx context.Context, key K, loader func(context.Context, K) (V, time.Duration, error)) (value V, err error, ok bool) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.load(ctx, hash, key, loader)
+}
+
+// load calls loader function by singleflight and sets the loaded value.
+func (c *TTLCache[K, V]) load(ctx context.Context, hash uint32, key K, loader func(context.Context, K) (V, time.Duration, error)) (value V, err error, ok bool) {
+	if loader == nil {
+		loader = c.loader
+	}
+	if loader == nil {
+		err = ErrLoaderIsNil
+		return
+	}
+	return c.group.Do(key, c.loadCall(ctx, hash, key, loader))
+}
+
+// loadCall returns the singleflight function which calls loader and sets the loaded value.
+func (c *TTLCache[K, V]) loadCall(ctx context.Context, hash uint32, key K, loader func(context.Context, K) (V, time.Duration, error)) func(call *singleflightCall[V]) (V, error) {
+	return func(call *singleflightCall[V]) (V, error) {
+		start := time.Now()
+		var v V
+		var ttl time.Duration
+		err := c.policy.do(ctx, func(ctx context.Context) (err error) {
+			v, ttl, err = loader(ctx, key)
 			return
+		})
+		if err != nil {
+			return v, err
 		}
-		value, err, ok = c.group.Do(key, func() (V, error) {
-			v, ttl, err := loader(ctx, key)
-			if err != nil {
-				return v, err
+		ttl = c.entryTTL(v, ttl)
+		if cost := time.Since(start); c.loadCostFactor > 0 && cost > c.loadCostThreshold {
+			if floor := time.Duration(float64(cost) * c.loadCostFactor); ttl > 0 && ttl < floor {
+				ttl = floor
 			}
-			c.shards[hash&c.mask].Set(hash, key, v, ttl)
-			return v, nil
+		}
+		ttl = c.clampTTL(ttl)
+		c.group.commit(call, func() {
+			c.setUnlessTombstoned(hash, key, v, ttl)
 		})
+		return v, nil
+	}
+}
+
+// setUnlessTombstoned sets the value of key loaded or revalidated in background, unless key has a tombstone,
+// so that a slow loader never resurrects the entry deleted by Tombstone meanwhile.
+func (c *TTLCache[K, V]) setUnlessTombstoned(hash uint32, key K, value V, ttl time.Duration) {
+	c.unlinkStale(key)
+	if !c.shards[hash&c.mask].SetUnlessTombstoned(hash, key, value, ttl) {
+		return
+	}
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	if c.shadow != nil {
+		c.shadow.set(key)
+	}
+}
+
+// ForgetLoad forgets the in-flight load of key, so the next GetOrLoad of key calls the loader again instead of
+// waiting for it. The forgotten load still returns to its waiters, but its value is not set to cache.
+// Call it before writing a new value of key, so the value is never overwritten by the stale load.
+func (c *TTLCache[K, V]) ForgetLoad(key K) {
+	c.group.Forget(key)
+}
+
+// InflightLoads returns the number of in-flight loads of GetOrLoad.
+func (c *TTLCache[K, V]) InflightLoads() int {
+	return c.group.Len()
+}
+
+// GetMultiOrLoad returns the values of keys in cache, and calls loader once with the missing keys.
+// The entries returned by loader are set to cache by SetMulti and merged into values.
+// If loader fails, the values in cache are returned with the error.
+func (c *TTLCache[K, V]) GetMultiOrLoad(ctx context.Context, keys []K, loader func(context.Context, []K) ([]Entry[K, V], error)) (values map[K]V, err error) {
+	values = c.GetMulti(keys)
+	if len(values) == len(keys) {
+		return
+	}
+
+	var missing []K
+	seen := make(map[K]struct{}, len(keys)-len(values))
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if loader == nil {
+		err = ErrLoaderIsNil
+		return
+	}
+
+	entries, err := loader(ctx, missing)
+	if err != nil {
+		return
+	}
+	c.SetMulti(entries)
+	for _, e := range entries {
+		values[e.Key] = e.Value
 	}
 	return
 }
 
+// GetOrLoadSimple is GetOrLoad with background context and the loader specified by WithLoader option.
+func (c *TTLCache[K, V]) GetOrLoadSimple(key K) (value V, err error, ok bool) {
+	return c.GetOrLoad(context.Background(), key, nil)
+}
+
 // Peek returns value and expires nanoseconds for key, but does not modify its recency.
 func (c *TTLCache[K, V]) Peek(key K) (value V, expires int64, ok bool) {
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
@@ -108,28 +374,388 @@ func (c *TTLCache[K, V]) Peek(key K) (value V, expires int64, ok bool) {
 	return (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Peek(hash, key)
 }
 
+// Contains reports whether key is in the cache and not expired, but does not modify its recency or copy its value.
+func (c *TTLCache[K, V]) Contains(key K) bool {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.shards[hash&c.mask].Contains(hash, key)
+}
+
+// GetStale returns value for key even if it has expired, and how long it has expired for, but does not modify its recency.
+// The expired entries are reclaimed when they are accessed by Get or evicted, so the stale value is not always available.
+func (c *TTLCache[K, V]) GetStale(key K) (value V, expiredFor time.Duration, ok bool) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	value, seconds, ok := c.shards[hash&c.mask].GetStale(hash, key)
+	expiredFor = time.Duration(seconds) * time.Second
+	return
+}
+
+// Age returns the duration since key was set, it is accurate to the nearest second and does not modify recency.
+func (c *TTLCache[K, V]) Age(key K) (age time.Duration, ok bool) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	seconds, ok := c.shards[hash&c.mask].Age(hash, key)
+	age = time.Duration(seconds) * time.Second
+	return
+}
+
 // Set inserts key value pair and returns previous value.
 func (c *TTLCache[K, V]) Set(key K, value V, ttl time.Duration) (prev V, replaced bool) {
+	c.unlinkStale(key)
+	ttl = c.entryTTL(value, ttl)
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	// return c.shards[hash&c.mask].Set(hash, key, value, ttl)
-	return (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Set(hash, key, value, ttl)
+	// prev, replaced = c.shards[hash&c.mask].Set(hash, key, value, ttl)
+	prev, replaced = (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Set(hash, key, value, ttl)
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	if c.shadow != nil {
+		c.shadow.set(key)
+	}
+	return
+}
+
+// SetExpires inserts key value pair which expires at the absolute time expiresAt and returns previous value.
+// It is accurate to the second and rounds expiresAt down, and deletes key if expiresAt is not after now.
+// The expiresAt later than now plus the max ttl of WithMaxTTL is clamped as well.
+func (c *TTLCache[K, V]) SetExpires(key K, value V, expiresAt time.Time) (prev V, replaced bool) {
+	c.unlinkStale(key)
+	expires := expiresAt.Unix() - clockBase
+	if expires <= int64(atomic.LoadUint32(&clock)) {
+		c.Delete(key)
+		return
+	}
+	if expires > math.MaxUint32 {
+		expires = math.MaxUint32
+	}
+	if c.maxTTL > 0 {
+		if limit := int64(atomic.LoadUint32(&clock)) + int64(c.maxTTL/time.Second); expires > limit {
+			expires = limit
+		}
+	}
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	prev, replaced = c.shards[hash&c.mask].SetExpires(hash, key, value, uint32(expires))
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	return
+}
+
+// Hash returns the hash of key computed by the cache hasher, it could be cached by callers and passed to the Hashed variants.
+func (c *TTLCache[K, V]) Hash(key K) uint32 {
+	return uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+}
+
+// ShardOf returns the index of the shard which key lands in, it matches the index of ShardStats.
+// The shard of a key is deterministic across caches with the same shards count, hasher and WithSeed.
+func (c *TTLCache[K, V]) ShardOf(key K) int {
+	return int(uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed)) & c.mask)
+}
+
+// GetHashed returns value for key with the hash returned by Hash.
+func (c *TTLCache[K, V]) GetHashed(hash uint32, key K) (value V, ok bool) {
+	// value, ok = c.shards[hash&c.mask].Get(hash, key)
+	value, ok = (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Get(hash, key)
+	if !ok && c.sampler != nil && c.sampler.sample() {
+		c.sampler.fn(key, c.Stats())
+	}
+	if c.shadow != nil {
+		c.shadow.get(key)
+	}
+	if c.window != nil && c.window.due() {
+		c.window.record(c.Stats())
+	}
+	return
+}
+
+// SetHashed inserts key value pair with the hash returned by Hash and returns previous value.
+func (c *TTLCache[K, V]) SetHashed(hash uint32, key K, value V, ttl time.Duration) (prev V, replaced bool) {
+	c.unlinkStale(key)
+	ttl = c.entryTTL(value, ttl)
+	// prev, replaced = c.shards[hash&c.mask].Set(hash, key, value, ttl)
+	prev, replaced = (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Set(hash, key, value, ttl)
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	if c.shadow != nil {
+		c.shadow.set(key)
+	}
+	return
+}
+
+// SetWithFlags inserts key value pair with user defined flags and returns previous value.
+// The flags are reset to zero by other Set methods.
+func (c *TTLCache[K, V]) SetWithFlags(key K, value V, ttl time.Duration, flags uint8) (prev V, replaced bool) {
+	c.unlinkStale(key)
+	ttl = c.entryTTL(value, ttl)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	prev, replaced = c.shards[hash&c.mask].SetWithFlags(hash, key, value, ttl, flags)
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	return
+}
+
+// SetSliding inserts key value pair whose expiration slides on hits as WithSliding, regardless of the cache option,
+// so a cache could hold both fixed and sliding entries. The entry is fixed again once it is set by other Set methods.
+func (c *TTLCache[K, V]) SetSliding(key K, value V, ttl time.Duration) (prev V, replaced bool) {
+	c.unlinkStale(key)
+	ttl = c.entryTTL(value, ttl)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	prev, replaced = c.shards[hash&c.mask].SetSliding(hash, key, value, ttl)
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	return
+}
+
+// GetFlags returns the user defined flags for key, but does not modify its recency.
+func (c *TTLCache[K, V]) GetFlags(key K) (flags uint8, ok bool) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return c.shards[hash&c.mask].GetFlags(hash, key)
+}
+
+// SetNegative inserts a negative entry for key, which caches the absence of key for ttl.
+func (c *TTLCache[K, V]) SetNegative(key K, ttl time.Duration) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	c.shards[hash&c.mask].SetNegative(hash, key, c.clampTTL(ttl))
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexDelete(key)
+	}
+}
+
+// Tombstone inserts a deletion marker for key, which makes Get return not found and blocks SetIfAbsent for ttl.
+// It prevents a slow writer from resurrecting the deleted data by SetIfAbsent, and Set overwrites it as usual.
+func (c *TTLCache[K, V]) Tombstone(key K, ttl time.Duration) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	c.shards[hash&c.mask].Tombstone(hash, key, c.clampTTL(ttl))
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexDelete(key)
+	}
+}
+
+// entryTTL returns the ttl of value to set, the zero ttl is derived by WithTTLFromValue, or replaced by WithDefaultTTL.
+func (c *TTLCache[K, V]) entryTTL(value V, ttl time.Duration) time.Duration {
+	if ttl == 0 && c.ttlFromValue != nil {
+		ttl = c.ttlFromValue(value)
+	}
+	if ttl == 0 {
+		ttl = c.defaultTTL
+	}
+	return c.clampTTL(ttl)
+}
+
+// clampTTL returns the ttl clamped by WithMaxTTL, the entries never expiring are clamped as well.
+func (c *TTLCache[K, V]) clampTTL(ttl time.Duration) time.Duration {
+	if c.maxTTL > 0 && (ttl <= 0 || ttl > c.maxTTL) {
+		ttl = c.maxTTL
+	}
+	return ttl
+}
+
+// SetMulti inserts entries, it groups entries by shard and locks each shard once.
+func (c *TTLCache[K, V]) SetMulti(entries []Entry[K, V]) {
+	for i := range entries {
+		c.unlinkStale(entries[i].Key)
+	}
+	hashes := make([]uint32, len(entries))
+	for i := range entries {
+		hashes[i] = uint32(c.hasher(noescape(unsafe.Pointer(&entries[i].Key)), c.seed))
+	}
+	order := shardOrder(hashes, c.mask)
+	for i, j := 0, 0; i < len(order); i = j {
+		shard := hashes[order[i]] & c.mask
+		for j = i + 1; j < len(order) && hashes[order[j]]&c.mask == shard; j++ {
+		}
+		c.shards[shard].SetMulti(entries, hashes, order[i:j], c.entryTTL)
+	}
+	for i := range entries {
+		c.tags.delete(entries[i].Key)
+	}
+	if c.indexes != nil {
+		for i := range entries {
+			c.indexSet(entries[i].Key, entries[i].Value)
+		}
+	}
 }
 
 // SetIfAbsent inserts key value pair and returns previous value, if key is absent in the cache.
+// The expired and negative entries are treated as absent, and a tombstone blocks the store.
 func (c *TTLCache[K, V]) SetIfAbsent(key K, value V, ttl time.Duration) (prev V, replaced bool) {
+	c.unlinkStale(key)
+	ttl = c.entryTTL(value, ttl)
+	if atomic.LoadInt32(&c.tags.tagged) != 0 && !c.Contains(key) {
+		// the value is going to be inserted, so it must not keep the tags of an evicted or expired key.
+		c.tags.delete(key)
+	}
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	// return c.shards[hash&c.mask].SetIfAbsent(hash, key, value, ttl)
-	return (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).SetIfAbsent(hash, key, value, ttl)
+	// prev, replaced = c.shards[hash&c.mask].SetIfAbsent(hash, key, value, ttl)
+	prev, replaced = (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).SetIfAbsent(hash, key, value, ttl)
+	if c.indexes != nil {
+		// the value may not be inserted, so index the value in cache.
+		if v, _, ok := c.Peek(key); ok {
+			c.indexSet(key, v)
+		}
+	}
+	return
+}
+
+// GetOrSet returns the existing value for key if present, otherwise it inserts the value and returns it, in the way of sync.Map LoadOrStore.
+// The loaded result is true if the value was loaded, false if stored. The expired and negative entries are treated as absent,
+// and a tombstone blocks the store, which returns the zero value and false.
+func (c *TTLCache[K, V]) GetOrSet(key K, value V, ttl time.Duration) (actual V, loaded bool) {
+	c.unlinkStale(key)
+	ttl = c.entryTTL(value, ttl)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	actual, loaded = c.shards[hash&c.mask].GetOrSet(hash, key, value, ttl)
+	if !loaded {
+		c.tags.delete(key)
+	}
+	if !loaded && c.indexes != nil {
+		// the value may be blocked by a tombstone, so index the value in cache.
+		if v, _, ok := c.Peek(key); ok {
+			c.indexSet(key, v)
+		}
+	}
+	return
+}
+
+// SetIf inserts key value pair if cond returns true and reports whether it was inserted.
+// The cond is called with the existing value under the shard lock, so it must not call methods of the cache.
+// The expired and negative entries are treated as absent.
+func (c *TTLCache[K, V]) SetIf(key K, value V, ttl time.Duration, cond func(old V, exists bool) bool) bool {
+	c.unlinkStale(key)
+	ttl = c.entryTTL(value, ttl)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	ok := c.shards[hash&c.mask].SetIf(hash, key, value, ttl, cond)
+	if ok {
+		c.tags.delete(key)
+	}
+	if ok && c.indexes != nil {
+		c.indexSet(key, value)
+	}
+	return ok
+}
+
+// Compute calls fn with the existing value for key under the shard lock, and updates or inserts the value returned by fn with ttl if keep is true,
+// otherwise deletes key. It returns the new value and keep. The fn must not call methods of the cache.
+// The expired and negative entries are treated as absent.
+func (c *TTLCache[K, V]) Compute(key K, ttl time.Duration, fn func(old V, exists bool) (new V, keep bool)) (value V, ok bool) {
+	c.unlinkStale(key)
+	if ttl == 0 {
+		ttl = c.defaultTTL
+	}
+	ttl = c.clampTTL(ttl)
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	value, ok = c.shards[hash&c.mask].Compute(hash, key, ttl, fn)
+	c.tags.delete(key)
+	if c.indexes != nil {
+		if ok {
+			c.indexSet(key, value)
+		} else {
+			c.indexDelete(key)
+		}
+	}
+	return
 }
 
 // Delete method deletes value associated with key and returns deleted value (or empty value if key was not in cache).
 func (c *TTLCache[K, V]) Delete(key K) (prev V) {
 	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
-	// return c.shards[hash&c.mask].Delete(hash, key)
-	return (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Delete(hash, key)
+	// prev = c.shards[hash&c.mask].Delete(hash, key)
+	prev = (*ttlshard[K, V])(unsafe.Add(unsafe.Pointer(&c.shards[0]), uintptr(hash&c.mask)*unsafe.Sizeof(c.shards[0]))).Delete(hash, key)
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexDelete(key)
+	}
+	for _, child := range c.links.take(key) {
+		c.Delete(child)
+	}
+	return
+}
+
+// deleteIfValue deletes key if fn reports true for its value under the shard lock, e.g. a value reclaimed by GC of WeakCache,
+// so the key set again concurrently is not deleted.
+func (c *TTLCache[K, V]) deleteIfValue(key K, fn func(value V) bool) {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	if !c.shards[hash&c.mask].DeleteIfValue(hash, key, fn) {
+		return
+	}
+	c.tags.delete(key)
+	if c.indexes != nil {
+		c.indexDelete(key)
+	}
+	for _, child := range c.links.take(key) {
+		c.Delete(child)
+	}
+}
+
+// DeleteIf deletes all entries matching fn and returns the number of deleted entries, the expired entries are skipped.
+// The fn is called under the shard lock, so it must not call methods of the cache.
+func (c *TTLCache[K, V]) DeleteIf(fn func(key K, value V) bool) (deleted int) {
+	now := atomic.LoadUint32(&clock)
+	for i := uint32(0); i <= c.mask; i++ {
+		deleted += int(c.shards[i].DeleteIf(fn, now))
+	}
+	return
+}
+
+// DeleteExpired deletes all expired entries and returns the number of deleted entries, for the applications
+// which purge the cache on their own schedule instead of WithActiveExpiry. Each shard is locked once.
+func (c *TTLCache[K, V]) DeleteExpired() (deleted int) {
+	now := atomic.LoadUint32(&clock)
+	for i := uint32(0); i <= c.mask; i++ {
+		_, n := c.shards[i].DeleteExpired(0, 0, now)
+		deleted += int(n)
+	}
+	return
+}
+
+// KeyLock returns a striped locker for key, which is picked by the same hash as the cache sharding.
+// Keys in the same shard share a locker, so do not hold it while locking another key.
+func (c *TTLCache[K, V]) KeyLock(key K) sync.Locker {
+	hash := uint32(c.hasher(noescape(unsafe.Pointer(&key)), c.seed))
+	return &c.locks[hash&c.mask]
 }
 
-// Len returns number of cached nodes.
+// InvalidateBefore deletes all entries set before t and returns the number of deleted entries.
+// It is accurate to the second, so the entries set within the same second of t are deleted as well.
+func (c *TTLCache[K, V]) InvalidateBefore(t time.Time) (deleted int) {
+	before := t.Unix() - clockBase
+	if before < 0 {
+		return
+	}
+	for i := uint32(0); i <= c.mask; i++ {
+		deleted += int(c.shards[i].InvalidateBefore(uint32(before)))
+	}
+	return
+}
+
+// BumpEpoch invalidates all existing entries logically, without touching the entries.
+// The entries set before are treated as expired, and they are reclaimed lazily when accessed or evicted.
+func (c *TTLCache[K, V]) BumpEpoch() {
+	for i := uint32(0); i <= c.mask; i++ {
+		c.shards[i].BumpEpoch()
+	}
+}
+
+// Clear deletes all entries in place, the pre-allocated lists and tables are reused.
+func (c *TTLCache[K, V]) Clear() {
+	for i := uint32(0); i <= c.mask; i++ {
+		c.shards[i].Clear()
+	}
+	c.tags.clear()
+	for _, x := range c.indexes {
+		x.clear()
+	}
+}
+
+// Len returns number of cached nodes, including the expired nodes not removed yet, which is the length of AllKeys.
 func (c *TTLCache[K, V]) Len() int {
 	var n uint32
 	for i := uint32(0); i <= c.mask; i++ {
@@ -138,25 +764,280 @@ func (c *TTLCache[K, V]) Len() int {
 	return int(n)
 }
 
-// AppendKeys appends all keys to keys and return the keys.
+// AppendKeys appends all unexpired keys to keys and return the keys, excluding negative entries and tombstones.
+// The keys are kept until the second of their expiration passes, unless WithStrictExpiry is specified.
 func (c *TTLCache[K, V]) AppendKeys(keys []K) []K {
-	now := atomic.LoadUint32(&clock)
+	now := c.keysNow()
 	for i := uint32(0); i <= c.mask; i++ {
 		keys = c.shards[i].AppendKeys(keys, now)
 	}
 	return keys
 }
 
+// keysNow returns the clock compared by the key iteration, which keeps the keys expiring at the current second,
+// unless WithStrictExpiry is specified.
+func (c *TTLCache[K, V]) keysNow() uint32 {
+	if c.strictExpiry {
+		return atomic.LoadUint32(&clock) + 1
+	}
+	return atomic.LoadUint32(&clock)
+}
+
+// LiveKeys appends the keys which Get returns a value for to keys and return the keys,
+// excluding expired keys, negative entries and tombstones.
+func (c *TTLCache[K, V]) LiveKeys(keys []K) []K {
+	now := atomic.LoadUint32(&clock)
+	for i := uint32(0); i <= c.mask; i++ {
+		keys = c.shards[i].AppendLiveKeys(keys, now)
+	}
+	return keys
+}
+
+// AllKeys appends the keys of all cached nodes counted by Len to keys and return the keys,
+// including expired keys not removed yet, negative entries and tombstones.
+func (c *TTLCache[K, V]) AllKeys(keys []K) []K {
+	for i := uint32(0); i <= c.mask; i++ {
+		keys = c.shards[i].AppendAllKeys(keys)
+	}
+	return keys
+}
+
+// EvictToWatermark evicts the least recent used entries of shards proportionally,
+// until the number of cached nodes is not greater than n, and returns the number of evicted nodes.
+// It is intended to be called by memory pressure handlers, e.g. polling runtime/metrics against debug.SetMemoryLimit.
+func (c *TTLCache[K, V]) EvictToWatermark(n int) (evicted int) {
+	if n < 0 {
+		n = 0
+	}
+	total := c.Len()
+	if total <= n {
+		return
+	}
+	for i := uint32(0); i <= c.mask; i++ {
+		s := &c.shards[i]
+		target := uint64(s.Len()) * uint64(n) / uint64(total)
+		evicted += int(s.EvictTo(uint32(target)))
+	}
+	return
+}
+
+// ScanKeys iterates keys incrementally in the way of redis SCAN, it returns at most count keys
+// starting from cursor and the next cursor, iteration is complete when the next cursor is zero.
+// Keys added or deleted during the iteration may be missed or returned twice.
+func (c *TTLCache[K, V]) ScanKeys(cursor uint64, count int) (keys []K, next uint64) {
+	if count < 1 {
+		count = 1
+	}
+	now := c.keysNow()
+	pos := uint32(cursor)
+	for i := uint32(cursor >> 32); i <= c.mask && len(keys) < count; i++ {
+		keys, pos = c.shards[i].ScanKeys(keys, pos, count-len(keys), now)
+		if pos != 0 {
+			return keys, uint64(i)<<32 | uint64(pos)
+		}
+		if i < c.mask {
+			next = uint64(i+1) << 32
+		} else {
+			next = 0
+		}
+	}
+	return
+}
+
+// Range calls fn for each entry until fn returns false, fn is called out of the shard lock.
+// It copies the entries of at most batch buckets under the shard lock at a time, so the iteration of large shards
+// does not block writers for long, and batch less than 1 means the whole shard. Since the lock is released between batches,
+// entries added or deleted during the iteration may be missed or visited twice, the same as ScanKeys.
+func (c *TTLCache[K, V]) Range(batch int, fn func(key K, value V) bool) {
+	var keys []K
+	var values []V
+	for i := uint32(0); i <= c.mask; i++ {
+		for pos := uint32(0); ; {
+			keys, values, pos = c.shards[i].ScanEntries(keys[:0], values[:0], pos, batch, atomic.LoadUint32(&clock))
+			for j := range keys {
+				if !fn(keys[j], values[j]) {
+					return
+				}
+			}
+			if pos == 0 {
+				break
+			}
+		}
+	}
+}
+
+// ForEachParallel calls fn for each entry by bounded workers, which process shards concurrently.
+// fn is called out of the shard lock with a snapshot of the shard entries.
+// It stops on the first error returned by fn or the cancellation of ctx, and returns the error.
+func (c *TTLCache[K, V]) ForEachParallel(ctx context.Context, workers int, fn func(key K, value V) error) (err error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := c.keysNow()
+	var next uint32
+	var once sync.Once
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			var keys []K
+			var values []V
+			for {
+				i := atomic.AddUint32(&next, 1) - 1
+				if i > c.mask {
+					return
+				}
+				keys, values = c.shards[i].AppendEntries(keys[:0], values[:0], now)
+				for j := range keys {
+					select {
+					case <-ctx.Done():
+						return
+					default:
+					}
+					if e := fn(keys[j], values[j]); e != nil {
+						once.Do(func() { err = e })
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err == nil {
+		// the context is only canceled by parent here
+		err = ctx.Err()
+	}
+	return
+}
+
+// StartRevalidator starts a goroutine which walks one shard of the cache each tick of interval, and calls fn for each entry out of the shard lock.
+// The entry is set to the value and ttl returned by fn if keep is true, otherwise it is deleted, so a concurrent Set may be overwritten,
+// but a tombstone set meanwhile is neither overwritten nor deleted. The goroutine exits when ctx is done.
+func (c *TTLCache[K, V]) StartRevalidator(ctx context.Context, interval time.Duration, fn func(key K, value V) (newValue V, ttl time.Duration, keep bool)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var keys []K
+		var values []V
+		for i := uint32(0); ; i = (i + 1) & c.mask {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			keys, values = c.shards[i].AppendEntries(keys[:0], values[:0], c.keysNow())
+			for j := range keys {
+				if v, ttl, keep := fn(keys[j], values[j]); keep {
+					c.setUnlessTombstoned(uint32(c.hasher(noescape(unsafe.Pointer(&keys[j])), c.seed)), keys[j], v, c.entryTTL(v, ttl))
+				} else {
+					c.deleteIfValue(keys[j], func(V) bool { return true })
+				}
+			}
+		}
+	}()
+}
+
+// EvictionOrder returns at most max keys in the order they would be evicted.
+// Eviction strictly follows the shard list order, a Get or Set moves the key to the front and a Delete frees its node,
+// so the keys are collected from the back of each shard list, shard by shard. The order is global only for a single shard cache.
+func (c *TTLCache[K, V]) EvictionOrder(max int) (keys []K) {
+	for i := uint32(0); i <= c.mask && len(keys) < max; i++ {
+		keys = c.shards[i].AppendEvictionOrder(keys, max-len(keys))
+	}
+	return
+}
+
+// ShardOldest returns the least recently used live entry of the shard, which is the next to be evicted from it.
+// The shard is the index of ShardOf and ShardStats, and ok is false if the shard is empty or out of range.
+// The recency is ordered within a shard only, so the entries of different shards are not comparable.
+func (c *TTLCache[K, V]) ShardOldest(shard int) (key K, value V, ok bool) {
+	if shard < 0 || shard > int(c.mask) {
+		return
+	}
+	return c.shards[shard].Edge(true)
+}
+
+// ShardNewest returns the most recently used live entry of the shard, in the same way as ShardOldest.
+func (c *TTLCache[K, V]) ShardNewest(shard int) (key K, value V, ok bool) {
+	if shard < 0 || shard > int(c.mask) {
+		return
+	}
+	return c.shards[shard].Edge(false)
+}
+
 // Stats returns cache stats.
 func (c *TTLCache[K, V]) Stats() (stats Stats) {
+	c.CollectStats(&stats)
+	return
+}
+
+// CollectStats overwrites stats with cache stats, it reads the shard counters atomically without locking shards,
+// so it is cheap for frequent scraping, and the counters of different shards may be observed at slightly different times.
+func (c *TTLCache[K, V]) CollectStats(stats *Stats) {
+	*stats = Stats{}
 	for i := uint32(0); i <= c.mask; i++ {
 		s := &c.shards[i]
-		s.mu.Lock()
-		stats.EntriesCount += uint64(s.tableLength)
-		stats.GetCalls += s.statsGetCalls
-		stats.SetCalls += s.statsSetCalls
-		stats.Misses += s.statsMisses
-		s.mu.Unlock()
+		stats.EntriesCount += uint64(atomic.LoadUint32(&s.tableLength))
+		stats.GetCalls += atomic.LoadUint64(&s.statsGetCalls)
+		stats.SetCalls += atomic.LoadUint64(&s.statsSetCalls)
+		stats.Misses += atomic.LoadUint64(&s.statsMisses)
+		stats.Evictions += atomic.LoadUint64(&s.removal.evictions)
+		stats.Expired += atomic.LoadUint64(&s.removal.expired)
+	}
+	if c.shadow != nil {
+		stats.ShadowMisses = atomic.LoadUint64(&c.shadow.misses)
+	}
+}
+
+// ShardStats returns the stats of each shard, for finding the imbalance of shards, ShadowMisses is not per shard.
+func (c *TTLCache[K, V]) ShardStats() []Stats {
+	stats := make([]Stats, c.mask+1)
+	for i := range stats {
+		s := &c.shards[i]
+		stats[i] = Stats{
+			GetCalls:     atomic.LoadUint64(&s.statsGetCalls),
+			SetCalls:     atomic.LoadUint64(&s.statsSetCalls),
+			Misses:       atomic.LoadUint64(&s.statsMisses),
+			EntriesCount: uint64(atomic.LoadUint32(&s.tableLength)),
+			Evictions:    atomic.LoadUint64(&s.removal.evictions),
+			Expired:      atomic.LoadUint64(&s.removal.expired),
+		}
+	}
+	return stats
+}
+
+// HitRatio returns the hit ratio of Get calls in the last window, which is tracked by WithHitRatioWindow.
+// The window is rounded to the snapshots of the tracker, and ok is false if it is not tracked or there is no Get call.
+func (c *TTLCache[K, V]) HitRatio(window time.Duration) (ratio float64, ok bool) {
+	if c.window == nil {
+		return 0, false
+	}
+	return c.window.ratio(window, c.Stats())
+}
+
+// ResetStats swaps the stats counters to zero and returns their values before reset, EntriesCount is kept as current value.
+// Each counter is swapped atomically, so the counts between two calls are never lost or counted twice, and the returned
+// stats are the counts of the interval since the last reset.
+func (c *TTLCache[K, V]) ResetStats() (stats Stats) {
+	for i := uint32(0); i <= c.mask; i++ {
+		s := &c.shards[i]
+		stats.EntriesCount += uint64(atomic.LoadUint32(&s.tableLength))
+		stats.GetCalls += atomic.SwapUint64(&s.statsGetCalls, 0)
+		stats.SetCalls += atomic.SwapUint64(&s.statsSetCalls, 0)
+		stats.Misses += atomic.SwapUint64(&s.statsMisses, 0)
+		stats.Evictions += atomic.SwapUint64(&s.removal.evictions, 0)
+		stats.Expired += atomic.SwapUint64(&s.removal.expired, 0)
+	}
+	if c.shadow != nil {
+		stats.ShadowMisses = atomic.SwapUint64(&c.shadow.misses, 0)
 	}
 	return
 }
@@ -1,10 +1,13 @@
 package lru
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -269,6 +272,29 @@ func TestTTLCacheHasher(t *testing.T) {
 	}
 }
 
+func TestTTLCacheKeyEqual(t *testing.T) {
+	cache := NewTTLCache[string, int](1024,
+		WithHasher[string, int](func(key unsafe.Pointer, seed uintptr) (x uintptr) {
+			x = 5381
+			for _, c := range []byte(strings.ToLower(*(*string)(key))) {
+				x = x*33 + uintptr(c)
+			}
+			return
+		}),
+		WithKeyEqual[string, int](strings.EqualFold),
+	)
+
+	cache.Set("Foo", 1, time.Hour)
+
+	if _, replaced := cache.Set("foo", 2, time.Hour); !replaced {
+		t.Fatal("should have replaced")
+	}
+
+	if v, ok := cache.Get("FOO"); !ok || v != 2 {
+		t.Fatalf("bad returned value: %v != %v", v, 2)
+	}
+}
+
 func TestTTLCacheLoader(t *testing.T) {
 	cache := NewTTLCache[string, int](1024)
 	if v, err, ok := cache.GetOrLoad(context.Background(), "a", nil); ok || err == nil || v != 0 {
@@ -434,6 +460,1737 @@ func TestTTLCacheStats(t *testing.T) {
 	}
 }
 
+func TestTTLCacheMissSampler(t *testing.T) {
+	var sampled []string
+	cache := NewTTLCache[string, int](256, WithShards[string, int](1), WithMissSampler[string, int](2, func(key string, stats Stats) {
+		sampled = append(sampled, fmt.Sprintf("%s:%d", key, stats.Misses))
+	}))
+
+	cache.Set("a", 1, time.Hour)
+	cache.Get("a")
+	cache.Get("x")
+	cache.Get("y")
+	cache.Get("z")
+	cache.Get("w")
+
+	if got, want := strings.Join(sampled, ","), "y:2,w:4"; got != want {
+		t.Fatalf("sampled misses should be %v: %v", want, got)
+	}
+}
+
+func TestTTLCacheKeyLock(t *testing.T) {
+	cache := NewTTLCache[string, int](1024)
+
+	if cache.KeyLock("a") != cache.KeyLock("a") {
+		t.Fatalf("key lock of a should be same")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			mu := cache.KeyLock("a")
+			mu.Lock()
+			v, _ := cache.Get("a")
+			cache.Set("a", v+1, time.Hour)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if v, ok := cache.Get("a"); !ok || v != 10 {
+		t.Fatalf("a should be set to 10: %v", v)
+	}
+}
+
+func TestTTLCacheLoaderBusyTimeout(t *testing.T) {
+	cache := NewTTLCache[string, int](1024,
+		WithLoader[string, int](func(ctx context.Context, key string) (int, time.Duration, error) {
+			time.Sleep(300 * time.Millisecond)
+			return 1, time.Hour, nil
+		}),
+		WithLoaderBusyTimeout[string, int](100*time.Millisecond),
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if v, err, _ := cache.GetOrLoad(context.Background(), "a", nil); err != nil || v != 1 {
+			t.Errorf("a should be loaded to 1: %v, %v", v, err)
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if v, err, _ := cache.GetOrLoad(context.Background(), "a", nil); err != nil || v != 1 {
+			t.Errorf("a should be waited to 1: %v, %v", v, err)
+		}
+	}()
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err, ok := cache.GetOrLoad(context.Background(), "a", nil); ok || err != ErrLoaderBusy {
+		t.Errorf("a should be failed with busy loader: %v, %v", err, ok)
+	}
+	wg.Wait()
+}
+
+func TestTTLCacheEvictToWatermark(t *testing.T) {
+	cache := NewTTLCache[int, int](256, WithShards[int, int](1))
+
+	for i := 0; i < 256; i++ {
+		cache.Set(i, i, time.Hour)
+	}
+	for i := 0; i < 10; i++ {
+		cache.Get(i)
+	}
+
+	if got, want := cache.EvictToWatermark(100), 156; got != want {
+		t.Fatalf("evicted count %v should be %v", got, want)
+	}
+
+	if got, want := cache.Len(), 100; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+
+	for i := 0; i < 10; i++ {
+		if v, ok := cache.Get(i); !ok || v != i {
+			t.Fatalf("key %v should not be evicted", i)
+		}
+	}
+
+	if got, want := cache.EvictToWatermark(200), 0; got != want {
+		t.Fatalf("evicted count %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheScanKeys(t *testing.T) {
+	cache := NewTTLCache[int, int](1024, WithShards[int, int](4))
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i, time.Hour)
+	}
+
+	seen := make(map[int]bool)
+	var keys []int
+	var cursor uint64
+	for {
+		keys, cursor = cache.ScanKeys(cursor, 7)
+		if len(keys) > 7 {
+			t.Fatalf("scan keys length %v should not be greater than 7", len(keys))
+		}
+		for _, k := range keys {
+			seen[k] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if got, want := len(seen), cache.Len(); got != want {
+		t.Fatalf("scanned keys length %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheAge(t *testing.T) {
+	cache := NewTTLCache[string, int](256)
+
+	if age, ok := cache.Age("a"); ok || age != 0 {
+		t.Fatalf("a age should be absent: %v", age)
+	}
+
+	cache.Set("a", 1, time.Hour)
+	time.Sleep(2 * time.Second)
+	cache.Set("b", 2, time.Hour)
+
+	if age, ok := cache.Age("a"); !ok || age < time.Second || age > 3*time.Second {
+		t.Fatalf("a age should be about 2s: %v", age)
+	}
+	if age, ok := cache.Age("b"); !ok || age > time.Second {
+		t.Fatalf("b age should be about 0s: %v", age)
+	}
+
+	cache.Set("a", 1, time.Hour)
+	if age, ok := cache.Age("a"); !ok || age > time.Second {
+		t.Fatalf("a age should be reset by set: %v", age)
+	}
+}
+
+func TestTTLCacheGetOrLoadSimple(t *testing.T) {
+	cache := NewTTLCache[string, int](1024)
+	if v, err, ok := cache.GetOrLoadSimple("a"); ok || err != ErrLoaderIsNil || v != 0 {
+		t.Errorf("cache.GetOrLoadSimple(\"a\") should be return error: %v, %v, %v", v, err, ok)
+	}
+
+	cache = NewTTLCache[string, int](1024, WithLoader[string, int](func(ctx context.Context, key string) (int, time.Duration, error) {
+		return int(key[0] - 'a' + 1), time.Hour, nil
+	}))
+
+	if v, err, ok := cache.GetOrLoadSimple("b"); ok || err != nil || v != 2 {
+		t.Errorf("cache.GetOrLoadSimple(\"b\") should be return 2: %v, %v, %v", v, err, ok)
+	}
+
+	if v, err, ok := cache.GetOrLoadSimple("b"); !ok || err != nil || v != 2 {
+		t.Errorf("cache.GetOrLoadSimple(\"b\") again should be return 2: %v, %v, %v", v, err, ok)
+	}
+}
+
+func TestTTLCacheHashed(t *testing.T) {
+	cache := NewTTLCache[string, int](1024)
+
+	hash := cache.Hash("a")
+	if hash != cache.Hash("a") {
+		t.Fatalf("hash of a should be stable")
+	}
+
+	if v, ok := cache.GetHashed(hash, "a"); ok {
+		t.Fatalf("a should not be set: %v", v)
+	}
+
+	cache.SetHashed(hash, "a", 1, time.Hour)
+
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("a should be set to 1: %v", v)
+	}
+
+	cache.Set("a", 2, time.Hour)
+
+	if v, ok := cache.GetHashed(hash, "a"); !ok || v != 2 {
+		t.Fatalf("a should be set to 2: %v", v)
+	}
+}
+
+func TestTTLCacheForEachParallel(t *testing.T) {
+	cache := NewTTLCache[int, int](1024, WithShards[int, int](16))
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i, time.Hour)
+	}
+
+	var count int64
+	err := cache.ForEachParallel(context.Background(), 4, func(key int, value int) error {
+		if key != value {
+			return fmt.Errorf("bad value of key %v: %v", key, value)
+		}
+		atomic.AddInt64(&count, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("for each parallel error: %v", err)
+	}
+	if got, want := int(count), cache.Len(); got != want {
+		t.Fatalf("for each count %v should be %v", got, want)
+	}
+
+	errStop := fmt.Errorf("stop")
+	err = cache.ForEachParallel(context.Background(), 4, func(key int, value int) error {
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("for each parallel error should be %v: %v", errStop, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = cache.ForEachParallel(ctx, 4, func(key int, value int) error {
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("for each parallel error should be %v: %v", context.Canceled, err)
+	}
+}
+
+func TestTTLCacheEvictionOrder(t *testing.T) {
+	cache := NewTTLCache[int, int](8, WithShards[int, int](1))
+
+	for i := 0; i < 8; i++ {
+		cache.Set(i, i, time.Hour)
+	}
+	cache.Get(0)
+	cache.Delete(1)
+
+	if got, want := fmt.Sprint(cache.EvictionOrder(3)), "[2 3 4]"; got != want {
+		t.Fatalf("eviction order should be %v: %v", want, got)
+	}
+
+	cache.Set(8, 8, time.Hour)
+	cache.Set(9, 9, time.Hour)
+
+	if got, want := fmt.Sprint(cache.EvictionOrder(3)), "[3 4 5]"; got != want {
+		t.Fatalf("eviction order should be %v: %v", want, got)
+	}
+}
+
+func TestTTLCacheShardOldestNewest(t *testing.T) {
+	cache := NewTTLCache[int, int](32, WithShards[int, int](4))
+
+	for shard := -1; shard <= 4; shard++ {
+		if _, _, ok := cache.ShardOldest(shard); ok {
+			t.Fatalf("empty shard %v should have no oldest entry", shard)
+		}
+	}
+
+	// the keys of one shard, in the order they are set.
+	var keys []int
+	for i := 0; len(keys) < 4; i++ {
+		if cache.ShardOf(i) == 2 {
+			keys = append(keys, i)
+		}
+	}
+	for _, i := range keys {
+		cache.Set(i, i*10, time.Hour)
+	}
+	cache.Get(keys[0])
+	cache.Delete(keys[1])
+
+	if k, v, ok := cache.ShardOldest(2); !ok || k != keys[2] || v != keys[2]*10 {
+		t.Fatalf("oldest entry of shard 2 should be %v: %v %v %v", keys[2], k, v, ok)
+	}
+	if k, v, ok := cache.ShardNewest(2); !ok || k != keys[0] || v != keys[0]*10 {
+		t.Fatalf("newest entry of shard 2 should be %v: %v %v %v", keys[0], k, v, ok)
+	}
+	if _, _, ok := cache.ShardNewest(1); ok {
+		t.Fatalf("shard 1 should have no newest entry")
+	}
+}
+
+func TestTTLCacheFlags(t *testing.T) {
+	const compressed, negative = 1 << 0, 1 << 1
+
+	cache := NewTTLCache[string, int](256)
+
+	if flags, ok := cache.GetFlags("a"); ok || flags != 0 {
+		t.Fatalf("a flags should be absent: %v", flags)
+	}
+
+	cache.SetWithFlags("a", 1, time.Hour, compressed|negative)
+
+	if flags, ok := cache.GetFlags("a"); !ok || flags != compressed|negative {
+		t.Fatalf("a flags should be %v: %v", compressed|negative, flags)
+	}
+
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("a should be set to 1: %v", v)
+	}
+
+	cache.Set("a", 2, time.Hour)
+
+	if flags, ok := cache.GetFlags("a"); !ok || flags != 0 {
+		t.Fatalf("a flags should be reset by set: %v", flags)
+	}
+}
+
+func TestTTLCacheNegative(t *testing.T) {
+	cache := NewTTLCache[string, int](256)
+
+	if v, negative, ok := cache.Lookup("a"); ok || negative || v != 0 {
+		t.Fatalf("a should be absent: %v, %v", v, negative)
+	}
+
+	cache.SetNegative("a", 2*time.Second)
+
+	if v, negative, ok := cache.Lookup("a"); !ok || !negative || v != 0 {
+		t.Fatalf("a should be negative: %v, %v", v, negative)
+	}
+	if v, ok := cache.Get("a"); ok || v != 0 {
+		t.Fatalf("a should be missing for Get: %v", v)
+	}
+
+	cache.Set("b", 0, time.Hour)
+
+	if v, negative, ok := cache.Lookup("b"); !ok || negative || v != 0 {
+		t.Fatalf("b should be set to 0: %v, %v", v, negative)
+	}
+
+	cache.Set("a", 1, time.Hour)
+
+	if v, negative, ok := cache.Lookup("a"); !ok || negative || v != 1 {
+		t.Fatalf("a should be set to 1: %v, %v", v, negative)
+	}
+
+	cache.SetNegative("b", time.Second)
+	time.Sleep(2 * time.Second)
+
+	if _, negative, ok := cache.Lookup("b"); ok || negative {
+		t.Fatalf("b negative entry should be expired: %v", negative)
+	}
+}
+
+func TestTTLCacheNegativeAbsent(t *testing.T) {
+	cache := NewTTLCache[string, int](256)
+
+	cache.SetNegative("a", time.Hour)
+	if _, replaced := cache.SetIfAbsent("a", 1, time.Hour); !replaced {
+		t.Fatalf("negative a should be replaced by SetIfAbsent")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("a should be set to 1 by SetIfAbsent: %v", v)
+	}
+
+	cache.SetNegative("b", time.Hour)
+	if v, loaded := cache.GetOrSet("b", 2, time.Hour); loaded || v != 2 {
+		t.Fatalf("negative b should be stored by GetOrSet: %v %v", v, loaded)
+	}
+	if v, ok := cache.Get("b"); !ok || v != 2 {
+		t.Fatalf("b should be set to 2 by GetOrSet: %v", v)
+	}
+
+	cache.SetNegative("c", time.Hour)
+	if ok := cache.SetIf("c", 3, time.Hour, func(old int, exists bool) bool { return !exists }); !ok {
+		t.Fatalf("negative c should be absent for SetIf")
+	}
+	if v, ok := cache.Get("c"); !ok || v != 3 {
+		t.Fatalf("c should be set to 3 by SetIf: %v", v)
+	}
+
+	cache.SetNegative("d", time.Hour)
+	if v, ok := cache.Compute("d", time.Hour, func(old int, exists bool) (int, bool) { return 4, !exists }); !ok || v != 4 {
+		t.Fatalf("negative d should be absent for Compute: %v %v", v, ok)
+	}
+	if v, ok := cache.Get("d"); !ok || v != 4 {
+		t.Fatalf("d should be set to 4 by Compute: %v", v)
+	}
+
+	cache.Tombstone("e", time.Hour)
+	if _, replaced := cache.SetIfAbsent("e", 5, time.Hour); replaced {
+		t.Fatalf("tombstone e should block SetIfAbsent")
+	}
+}
+
+func TestTTLCacheInvalidateBefore(t *testing.T) {
+	cache := NewTTLCache[int, int](1024, WithShards[int, int](4))
+
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i, time.Hour)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	now := time.Now()
+	time.Sleep(1100 * time.Millisecond)
+
+	for i := 100; i < 200; i++ {
+		cache.Set(i, i, time.Hour)
+	}
+	cache.Set(0, 0, time.Hour)
+
+	if got, want := cache.InvalidateBefore(now), 99; got != want {
+		t.Fatalf("invalidated entries %v should be %v", got, want)
+	}
+
+	if got, want := cache.Len(), 101; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+
+	if v, ok := cache.Get(0); !ok || v != 0 {
+		t.Fatalf("0 should be set after invalidation: %v", v)
+	}
+	if v, ok := cache.Get(1); ok {
+		t.Fatalf("1 should be invalidated: %v", v)
+	}
+}
+
+func TestTTLCacheBumpEpoch(t *testing.T) {
+	cache := NewTTLCache[int, int](1024, WithShards[int, int](4))
+
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i, time.Hour)
+	}
+
+	cache.BumpEpoch()
+
+	if got, want := len(cache.AppendKeys(nil)), 0; got != want {
+		t.Fatalf("curent cache keys length %v should be %v", got, want)
+	}
+
+	if v, ok := cache.Get(1); ok {
+		t.Fatalf("1 should be invalidated: %v", v)
+	}
+
+	if _, replaced := cache.SetIfAbsent(2, 20, time.Hour); !replaced {
+		t.Fatalf("2 should be replaced")
+	}
+
+	if v, ok := cache.Get(2); !ok || v != 20 {
+		t.Fatalf("2 should be set to 20: %v", v)
+	}
+
+	cache.Set(3, 30, time.Hour)
+	if v, ok := cache.Get(3); !ok || v != 30 {
+		t.Fatalf("3 should be set to 30: %v", v)
+	}
+}
+
+func TestTTLCacheBumpEpochWraparound(t *testing.T) {
+	cache := NewTTLCache[int, int](1024, WithShards[int, int](1))
+
+	cache.Set(1, 1, time.Hour)
+	for i := 0; i < 1<<16; i++ {
+		cache.BumpEpoch()
+	}
+
+	if v, ok := cache.Get(1); ok {
+		t.Fatalf("1 should be invalidated: %v", v)
+	}
+
+	if got, want := cache.Len(), 0; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+
+	cache.Set(1, 1, time.Hour)
+	if v, ok := cache.Get(1); !ok || v != 1 {
+		t.Fatalf("1 should be set to 1: %v", v)
+	}
+}
+
+func TestTTLCacheTTLFromValue(t *testing.T) {
+	type token struct {
+		name   string
+		expiry time.Time
+	}
+
+	cache := NewTTLCache[string, token](256, WithTTLFromValue[string, token](func(v token) time.Duration {
+		return time.Until(v.expiry)
+	}))
+
+	cache.Set("a", token{"a", time.Now().Add(time.Second)}, 0)
+	cache.Set("b", token{"b", time.Now().Add(time.Second)}, time.Hour)
+	cache.SetIfAbsent("c", token{"c", time.Now().Add(time.Hour)}, 0)
+
+	if _, expires, ok := cache.Peek("c"); !ok || time.Until(time.Unix(0, expires)) < 50*time.Minute {
+		t.Fatalf("c should be expired in an hour: %v", expires)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if v, ok := cache.Get("a"); ok {
+		t.Fatalf("a should be expired: %v", v)
+	}
+	if v, ok := cache.Get("b"); !ok || v.name != "b" {
+		t.Fatalf("b should not be expired: %v", v)
+	}
+}
+
+func TestTTLCacheGetStale(t *testing.T) {
+	cache := NewTTLCache[string, int](256)
+
+	cache.Set("a", 1, time.Second)
+	cache.Set("b", 2, 0)
+
+	if v, expiredFor, ok := cache.GetStale("a"); !ok || v != 1 || expiredFor != 0 {
+		t.Fatalf("a should be fresh: %v %v %v", v, expiredFor, ok)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if v, expiredFor, ok := cache.GetStale("a"); !ok || v != 1 || expiredFor == 0 {
+		t.Fatalf("a should be stale: %v %v %v", v, expiredFor, ok)
+	}
+	if v, expiredFor, ok := cache.GetStale("b"); !ok || v != 2 || expiredFor != 0 {
+		t.Fatalf("b should never expire: %v %v %v", v, expiredFor, ok)
+	}
+
+	if v, ok := cache.Get("a"); ok {
+		t.Fatalf("a should be expired: %v", v)
+	}
+	if v, _, ok := cache.GetStale("a"); ok {
+		t.Fatalf("a should be reclaimed by Get: %v", v)
+	}
+}
+
+func TestTTLCacheSetIf(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	newer := func(version int) func(int, bool) bool {
+		return func(old int, exists bool) bool { return !exists || version > old }
+	}
+
+	if !cache.SetIf("a", 5, time.Second, newer(5)) {
+		t.Fatalf("a should be set as absent")
+	}
+	if cache.SetIf("a", 1, time.Second, newer(1)) {
+		t.Fatalf("a should not be overwritten by older version")
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if !cache.SetIf("a", 1, time.Hour, newer(1)) {
+		t.Fatalf("expired a should be treated as absent")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("a should be 1: %v %v", v, ok)
+	}
+}
+
+func TestTTLCacheEvictionCallback(t *testing.T) {
+	evicted := make(map[string]int)
+	cache := NewTTLCache[string, int](4, WithShards[string, int](1), WithEvictionCallback(func(key string, value int) {
+		evicted[key] = value
+	}))
+
+	for i := 0; i < 6; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+	cache.Delete("5")
+	cache.SetIfAbsent("5", 5, time.Hour)
+
+	if got, want := fmt.Sprint(evicted), "map[0:0 1:1]"; got != want {
+		t.Fatalf("evicted entries %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheEvictionCallbackSkipsExpiredAndMarkers(t *testing.T) {
+	evicted := make(map[string]int)
+	cache := NewTTLCache[string, int](4, WithShards[string, int](1), WithEvictionCallback(func(key string, value int) {
+		evicted[key] = value
+	}))
+
+	cache.SetNegative("negative", time.Hour)
+	cache.Tombstone("tombstone", time.Hour)
+	cache.Set("expired", 1, time.Second)
+	cache.Set("live", 2, time.Hour)
+
+	time.Sleep(2 * time.Second)
+
+	for i := 0; i < 4; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+
+	if got, want := fmt.Sprint(evicted), "map[live:2]"; got != want {
+		t.Fatalf("evicted entries %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheSetSliding(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	cache.SetSliding("session", 1, 4*time.Second)
+	cache.Set("token", 2, 4*time.Second)
+
+	time.Sleep(2 * time.Second)
+	for _, key := range []string{"session", "token"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("%v should not be expired", key)
+		}
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+	if v, ok := cache.Get("session"); !ok || v != 1 {
+		t.Fatalf("session should be slid: %v", v)
+	}
+	if v, ok := cache.Get("token"); ok {
+		t.Fatalf("token should be expired: %v", v)
+	}
+
+	cache.Set("session", 3, 4*time.Second)
+	time.Sleep(2 * time.Second)
+	cache.Get("session")
+	time.Sleep(2500 * time.Millisecond)
+	if v, ok := cache.Get("session"); ok {
+		t.Fatalf("session set by Set should not be slid: %v", v)
+	}
+}
+
+func TestTTLCacheMaxLifetime(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithSliding[string, int](true), WithMaxLifetime[string, int](4*time.Second))
+
+	cache.Set("a", 1, 4*time.Second)
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(time.Second)
+		if _, ok := cache.Get("a"); !ok {
+			t.Fatalf("a should be slid at %v", i)
+		}
+	}
+
+	time.Sleep(3 * time.Second)
+
+	if v, ok := cache.Get("a"); ok {
+		t.Fatalf("a should be expired after max lifetime: %v", v)
+	}
+}
+
+func TestTTLCacheMaxLifetimeRoundUp(t *testing.T) {
+	cases := []struct {
+		lifetime time.Duration
+		seconds  uint32
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{time.Millisecond, 1},
+		{500 * time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+	}
+	for _, c := range cases {
+		cache := NewTTLCache[string, int](128, WithShards[string, int](4), WithMaxLifetime[string, int](c.lifetime))
+		for i := uint32(0); i <= cache.mask; i++ {
+			if n := cache.shards[i].lifetime; n != c.seconds {
+				t.Fatalf("lifetime %v of shard %d should be %d seconds: %d", c.lifetime, i, c.seconds, n)
+			}
+		}
+	}
+}
+
+func TestTTLCacheTombstone(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	cache.Set("a", 1, time.Hour)
+	cache.Tombstone("a", time.Second)
+
+	if v, ok := cache.Get("a"); ok {
+		t.Fatalf("a should be not found: %v", v)
+	}
+	if v, _, ok := cache.Lookup("a"); ok {
+		t.Fatalf("a should be not found by Lookup: %v", v)
+	}
+	if v, _, ok := cache.Peek("a"); ok {
+		t.Fatalf("a should be not found by Peek: %v", v)
+	}
+	if _, replaced := cache.SetIfAbsent("a", 2, time.Hour); replaced {
+		t.Fatalf("a should be blocked by tombstone")
+	}
+	if v, ok := cache.Get("a"); ok {
+		t.Fatalf("a should be not found after SetIfAbsent: %v", v)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if _, replaced := cache.SetIfAbsent("a", 3, time.Hour); !replaced {
+		t.Fatalf("a should be set after tombstone expired")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 3 {
+		t.Fatalf("a should be 3: %v %v", v, ok)
+	}
+}
+
+func TestTTLCacheRemovalListener(t *testing.T) {
+	var removed []string
+	cache := NewTTLCache[string, int](4, WithShards[string, int](1), WithRemovalListener(func(key string, value int, reason RemovalReason) {
+		removed = append(removed, fmt.Sprintf("%s=%d:%s", key, value, reason))
+	}))
+
+	cache.Set("a", 1, time.Second)
+	cache.Set("b", 2, time.Hour)
+	cache.Set("b", 3, time.Hour)
+	cache.Delete("b")
+	cache.SetNegative("c", time.Hour)
+	cache.Delete("c")
+
+	time.Sleep(2 * time.Second)
+
+	cache.Get("a")
+
+	for i := 0; i < 5; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+
+	if got, want := fmt.Sprint(removed), "[b=2:Replaced b=3:Deleted a=1:Expired 0=0:Evicted]"; got != want {
+		t.Fatalf("removed entries %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheSeed(t *testing.T) {
+	newCache := func() *TTLCache[string, int] {
+		cache := NewTTLCache[string, int](1024, WithShards[string, int](4), WithSeed[string, int](42))
+		for i := 0; i < 1024; i++ {
+			cache.Set(fmt.Sprint(i), i, time.Hour)
+		}
+		return cache
+	}
+
+	if got, want := fmt.Sprint(newCache().AppendKeys(nil)), fmt.Sprint(newCache().AppendKeys(nil)); got != want {
+		t.Fatalf("keys order %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheTags(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	cache.SetWithTags("a", 1, time.Hour, "row:1")
+	cache.SetWithTags("b", 2, time.Hour, "row:1", "row:2")
+	cache.SetWithTags("c", 3, time.Hour, "row:2")
+	cache.SetWithTags("d", 4, time.Hour, "row:1")
+	cache.SetWithTags("d", 4, time.Hour)
+
+	if got, want := cache.InvalidateTag("row:1"), 2; got != want {
+		t.Fatalf("invalidated keys %v should be %v", got, want)
+	}
+	for key, want := range map[string]bool{"a": false, "b": false, "c": true, "d": true} {
+		if _, ok := cache.Get(key); ok != want {
+			t.Fatalf("%v should be present %v", key, want)
+		}
+	}
+
+	if got, want := cache.InvalidateTag("row:2"), 1; got != want {
+		t.Fatalf("invalidated keys %v should be %v", got, want)
+	}
+	if got, want := cache.InvalidateTag("row:1"), 0; got != want {
+		t.Fatalf("invalidated keys %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheTagsUnlink(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	cache.SetWithTags("a", 1, time.Hour, "row:1")
+	cache.SetWithTags("b", 2, time.Hour, "row:1")
+	cache.Delete("a")
+	cache.Set("a", 1, time.Hour)
+	cache.Set("b", 2, time.Hour)
+
+	if got, want := cache.InvalidateTag("row:1"), 0; got != want {
+		t.Fatalf("invalidated keys %v should be %v", got, want)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("%v set without tags should not be invalidated", key)
+		}
+	}
+}
+
+func TestTTLCacheTagsPrune(t *testing.T) {
+	cache := NewTTLCache[int, int](128, WithShards[int, int](1))
+
+	for i := 0; i < 8*minIndexLimit; i++ {
+		cache.SetWithTags(i, i, time.Hour, "all")
+	}
+	if got, max := len(cache.tags.keys), 2*minIndexLimit+1; got > max {
+		t.Fatalf("tagged keys %v should be pruned to at most %v", got, max)
+	}
+	if got, want := cache.InvalidateTag("all"), 128; got < want {
+		t.Fatalf("invalidated keys %v should be at least %v", got, want)
+	}
+	if got, want := cache.Len(), 0; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheClear(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithShards[string, int](4))
+
+	for i := 0; i < 256; i++ {
+		cache.SetWithTags(fmt.Sprint(i), i, time.Hour, "all")
+	}
+	cache.Clear()
+
+	if got, want := cache.Len(), 0; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+	if got, want := cache.InvalidateTag("all"), 0; got != want {
+		t.Fatalf("tagged keys %v should be %v", got, want)
+	}
+
+	for i := 0; i < 256; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+	for i := uint32(0); i <= cache.mask; i++ {
+		checkTTLShardList(t, &cache.shards[i])
+	}
+	if v, ok := cache.Get("255"); !ok || v != 255 {
+		t.Fatalf("255 should be set after clear: %v %v", v, ok)
+	}
+}
+
+func TestTTLCacheContains(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	cache.Set("a", 1, time.Second)
+	cache.SetNegative("b", time.Hour)
+
+	if !cache.Contains("a") {
+		t.Fatalf("a should be contained")
+	}
+	if cache.Contains("b") {
+		t.Fatalf("negative b should not be contained")
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if cache.Contains("a") {
+		t.Fatalf("a should be expired")
+	}
+}
+
+func TestTTLCacheLink(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	cache.Set("page", 1, time.Hour)
+	cache.Set("fragment", 2, time.Hour)
+	cache.Link("page", "fragment")
+
+	cache.Delete("fragment")
+	cache.Set("fragment", 3, time.Hour)
+	if !cache.Contains("page") {
+		t.Fatalf("page should not be deleted by child")
+	}
+
+	cache.Delete("page")
+	if cache.Contains("fragment") {
+		t.Fatalf("fragment should be deleted by parent")
+	}
+}
+
+func TestTTLCacheLinkExpired(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	cache.Set("page", 1, time.Second)
+	cache.Set("fragment", 2, time.Hour)
+	cache.Link("page", "fragment")
+
+	time.Sleep(2 * time.Second)
+
+	cache.Set("page", 3, time.Hour)
+	cache.Delete("page")
+	if !cache.Contains("fragment") {
+		t.Fatalf("fragment should not be deleted by the expired parent")
+	}
+}
+
+func TestTTLCacheRevalidator(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithShards[string, int](2))
+
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	calls := 0
+	cache.StartRevalidator(ctx, 10*time.Millisecond, func(key string, value int) (int, time.Duration, bool) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return value * 10, time.Hour, value%2 == 0
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	mu.Lock()
+	if calls == 0 {
+		t.Fatalf("revalidator should be called")
+	}
+	mu.Unlock()
+	if v, _, ok := cache.Peek("1"); ok {
+		t.Fatalf("1 should be deleted: %v", v)
+	}
+	if v, _, ok := cache.Peek("2"); !ok || v%20 != 0 {
+		t.Fatalf("2 should be updated: %v %v", v, ok)
+	}
+}
+
+func TestTTLCacheTombstoneGetOrLoad(t *testing.T) {
+	cache := NewTTLCache[string, string](128)
+
+	loads := 0
+	loader := func(ctx context.Context, key string) (string, time.Duration, error) {
+		loads++
+		return "stale", time.Hour, nil
+	}
+
+	cache.Set("k", "v", time.Hour)
+	cache.Tombstone("k", time.Hour)
+
+	if v, err, _ := cache.GetOrLoad(context.Background(), "k", loader); err != nil || v != "" {
+		t.Fatalf("tombstoned k should be a miss: %v %v", v, err)
+	}
+	if r := <-cache.GetOrLoadChan(context.Background(), "k"); r.OK || r.Err != nil {
+		t.Fatalf("tombstoned k should be a miss of GetOrLoadChan: %+v", r)
+	}
+	if loads != 0 {
+		t.Fatalf("loader should not be called for tombstoned k: %v", loads)
+	}
+
+	// a load finishing after the tombstone does not resurrect the key.
+	started, tombstoned := make(chan struct{}), make(chan struct{})
+	go func() {
+		<-started
+		cache.Tombstone("slow", time.Hour)
+		close(tombstoned)
+	}()
+	v, err, _ := cache.GetOrLoad(context.Background(), "slow", func(ctx context.Context, key string) (string, time.Duration, error) {
+		close(started)
+		<-tombstoned
+		return "stale", time.Hour, nil
+	})
+	if err != nil || v != "stale" {
+		t.Fatalf("slow load should return its value: %v %v", v, err)
+	}
+	if v, ok := cache.Get("slow"); ok {
+		t.Fatalf("slow load should not overwrite the tombstone: %v", v)
+	}
+	if _, replaced := cache.SetIfAbsent("slow", "new", time.Hour); replaced {
+		t.Fatalf("tombstone of slow should still block SetIfAbsent")
+	}
+}
+
+func TestTTLCacheTombstoneRevalidator(t *testing.T) {
+	cache := NewTTLCache[string, string](128, WithShards[string, string](1), WithLoader[string, string](func(ctx context.Context, key string) (string, time.Duration, error) {
+		return "loaded", time.Hour, nil
+	}))
+
+	cache.Set("a", "a", time.Hour)
+	cache.Set("d", "d", time.Hour)
+	cache.Tombstone("t", time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache.StartRevalidator(ctx, 10*time.Millisecond, func(key string, value string) (string, time.Duration, bool) {
+		return "rrrrr", time.Hour, key != "d"
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if v, ok := cache.Get("a"); !ok || v != "rrrrr" {
+		t.Fatalf("a should be revalidated: %v %v", v, ok)
+	}
+	if v, ok := cache.Get("d"); ok {
+		t.Fatalf("d should be deleted: %v", v)
+	}
+	if v, ok := cache.Get("t"); ok {
+		t.Fatalf("tombstone t should not be revalidated: %v", v)
+	}
+	if v, err, _ := cache.GetOrLoad(context.Background(), "t", nil); err != nil || v != "" {
+		t.Fatalf("tombstone t should not be loaded: %v %v", v, err)
+	}
+	if _, replaced := cache.SetIfAbsent("t", "new", time.Hour); replaced {
+		t.Fatalf("tombstone t should still block SetIfAbsent")
+	}
+}
+
+func TestTTLCacheIndex(t *testing.T) {
+	cache := NewTTLCache[string, int](4, WithShards[string, int](1), WithIndex[string, int]("parity", func(v int) bool { return v%2 == 0 }))
+
+	for i := 0; i < 8; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+	cache.SetNegative("7", time.Hour)
+
+	keys, _ := cache.GetByIndex("parity", true)
+	sort.Strings(keys)
+	if got, want := fmt.Sprint(keys), "[4 6]"; got != want {
+		t.Fatalf("even keys %v should be %v", got, want)
+	}
+	if keys, _ := cache.GetByIndex("parity", false); fmt.Sprint(keys) != "[5]" {
+		t.Fatalf("odd keys should be [5]: %v", keys)
+	}
+}
+
+func TestTTLCacheIndexPeek(t *testing.T) {
+	cache := NewTTLCache[string, int](2, WithShards[string, int](1), WithIndex[string, int]("parity", func(v int) bool { return v%2 == 0 }))
+
+	cache.Set("a", 0, time.Hour)
+	cache.Set("b", 1, time.Hour)
+	stats := cache.Stats()
+
+	if keys, _ := cache.GetByIndex("parity", true); fmt.Sprint(keys) != "[a]" {
+		t.Fatalf("even keys should be [a]: %v", keys)
+	}
+	if got := cache.Stats(); got != stats {
+		t.Fatalf("stats %+v should not be changed from %+v", got, stats)
+	}
+
+	cache.Set("c", 2, time.Hour)
+	if cache.Contains("a") {
+		t.Fatalf("a should be evicted without promotion by GetByIndex")
+	}
+}
+
+func TestTTLCacheSetExpires(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	cache.SetExpires("a", 1, expiresAt)
+	if _, expires, ok := cache.Peek("a"); !ok || expires != expiresAt.UnixNano() {
+		t.Fatalf("a should be expired at %v: %v", expiresAt, time.Unix(0, expires))
+	}
+
+	cache.SetExpires("a", 2, time.Now().Add(-time.Second))
+	if v, ok := cache.Get("a"); ok {
+		t.Fatalf("a should be deleted by past expiration: %v", v)
+	}
+}
+
+func TestTTLCacheGetOrSet(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	if actual, loaded := cache.GetOrSet("a", 1, time.Second); loaded || actual != 1 {
+		t.Fatalf("a should be stored: %v %v", actual, loaded)
+	}
+	if actual, loaded := cache.GetOrSet("a", 2, time.Second); !loaded || actual != 1 {
+		t.Fatalf("a should be loaded: %v %v", actual, loaded)
+	}
+
+	cache.Tombstone("b", time.Hour)
+	if actual, loaded := cache.GetOrSet("b", 2, time.Hour); loaded || actual != 0 {
+		t.Fatalf("b should be blocked by tombstone: %v %v", actual, loaded)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if actual, loaded := cache.GetOrSet("a", 3, time.Hour); loaded || actual != 3 {
+		t.Fatalf("expired a should be stored: %v %v", actual, loaded)
+	}
+}
+
+func TestTTLCacheCompute(t *testing.T) {
+	cache := NewTTLCache[string, int](128)
+
+	incr := func(old int, exists bool) (int, bool) { return old + 1, true }
+
+	cache.Compute("counter", time.Second, incr)
+	if v, ok := cache.Compute("counter", time.Second, incr); !ok || v != 2 {
+		t.Fatalf("counter should be 2: %v %v", v, ok)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if v, ok := cache.Compute("counter", time.Hour, incr); !ok || v != 1 {
+		t.Fatalf("expired counter should be restarted: %v %v", v, ok)
+	}
+}
+
+func TestTTLCacheTryNew(t *testing.T) {
+	for _, size := range []int{-1, MaxShardSize + 1} {
+		cache, err := TryNewTTLCache[int, int](size, WithShards[int, int](1))
+		var serr *SizeError
+		if cache != nil || !errors.As(err, &serr) || serr.Size != size || serr.Shards != 1 {
+			t.Fatalf("TryNewTTLCache(%v) should return SizeError: %v %v", size, cache, err)
+		}
+	}
+
+	cache, err := TryNewTTLCache[int, int](1024, WithShards[int, int](4))
+	if err != nil {
+		t.Fatalf("TryNewTTLCache(1024) should not return error: %v", err)
+	}
+	cache.Set(1, 10, time.Hour)
+	if v, ok := cache.Get(1); !ok || v != 10 {
+		t.Fatalf("cache.Get(1) should return 10: %v %v", v, ok)
+	}
+}
+
+func TestTTLCacheGetMulti(t *testing.T) {
+	cache := NewTTLCache[string, int](1024, WithShards[string, int](8))
+
+	cache.Set("a", 1, time.Hour)
+	cache.Set("b", 2, time.Second)
+	cache.SetNegative("c", time.Hour)
+
+	time.Sleep(2 * time.Second)
+
+	if got, want := fmt.Sprint(cache.GetMulti([]string{"a", "b", "c", "d"})), "map[a:1]"; got != want {
+		t.Fatalf("values %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheGetOrLoadBypass(t *testing.T) {
+	var loads int32
+	loader := func(ctx context.Context, key string) (int, time.Duration, error) {
+		n := atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return int(n), time.Hour, nil
+	}
+
+	cache := NewTTLCache[string, int](128, WithLoader[string, int](loader))
+
+	if v, err, _ := cache.GetOrLoad(context.Background(), "a", nil); err != nil || v != 1 {
+		t.Fatalf("a should be loaded: %v %+v", v, err)
+	}
+	if v, err, _ := cache.GetOrLoadBypass(context.Background(), "a", nil); err != nil || v != 2 {
+		t.Fatalf("a should be reloaded: %v %+v", v, err)
+	}
+	if v, ok := cache.Get("a"); !ok || v != 2 {
+		t.Fatalf("a should be overwritten: %v %v", v, ok)
+	}
+
+	// concurrent bypasses are collapsed.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.GetOrLoadBypass(context.Background(), "a", nil)
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&loads); got < 3 || got > 4 {
+		t.Fatalf("loads %v should be collapsed", got)
+	}
+}
+
+func TestTTLCacheGetMultiOrLoad(t *testing.T) {
+	cache := NewTTLCache[string, int](1024, WithShards[string, int](8))
+
+	cache.Set("a", 1, time.Hour)
+
+	var loaded []string
+	loader := func(ctx context.Context, keys []string) (entries []Entry[string, int], err error) {
+		loaded = append(loaded, keys...)
+		for _, key := range keys {
+			if key != "d" {
+				entries = append(entries, Entry[string, int]{Key: key, Value: int(key[0] - 'a' + 1), TTL: time.Minute})
+			}
+		}
+		return
+	}
+
+	values, err := cache.GetMultiOrLoad(context.Background(), []string{"a", "b", "c", "b", "d"}, loader)
+	if err != nil {
+		t.Fatalf("get multi or load error: %+v", err)
+	}
+	if got, want := fmt.Sprint(loaded), "[b c d]"; got != want {
+		t.Fatalf("loaded keys %v should be %v", got, want)
+	}
+	if got, want := fmt.Sprint(values), "map[a:1 b:2 c:3]"; got != want {
+		t.Fatalf("values %v should be %v", got, want)
+	}
+	if _, expires, ok := cache.Peek("c"); !ok || time.Until(time.Unix(0, expires)) > time.Minute {
+		t.Fatalf("c should be expired in a minute: %v %v", expires, ok)
+	}
+
+	loaded = nil
+	if _, err := cache.GetMultiOrLoad(context.Background(), []string{"a", "b", "c"}, loader); err != nil || len(loaded) != 0 {
+		t.Fatalf("loader should not be called for hits: %+v %v", err, loaded)
+	}
+
+	values, err = cache.GetMultiOrLoad(context.Background(), []string{"a", "e"}, func(context.Context, []string) ([]Entry[string, int], error) {
+		return nil, context.Canceled
+	})
+	if err != context.Canceled || len(values) != 1 || values["a"] != 1 {
+		t.Fatalf("loader error should return hits: %+v %v", err, values)
+	}
+	if _, err := cache.GetMultiOrLoad(context.Background(), []string{"e"}, nil); err != ErrLoaderIsNil {
+		t.Fatalf("nil loader should be error: %+v", err)
+	}
+}
+
+func TestTTLCacheSetMulti(t *testing.T) {
+	cache := NewTTLCache[string, int](1024, WithShards[string, int](8))
+
+	cache.SetMulti([]Entry[string, int]{
+		{Key: "a", Value: 1, TTL: time.Second},
+		{Key: "b", Value: 2, TTL: time.Hour},
+		{Key: "c", Value: 3},
+	})
+
+	time.Sleep(2 * time.Second)
+
+	if got, want := fmt.Sprint(cache.GetMulti([]string{"a", "b", "c"})), "map[b:2 c:3]"; got != want {
+		t.Fatalf("values %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheDeleteIf(t *testing.T) {
+	cache := NewTTLCache[string, int](1024, WithShards[string, int](4))
+
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+
+	deleted := cache.DeleteIf(func(key string, value int) bool { return value%2 == 0 })
+	if got, want := deleted, 50; got != want {
+		t.Fatalf("deleted %v should be %v", got, want)
+	}
+	if got, want := cache.Len(), 50; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+	for i := uint32(0); i <= cache.mask; i++ {
+		checkTTLShardList(t, &cache.shards[i])
+	}
+}
+
+func TestTTLCacheLoadCostTTL(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithLoadCostTTL[string, int](100*time.Millisecond, 10))
+
+	loader := func(ctx context.Context, key string) (int, time.Duration, error) {
+		if key == "slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return 1, time.Second, nil
+	}
+
+	cache.GetOrLoad(context.Background(), "slow", loader)
+	cache.GetOrLoad(context.Background(), "fast", loader)
+
+	if _, expires, _ := cache.Peek("slow"); time.Until(time.Unix(0, expires)) < 1500*time.Millisecond {
+		t.Fatalf("slow should be stretched to 3s: %v", time.Until(time.Unix(0, expires)))
+	}
+	if _, expires, _ := cache.Peek("fast"); time.Until(time.Unix(0, expires)) > time.Second {
+		t.Fatalf("fast should be kept as 1s: %v", time.Until(time.Unix(0, expires)))
+	}
+}
+
+func TestTTLCacheSnapshot(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithShards[string, int](1))
+
+	for i := 0; i < 5; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+	cache.Set("forever", 42, 0)
+	cache.Set("expired", 0, time.Second)
+	cache.Get("0")
+
+	time.Sleep(2 * time.Second)
+
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf, jsonCodec[string]{}, jsonCodec[int]{}); err != nil {
+		t.Fatalf("save snapshot error: %+v", err)
+	}
+
+	// the smaller cache keeps the most recent entries.
+	restored, err := NewTTLCacheFromReader[string, int](&buf, 4, jsonCodec[string]{}, jsonCodec[int]{}, WithShards[string, int](1))
+	if err != nil {
+		t.Fatalf("load snapshot error: %+v", err)
+	}
+	if got, want := fmt.Sprint(restored.EvictionOrder(10)), "[3 4 forever 0]"; got != want {
+		t.Fatalf("restored eviction order %v should be %v", got, want)
+	}
+	if _, expires, _ := restored.Peek("3"); time.Until(time.Unix(0, expires)) < 50*time.Minute {
+		t.Fatalf("3 should be expired in an hour: %v", time.Unix(0, expires))
+	}
+	if _, expires, _ := restored.Peek("forever"); expires != 0 {
+		t.Fatalf("forever should have no ttl: %v", expires)
+	}
+
+	if _, err := NewTTLCacheFromReader[string, int](strings.NewReader("LRU\x01\x05ab"), 4, jsonCodec[string]{}, jsonCodec[int]{}); err != ErrInvalidSnapshot {
+		t.Fatalf("truncated snapshot should be invalid: %+v", err)
+	}
+}
+
+func TestTTLCacheLiveKeys(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithShards[string, int](1))
+
+	cache.Set("a", 1, time.Hour)
+	cache.Set("b", 2, time.Second)
+	cache.SetNegative("c", time.Hour)
+	cache.Set("d", 4, 0)
+
+	time.Sleep(2 * time.Second)
+
+	keys := cache.LiveKeys(nil)
+	sort.Strings(keys)
+	if got, want := fmt.Sprint(keys), "[a d]"; got != want {
+		t.Fatalf("live keys %v should be %v", got, want)
+	}
+	keys = cache.AppendKeys(nil)
+	sort.Strings(keys)
+	if got, want := fmt.Sprint(keys), "[a d]"; got != want {
+		t.Fatalf("keys %v should be %v", got, want)
+	}
+	keys = cache.AllKeys(nil)
+	sort.Strings(keys)
+	if got, want := fmt.Sprint(keys), "[a b c d]"; got != want {
+		t.Fatalf("all keys %v should be %v", got, want)
+	}
+	if got, want := cache.Len(), len(keys); got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheKeysSkipMarkers(t *testing.T) {
+	cache := NewTTLCache[string, string](128, WithShards[string, string](1))
+
+	cache.Set("a", "x", time.Hour)
+	cache.Tombstone("t", time.Hour)
+	cache.SetNegative("n", time.Hour)
+
+	if got, want := fmt.Sprint(cache.AppendKeys(nil)), "[a]"; got != want {
+		t.Fatalf("keys %v should be %v", got, want)
+	}
+	if keys, _ := cache.ScanKeys(0, 128); fmt.Sprint(keys) != "[a]" {
+		t.Fatalf("scanned keys %v should be [a]", keys)
+	}
+
+	var mu sync.Mutex
+	var entries []string
+	err := cache.ForEachParallel(context.Background(), 2, func(key string, value string) error {
+		mu.Lock()
+		entries = append(entries, key+"="+value)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("for each parallel error: %v", err)
+	}
+	if got, want := fmt.Sprint(entries), "[a=x]"; got != want {
+		t.Fatalf("entries %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheRange(t *testing.T) {
+	cache := NewTTLCache[string, int](1024, WithShards[string, int](4))
+
+	for i := 0; i < 500; i++ {
+		cache.Set(fmt.Sprint(i), i, time.Hour)
+	}
+
+	for _, batch := range []int{0, 1, 7, 4096} {
+		seen := make(map[string]int)
+		cache.Range(batch, func(key string, value int) bool {
+			seen[key] = value
+			return true
+		})
+		if got, want := len(seen), 500; got != want {
+			t.Fatalf("range with batch %v visited %v entries, should be %v", batch, got, want)
+		}
+		for key, value := range seen {
+			if fmt.Sprint(value) != key {
+				t.Fatalf("value of %v should be %v", key, value)
+			}
+		}
+	}
+
+	n := 0
+	cache.Range(7, func(key string, value int) bool {
+		n++
+		return n < 10
+	})
+	if got, want := n, 10; got != want {
+		t.Fatalf("range should stop after %v entries, got %v", want, got)
+	}
+}
+
+func TestTTLCacheResetStats(t *testing.T) {
+	cache := NewTTLCache[string, int](4, WithShards[string, int](1))
+
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		cache.Set(key, i, 0)
+	}
+	cache.Get("a")
+	cache.Get("e")
+
+	stats := cache.ResetStats()
+	if got, want := stats, (Stats{GetCalls: 2, SetCalls: 5, Misses: 1, EntriesCount: 4, Evictions: 1}); got != want {
+		t.Fatalf("stats before reset %+v should be %+v", got, want)
+	}
+	if got, want := cache.Stats(), (Stats{EntriesCount: 4}); got != want {
+		t.Fatalf("stats after reset %+v should be %+v", got, want)
+	}
+}
+
+func TestTTLCacheLoaderRetry(t *testing.T) {
+	var calls int
+	cache := NewTTLCache[string, int](128, WithLoaderRetry[string, int](2, 0), WithLoaderTimeout[string, int](time.Second))
+
+	value, err, _ := cache.GetOrLoad(context.Background(), "a", func(ctx context.Context, key string) (int, time.Duration, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("loader context should have a deadline")
+		}
+		if calls++; calls < 2 {
+			return 0, 0, errors.New("unavailable")
+		}
+		return 42, time.Minute, nil
+	})
+	if err != nil || value != 42 {
+		t.Fatalf("a should be loaded by retries: %v %v", value, err)
+	}
+	if got, want := calls, 2; got != want {
+		t.Fatalf("loader calls should be %v: %v", want, got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	if _, err, _ := cache.GetOrLoad(ctx, "b", func(ctx context.Context, key string) (int, time.Duration, error) {
+		calls++
+		return 0, 0, ctx.Err()
+	}); err != context.Canceled {
+		t.Fatalf("b should be canceled: %v", err)
+	}
+	if got, want := calls, 1; got != want {
+		t.Fatalf("loader calls should be %v: %v", want, got)
+	}
+}
+
+func TestTTLCacheGetOrLoadChan(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	cache := NewTTLCache[string, int](128, WithLoader[string, int](func(ctx context.Context, key string) (int, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return len(key), time.Minute, nil
+	}))
+
+	ch1 := cache.GetOrLoadChan(context.Background(), "abc")
+	ch2 := cache.GetOrLoadChan(context.Background(), "abc")
+	select {
+	case r := <-ch1:
+		t.Fatalf("result should not be ready: %+v", r)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	for _, ch := range []<-chan Result[int]{ch1, ch2} {
+		if r := <-ch; r.Err != nil || r.Value != 3 || !r.OK {
+			t.Fatalf("abc should be loaded: %+v", r)
+		}
+	}
+	if got, want := atomic.LoadInt32(&calls), int32(1); got != want {
+		t.Fatalf("loader calls should be %v: %v", want, got)
+	}
+
+	if r := <-cache.GetOrLoadChan(context.Background(), "abc"); r.Err != nil || r.Value != 3 || !r.OK {
+		t.Fatalf("abc should be cached: %+v", r)
+	}
+	if r := <-NewTTLCache[string, int](128).GetOrLoadChan(context.Background(), "abc"); r.Err != ErrLoaderIsNil {
+		t.Fatalf("loader should be nil: %+v", r)
+	}
+}
+
+func TestTTLCacheActiveExpiry(t *testing.T) {
+	cache := NewTTLCache[int, int](1024, WithShards[int, int](4), WithActiveExpiry[int, int](10*time.Millisecond))
+
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i, time.Second)
+	}
+	cache.Set(100, 100, time.Hour)
+
+	time.Sleep(2500 * time.Millisecond)
+
+	if got, want := cache.Len(), 1; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+	if got, want := cache.Stats().Expired, uint64(100); got != want {
+		t.Fatalf("expired entries %v should be %v", got, want)
+	}
+}
+
+func TestTTLCacheActiveExpiryClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 100; i++ {
+		cache := NewTTLCache[int, int](128, WithActiveExpiry[int, int](time.Millisecond))
+		cache.Set(i, i, time.Second)
+		cache.Close()
+		cache.Close()
+	}
+
+	// the other tests may leave goroutines exiting, so allow them a moment.
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > before {
+		t.Fatalf("goroutines %v should be back to %v", got, before)
+	}
+
+	cache := NewTTLCache[int, int](128)
+	cache.Close()
+	cache.Set(1, 1, 0)
+	if v, ok := cache.Get(1); !ok || v != 1 {
+		t.Fatalf("closed cache should be usable: %v %v", v, ok)
+	}
+}
+
+func TestTTLCacheDeleteExpired(t *testing.T) {
+	var expired []int
+	cache := NewTTLCache[int, int](1024, WithRemovalListener[int, int](func(key int, value int, reason RemovalReason) {
+		if reason == RemovalExpired {
+			expired = append(expired, key)
+		}
+	}))
+
+	for i := 0; i < 10; i++ {
+		cache.Set(i, i, time.Second)
+	}
+	cache.Set(10, 10, time.Hour)
+	cache.Set(11, 11, 0)
+
+	if got, want := cache.DeleteExpired(), 0; got != want {
+		t.Fatalf("deleted entries %v should be %v", got, want)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if got, want := cache.DeleteExpired(), 10; got != want {
+		t.Fatalf("deleted entries %v should be %v", got, want)
+	}
+	if got, want := cache.Len(), 2; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+	if got, want := len(expired), 10; got != want {
+		t.Fatalf("expired notifications %v should be %v", got, want)
+	}
+	if v, ok := cache.Get(10); !ok || v != 10 {
+		t.Fatalf("bad returned value: %v != %v", v, 10)
+	}
+}
+
+func TestTTLCacheStopClock(t *testing.T) {
+	NewTTLCache[int, int](128)
+
+	StopClock()
+	StopClock()
+
+	stopped := atomic.LoadUint32(&clock)
+	time.Sleep(1200 * time.Millisecond)
+	if got := atomic.LoadUint32(&clock); got != stopped {
+		t.Fatalf("stopped clock %v should not advance to %v", stopped, got)
+	}
+
+	NewTTLCache[int, int](128)
+
+	if got := atomic.LoadUint32(&clock); got <= stopped {
+		t.Fatalf("restarted clock %v should advance from %v", got, stopped)
+	}
+}
+
+func TestTTLCacheDefaultTTL(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithDefaultTTL[string, int](time.Hour))
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, time.Minute)
+	cache.Set("c", 3, -1)
+	cache.SetMulti([]Entry[string, int]{{Key: "d", Value: 4}})
+
+	for key, want := range map[string]time.Duration{"a": time.Hour, "b": time.Minute, "c": 0, "d": time.Hour} {
+		_, expires, ok := cache.Peek(key)
+		if !ok {
+			t.Fatalf("%v should be set", key)
+		}
+		if want == 0 {
+			if expires != 0 {
+				t.Fatalf("%v should never expire: %v", key, expires)
+			}
+			continue
+		}
+		if got := time.Until(time.Unix(0, expires)); got < want-2*time.Second || got > want {
+			t.Fatalf("%v should expire in %v: %v", key, want, got)
+		}
+	}
+}
+
+func TestTTLCacheMaxTTL(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithMaxTTL[string, int](time.Hour))
+
+	cache.Set("a", 1, 10*365*24*time.Hour)
+	cache.Set("b", 2, time.Minute)
+	cache.Set("c", 3, 0)
+
+	for key, want := range map[string]time.Duration{"a": time.Hour, "b": time.Minute, "c": time.Hour} {
+		_, expires, ok := cache.Peek(key)
+		if !ok {
+			t.Fatalf("%v should be set", key)
+		}
+		if got := time.Until(time.Unix(0, expires)); got < want-2*time.Second || got > want {
+			t.Fatalf("%v should expire in %v: %v", key, want, got)
+		}
+	}
+}
+
+func TestTTLCacheMaxTTLSetExpires(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithMaxTTL[string, int](time.Hour))
+
+	cache.SetExpires("a", 1, time.Now().Add(10*365*24*time.Hour))
+	cache.SetExpires("b", 2, time.Now().Add(time.Minute))
+
+	for key, want := range map[string]time.Duration{"a": time.Hour, "b": time.Minute} {
+		_, expires, ok := cache.Peek(key)
+		if !ok {
+			t.Fatalf("%v should be set", key)
+		}
+		if got := time.Until(time.Unix(0, expires)); got < want-2*time.Second || got > want {
+			t.Fatalf("%v should expire in %v: %v", key, want, got)
+		}
+	}
+}
+
+func TestTTLCacheMaxTTLSetNegative(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithMaxTTL[string, int](time.Hour))
+
+	cache.SetNegative("a", 10*365*24*time.Hour)
+	cache.SetNegative("b", 0)
+
+	for _, key := range []string{"a", "b"} {
+		_, expires, _ := cache.Peek(key)
+		if got := time.Until(time.Unix(0, expires)); got < time.Hour-2*time.Second || got > time.Hour {
+			t.Fatalf("negative %v should expire in %v: %v", key, time.Hour, got)
+		}
+	}
+}
+
+func TestTTLCacheMaxTTLTombstone(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithMaxTTL[string, int](time.Hour))
+
+	cache.Tombstone("a", 10*365*24*time.Hour)
+	cache.Tombstone("b", 0)
+
+	for _, key := range []string{"a", "b"} {
+		_, expires, _ := cache.Peek(key)
+		if got := time.Until(time.Unix(0, expires)); got < time.Hour-2*time.Second || got > time.Hour {
+			t.Fatalf("tombstone %v should expire in %v: %v", key, time.Hour, got)
+		}
+	}
+}
+
+func TestTTLCacheStatsEvictions(t *testing.T) {
+	cache := NewTTLCache[string, int](4, WithShards[string, int](1))
+
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		cache.Set(key, i, 0)
+	}
+	if got, want := cache.Stats().Evictions, uint64(1); got != want {
+		t.Fatalf("cache evictions should be %v: %v", want, got)
+	}
+	if got, want := cache.EvictToWatermark(2), 2; got != want {
+		t.Fatalf("cache evicted should be %v: %v", want, got)
+	}
+	if got, want := cache.Stats().Evictions, uint64(3); got != want {
+		t.Fatalf("cache evictions should be %v: %v", want, got)
+	}
+
+	cache.BumpEpoch()
+	if _, ok := cache.Get("e"); ok {
+		t.Fatalf("e should be expired")
+	}
+	cache.Set("f", 6, 0)
+	cache.Set("g", 7, 0)
+	cache.Set("h", 8, 0)
+	cache.Set("i", 9, 0)
+
+	stats := cache.Stats()
+	if got, want := stats.Expired, uint64(2); got != want {
+		t.Fatalf("cache expired should be %v: %v", want, got)
+	}
+	if got, want := stats.Evictions, uint64(3); got != want {
+		t.Fatalf("cache evictions should be %v: %v", want, got)
+	}
+}
+
+func TestTTLCacheStrictExpiry(t *testing.T) {
+	cache := NewTTLCache[string, int](128, WithShards[string, int](1))
+	strict := NewTTLCache[string, int](128, WithShards[string, int](1), WithStrictExpiry[string, int](true))
+
+	cache.Set("a", 1, time.Second)
+	strict.Set("a", 1, time.Second)
+
+	// wait for the second of the expiration, which is kept by AppendKeys unless WithStrictExpiry.
+	expires := atomic.LoadUint32(&clock) + 1
+	for atomic.LoadUint32(&clock) < expires {
+		time.Sleep(10 * time.Millisecond)
+	}
+	keys, strictKeys := cache.AppendKeys(nil), strict.AppendKeys(nil)
+	if atomic.LoadUint32(&clock) != expires {
+		t.Skip("the clock passes the second of the expiration")
+	}
+
+	if got, want := fmt.Sprint(keys), "[a]"; got != want {
+		t.Fatalf("keys %v should be %v", got, want)
+	}
+	if got, want := fmt.Sprint(strictKeys), "[]"; got != want {
+		t.Fatalf("strict keys %v should be %v", got, want)
+	}
+	if got, want := cache.Len(), 1; got != want {
+		t.Fatalf("cache length %v should be %v", got, want)
+	}
+}
+
 func BenchmarkTTLCacheRand(b *testing.B) {
 	cache := NewTTLCache[int64, int64](8192)
 
@@ -12,22 +12,46 @@ import (
 // always use `atomic.LoadUint32(&clock)` for accessing clock value.
 var clock uint32
 
-var clockOnce sync.Once
+// clockStop stops the clock goroutine, nil if it is not running, and clockDone is closed once it returns.
+var clockStop, clockDone chan struct{}
+
+var clockMu sync.Mutex
 
 const clockBase = 1704067200 // 2024-01-01T00:00:00Z
 
 func clocking() {
-	clockOnce.Do(func() {
-		atomic.StoreUint32(&clock, uint32(time.Now().Unix()-clockBase))
-		go func(clock *uint32) {
-			for {
-				for i := 0; i < 9; i++ {
-					time.Sleep(100 * time.Millisecond)
-					atomic.StoreUint32(clock, uint32(time.Now().Unix()-clockBase))
-				}
-				time.Sleep(100 * time.Millisecond)
+	clockMu.Lock()
+	defer clockMu.Unlock()
+
+	if clockStop != nil {
+		return
+	}
+	atomic.StoreUint32(&clock, uint32(time.Now().Unix()-clockBase))
+	clockStop, clockDone = make(chan struct{}), make(chan struct{})
+	go func(clock *uint32, stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
 				atomic.StoreUint32(clock, uint32(time.Now().Unix()-clockBase))
 			}
-		}(&clock)
-	})
+		}
+	}(&clock, clockStop, clockDone)
+}
+
+// StopClock stops the goroutine which updates the clock of TTLCache and waits for it to return, e.g. before a test exits
+// or a plugin is unloaded. Entries do not expire while the clock is stopped, so call it only when no TTLCache is in use.
+// The clock is started again by the next NewTTLCache.
+func StopClock() {
+	clockMu.Lock()
+	if clockStop != nil {
+		close(clockStop)
+		<-clockDone
+		clockStop, clockDone = nil, nil
+	}
+	clockMu.Unlock()
 }
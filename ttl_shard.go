@@ -16,9 +16,23 @@ type ttlnode[K comparable, V any] struct {
 	next    uint32
 	prev    uint32
 	ttl     uint32
+	created uint32
+	flags   uint8
+	mark    uint8
+	epoch   uint16
 	value   V
 }
 
+// internal marks of ttlnode
+const (
+	ttlmarkNegative uint8 = 1 << iota
+	ttlmarkTombstone
+	ttlmarkSliding // the expiration slides on hits, as the shard sliding
+
+	// the marks of the marker entries, which are not values
+	ttlmarkMarkers = ttlmarkNegative | ttlmarkTombstone
+)
+
 type ttlbucket struct {
 	hdib  uint32 // bitfield { hash:24 dib:8 }
 	index uint32 // node index
@@ -26,66 +40,103 @@ type ttlbucket struct {
 
 // ttlshard is an LRU partition contains a list and a hash table.
 type ttlshard[K comparable, V any] struct {
+	// stats, keep first for 64-bit atomic alignment on 32-bit platforms
+	statsGetCalls uint64
+	statsSetCalls uint64
+	statsMisses   uint64
+
 	mu sync.Mutex
 
 	// the hash table, with 20% extra spacer than the list for fewer conflicts.
 	tableBuckets []uint64 // []ttlbucket
 	tableMask    uint32
 	tableLength  uint32
-	tableHasher  func(key unsafe.Pointer, seed uintptr) uintptr
-	tableSeed    uintptr
+	tableHasher  func(key unsafe.Pointer) uintptr // seeded
+	tableEqual   func(a, b K) bool                // nil for ==
 
 	// the list of nodes
 	list []ttlnode[K, V]
 
-	sliding bool
+	sliding  bool
+	epoch    uint16
+	lifetime uint32
 
-	// stats
-	statsGetCalls uint64
-	statsSetCalls uint64
-	statsMisses   uint64
+	// eviction callback, removal listener and removal counters
+	removal *shardremoval[K, V]
 
-	// padding
-	_ [16]byte
+	// padding, which also keeps the shard size a multiple of 8 on 32-bit platforms
+	_ [16 - unsafe.Sizeof(uintptr(0))]byte
 }
 
 func (s *ttlshard[K, V]) Init(size uint32, hasher func(key unsafe.Pointer, seed uintptr) uintptr, seed uintptr) {
 	s.listInit(size)
 	s.tableInit(size, hasher, seed)
+	if s.removal == nil {
+		s.removal = new(shardremoval[K, V])
+	}
 }
 
 func (s *ttlshard[K, V]) Get(hash uint32, key K) (value V, ok bool) {
+	var negative bool
+	value, negative, ok = s.Lookup(hash, key)
+	ok = ok && !negative
+	return
+}
+
+func (s *ttlshard[K, V]) Lookup(hash uint32, key K) (value V, negative bool, ok bool) {
+	s.mu.Lock()
+	value, negative, ok = s.lookupLocked(hash, key)
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *ttlshard[K, V]) GetMulti(keys []K, hashes []uint32, order []int, values map[K]V) {
 	s.mu.Lock()
+	for _, i := range order {
+		if value, negative, ok := s.lookupLocked(hashes[i], keys[i]); ok && !negative {
+			values[keys[i]] = value
+		}
+	}
+	s.mu.Unlock()
+}
 
-	s.statsGetCalls++
+// lookupLocked is the same as Lookup, but the caller must hold the shard lock.
+func (s *ttlshard[K, V]) lookupLocked(hash uint32, key K) (value V, negative bool, ok bool) {
+	atomic.AddUint64(&s.statsGetCalls, 1)
 
 	if index, exists := s.tableGet(hash, key); exists {
-		if expires := s.list[index].expires; expires == 0 {
-			s.listMoveToFront(index)
-			// value = s.list[index].value
-			value = (*ttlnode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0]))).value
-			ok = true
-		} else if now := atomic.LoadUint32(&clock); now < expires {
-			if s.sliding {
-				s.list[index].expires = now + s.list[index].ttl
+		// node := &s.list[index]
+		node := (*ttlnode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0])))
+		if now := atomic.LoadUint32(&clock); node.epoch != s.epoch || (node.expires != 0 && now >= node.expires) {
+			atomic.AddUint64(&s.removal.expired, 1)
+			if s.removal.listener != nil {
+				s.notify(node, RemovalExpired)
 			}
-			s.listMoveToFront(index)
-			// value = s.list[index].value
-			value = (*ttlnode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0]))).value
-			ok = true
-		} else {
 			s.listMoveToBack(index)
-			// s.list[index].value = value
-			(*ttlnode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0]))).value = value
+			node.value = value
 			s.tableDelete(hash, key)
-			s.statsMisses++
+			atomic.AddUint64(&s.statsMisses, 1)
+		} else if node.mark&ttlmarkTombstone != 0 {
+			// the tombstone is kept until it expires
+			atomic.AddUint64(&s.statsMisses, 1)
+		} else {
+			if (s.sliding || node.mark&ttlmarkSliding != 0) && node.expires != 0 {
+				node.expires = now + node.ttl
+				// slid entries never live longer than the max lifetime since set.
+				if s.lifetime != 0 && node.expires > node.created+s.lifetime {
+					node.expires = node.created + s.lifetime
+				}
+			}
+			s.listMoveToFront(index)
+			value = node.value
+			negative = node.mark&ttlmarkNegative != 0
+			ok = true
 		}
 	} else {
-		s.statsMisses++
+		atomic.AddUint64(&s.statsMisses, 1)
 	}
 
-	s.mu.Unlock()
-
 	return
 }
 
@@ -97,6 +148,92 @@ func (s *ttlshard[K, V]) Peek(hash uint32, key K) (value V, expires int64, ok bo
 		if e := s.list[index].expires; e > 0 {
 			expires = (int64(e) + clockBase) * int64(time.Second)
 		}
+		ok = s.list[index].mark&ttlmarkMarkers == 0
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *ttlshard[K, V]) Contains(hash uint32, key K) (ok bool) {
+	s.mu.Lock()
+
+	if index, exists := s.tableGet(hash, key); exists {
+		node := &s.list[index]
+		ok = node.epoch == s.epoch && (node.expires == 0 || atomic.LoadUint32(&clock) < node.expires) && node.mark&ttlmarkMarkers == 0
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+// PeekLive returns value for key if it is not expired or a marker, but does not modify its recency or count stats.
+func (s *ttlshard[K, V]) PeekLive(hash uint32, key K) (value V, ok bool) {
+	s.mu.Lock()
+
+	if index, exists := s.tableGet(hash, key); exists {
+		node := &s.list[index]
+		if ok = node.epoch == s.epoch && (node.expires == 0 || atomic.LoadUint32(&clock) < node.expires) && node.mark&ttlmarkMarkers == 0; ok {
+			value = node.value
+		}
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+// Tombstoned reports whether key has a tombstone which is not expired.
+func (s *ttlshard[K, V]) Tombstoned(hash uint32, key K) (ok bool) {
+	s.mu.Lock()
+
+	if index, exists := s.tableGet(hash, key); exists {
+		node := &s.list[index]
+		ok = node.epoch == s.epoch && (node.expires == 0 || atomic.LoadUint32(&clock) < node.expires) && node.mark&ttlmarkTombstone != 0
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *ttlshard[K, V]) GetStale(hash uint32, key K) (value V, expired uint32, ok bool) {
+	s.mu.Lock()
+
+	if index, exists := s.tableGet(hash, key); exists {
+		node := &s.list[index]
+		if now := atomic.LoadUint32(&clock); node.expires != 0 && now >= node.expires {
+			expired = now - node.expires
+		}
+		value = node.value
+		ok = node.mark&ttlmarkMarkers == 0
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *ttlshard[K, V]) Age(hash uint32, key K) (age uint32, ok bool) {
+	s.mu.Lock()
+
+	if index, exists := s.tableGet(hash, key); exists {
+		age = atomic.LoadUint32(&clock) - s.list[index].created
+		ok = true
+	}
+
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *ttlshard[K, V]) GetFlags(hash uint32, key K) (flags uint8, ok bool) {
+	s.mu.Lock()
+
+	if index, exists := s.tableGet(hash, key); exists {
+		flags = s.list[index].flags
 		ok = true
 	}
 
@@ -112,14 +249,27 @@ func (s *ttlshard[K, V]) SetIfAbsent(hash uint32, key K, value V, ttl time.Durat
 		// node := &s.list[index]
 		node := (*ttlnode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0])))
 		prev = node.value
-		if node.expires == 0 || atomic.LoadUint32(&clock) < node.expires {
+		// the negative entries are treated as absent, and the tombstones block the store until they expire.
+		live := node.epoch == s.epoch && (node.expires == 0 || atomic.LoadUint32(&clock) < node.expires)
+		if live && node.mark&ttlmarkNegative == 0 {
 			s.mu.Unlock()
 			return
 		}
 
-		s.statsSetCalls++
+		atomic.AddUint64(&s.statsSetCalls, 1)
 
+		if !live {
+			atomic.AddUint64(&s.removal.expired, 1)
+			if s.removal.listener != nil {
+				s.notify(node, RemovalExpired)
+			}
+		}
+		s.listMoveToFront(index)
 		node.value = value
+		node.created = atomic.LoadUint32(&clock)
+		node.flags = 0
+		node.mark = 0
+		node.epoch = s.epoch
 		if ttl > 0 {
 			node.ttl = uint32(ttl / time.Second)
 			node.expires = atomic.LoadUint32(&clock) + node.ttl
@@ -133,20 +283,32 @@ func (s *ttlshard[K, V]) SetIfAbsent(hash uint32, key K, value V, ttl time.Durat
 		return
 	}
 
-	s.statsSetCalls++
+	atomic.AddUint64(&s.statsSetCalls, 1)
 
 	// index := s.list_Back()
 	// node := &s.list[index]
 	index := s.list[0].prev
 	node := (*ttlnode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0])))
 	evictedValue := node.value
-	s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)), s.tableSeed)), node.key)
+
+	// delete the old key if the list is full, note that the list length is size+1
+	if uint32(len(s.list)-1) < s.tableLength+1 && key != node.key {
+		s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key)
+		s.evict(node)
+	}
 
 	node.key = key
 	node.value = value
+	node.created = atomic.LoadUint32(&clock)
+	node.flags = 0
+	node.mark = 0
+	node.epoch = s.epoch
 	if ttl > 0 {
 		node.ttl = uint32(ttl / time.Second)
 		node.expires = atomic.LoadUint32(&clock) + node.ttl
+	} else {
+		node.ttl = 0
+		node.expires = 0
 	}
 	s.tableSet(hash, key, index)
 	s.listMoveToFront(index)
@@ -157,16 +319,59 @@ func (s *ttlshard[K, V]) SetIfAbsent(hash uint32, key K, value V, ttl time.Durat
 }
 
 func (s *ttlshard[K, V]) Set(hash uint32, key K, value V, ttl time.Duration) (prev V, replaced bool) {
+	return s.SetWithFlags(hash, key, value, ttl, 0)
+}
+
+func (s *ttlshard[K, V]) SetWithFlags(hash uint32, key K, value V, ttl time.Duration, flags uint8) (prev V, replaced bool) {
+	return s.set(hash, key, value, ttl, flags, 0)
+}
+
+func (s *ttlshard[K, V]) SetExpires(hash uint32, key K, value V, expires uint32) (prev V, replaced bool) {
 	s.mu.Lock()
 
-	s.statsSetCalls++
+	prev, replaced = s.setLocked(hash, key, value, 0, 0, 0)
+	if index, exists := s.tableGet(hash, key); exists {
+		node := &s.list[index]
+		node.ttl = expires - node.created
+		node.expires = expires
+	}
+
+	s.mu.Unlock()
+	return
+}
+
+func (s *ttlshard[K, V]) SetSliding(hash uint32, key K, value V, ttl time.Duration) (prev V, replaced bool) {
+	return s.set(hash, key, value, ttl, 0, ttlmarkSliding)
+}
+
+func (s *ttlshard[K, V]) SetNegative(hash uint32, key K, ttl time.Duration) {
+	var zero V
+	s.set(hash, key, zero, ttl, 0, ttlmarkNegative)
+}
+
+func (s *ttlshard[K, V]) Tombstone(hash uint32, key K, ttl time.Duration) {
+	var zero V
+	s.set(hash, key, zero, ttl, 0, ttlmarkTombstone)
+}
+
+func (s *ttlshard[K, V]) set(hash uint32, key K, value V, ttl time.Duration, flags, mark uint8) (prev V, replaced bool) {
+	s.mu.Lock()
+
+	atomic.AddUint64(&s.statsSetCalls, 1)
 
 	if index, exists := s.tableGet(hash, key); exists {
 		// node := &s.list[index]
 		node := (*ttlnode[K, V])(unsafe.Add(unsafe.Pointer(&s.list[0]), uintptr(index)*unsafe.Sizeof(s.list[0])))
 		previousValue := node.value
+		if s.removal.listener != nil {
+			s.notify(node, RemovalReplaced)
+		}
 		s.listMoveToFront(index)
 		node.value = value
+		node.created = atomic.LoadUint32(&clock)
+		node.flags = flags
+		node.mark = mark
+		node.epoch = s.epoch
 		if ttl > 0 {
 			node.ttl = uint32(ttl / time.Second)
 			node.expires = atomic.LoadUint32(&clock) + node.ttl
@@ -186,14 +391,23 @@ func (s *ttlshard[K, V]) Set(hash uint32, key K, value V, ttl time.Duration) (pr
 
 	// delete the old key if the list is full, note that the list length is size+1
 	if len(s.list)-1 < int(s.tableLength+1) && key != node.key {
-		s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)), s.tableSeed)), node.key)
+		s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key)
+		s.evict(node)
 	}
 
 	node.key = key
 	node.value = value
+	node.created = atomic.LoadUint32(&clock)
+	node.flags = flags
+	node.mark = mark
+	node.epoch = s.epoch
 	if ttl > 0 {
 		node.ttl = uint32(ttl / time.Second)
 		node.expires = atomic.LoadUint32(&clock) + node.ttl
+	} else {
+		// the recycled node must not keep the expiration of the evicted key.
+		node.ttl = 0
+		node.expires = 0
 	}
 	s.tableSet(hash, key, index)
 	s.listMoveToFront(index)
@@ -203,12 +417,168 @@ func (s *ttlshard[K, V]) Set(hash uint32, key K, value V, ttl time.Duration) (pr
 	return
 }
 
+// SetUnlessTombstoned is the same as Set, but returns false without setting if key has a tombstone which is not expired.
+func (s *ttlshard[K, V]) SetUnlessTombstoned(hash uint32, key K, value V, ttl time.Duration) (ok bool) {
+	s.mu.Lock()
+
+	if index, exists := s.tableGet(hash, key); exists {
+		node := &s.list[index]
+		if node.epoch == s.epoch && (node.expires == 0 || atomic.LoadUint32(&clock) < node.expires) && node.mark&ttlmarkTombstone != 0 {
+			s.mu.Unlock()
+			return
+		}
+	}
+
+	s.setLocked(hash, key, value, ttl, 0, 0)
+	ok = true
+
+	s.mu.Unlock()
+	return
+}
+
+func (s *ttlshard[K, V]) SetIf(hash uint32, key K, value V, ttl time.Duration, cond func(old V, exists bool) bool) (ok bool) {
+	s.mu.Lock()
+
+	var old V
+	index, exists := s.tableGet(hash, key)
+	if exists {
+		node := &s.list[index]
+		exists = node.epoch == s.epoch && (node.expires == 0 || atomic.LoadUint32(&clock) < node.expires) && node.mark&ttlmarkNegative == 0
+		if exists {
+			old = node.value
+		}
+	}
+	if ok = cond(old, exists); ok {
+		s.setLocked(hash, key, value, ttl, 0, 0)
+	}
+
+	s.mu.Unlock()
+	return
+}
+
+func (s *ttlshard[K, V]) GetOrSet(hash uint32, key K, value V, ttl time.Duration) (actual V, loaded bool) {
+	s.mu.Lock()
+
+	atomic.AddUint64(&s.statsGetCalls, 1)
+
+	if index, exists := s.tableGet(hash, key); exists {
+		node := &s.list[index]
+		if node.epoch == s.epoch && (node.expires == 0 || atomic.LoadUint32(&clock) < node.expires) {
+			switch {
+			case node.mark&ttlmarkTombstone != 0:
+				// the tombstone blocks the store
+				atomic.AddUint64(&s.statsMisses, 1)
+				s.mu.Unlock()
+				return
+			case node.mark&ttlmarkMarkers == 0:
+				s.listMoveToFront(index)
+				actual = node.value
+				loaded = true
+				s.mu.Unlock()
+				return
+			}
+		}
+	}
+
+	atomic.AddUint64(&s.statsMisses, 1)
+	s.setLocked(hash, key, value, ttl, 0, 0)
+	actual = value
+
+	s.mu.Unlock()
+	return
+}
+
+func (s *ttlshard[K, V]) Compute(hash uint32, key K, ttl time.Duration, fn func(old V, exists bool) (new V, keep bool)) (value V, ok bool) {
+	s.mu.Lock()
+
+	var old V
+	index, exists := s.tableGet(hash, key)
+	if exists {
+		node := &s.list[index]
+		exists = node.epoch == s.epoch && (node.expires == 0 || atomic.LoadUint32(&clock) < node.expires) && node.mark&ttlmarkNegative == 0
+		if exists {
+			old = node.value
+		}
+	}
+	if value, ok = fn(old, exists); ok {
+		s.setLocked(hash, key, value, ttl, 0, 0)
+	} else if exists {
+		var zero V
+		node := &s.list[index]
+		if s.removal.listener != nil {
+			s.notify(node, RemovalDeleted)
+		}
+		s.listMoveToBack(index)
+		node.value = zero
+		s.tableDelete(hash, key)
+		value = zero
+	}
+
+	s.mu.Unlock()
+	return
+}
+
+func (s *ttlshard[K, V]) SetMulti(entries []Entry[K, V], hashes []uint32, order []int, entryTTL func(V, time.Duration) time.Duration) {
+	s.mu.Lock()
+	for _, i := range order {
+		ttl := entryTTL(entries[i].Value, entries[i].TTL)
+		s.setLocked(hashes[i], entries[i].Key, entries[i].Value, ttl, 0, 0)
+	}
+	s.mu.Unlock()
+}
+
+// setLocked is the same as set, but the caller must hold the shard lock.
+func (s *ttlshard[K, V]) setLocked(hash uint32, key K, value V, ttl time.Duration, flags, mark uint8) (prev V, replaced bool) {
+	atomic.AddUint64(&s.statsSetCalls, 1)
+
+	index, exists := s.tableGet(hash, key)
+	if exists {
+		s.listMoveToFront(index)
+		replaced = true
+	} else {
+		index = s.list[0].prev
+	}
+	node := &s.list[index]
+	prev = node.value
+	if exists && s.removal.listener != nil {
+		s.notify(node, RemovalReplaced)
+	}
+
+	// delete the old key if the list is full, note that the list length is size+1
+	if !exists && uint32(len(s.list)-1) < s.tableLength+1 && key != node.key {
+		s.tableDelete(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key)
+		s.evict(node)
+	}
+
+	node.key = key
+	node.value = value
+	node.created = atomic.LoadUint32(&clock)
+	node.flags = flags
+	node.mark = mark
+	node.epoch = s.epoch
+	if ttl > 0 {
+		node.ttl = uint32(ttl / time.Second)
+		node.expires = atomic.LoadUint32(&clock) + node.ttl
+	} else if !exists {
+		node.ttl = 0
+		node.expires = 0
+	}
+	if !exists {
+		s.tableSet(hash, key, index)
+		s.listMoveToFront(index)
+	}
+	return
+}
+
 func (s *ttlshard[K, V]) Delete(hash uint32, key K) (v V) {
 	s.mu.Lock()
 
 	if index, exists := s.tableGet(hash, key); exists {
 		node := &s.list[index]
 		value := node.value
+		if s.removal.listener != nil {
+			s.notify(node, RemovalDeleted)
+		}
 		s.listMoveToBack(index)
 		node.value = v
 		s.tableDelete(hash, key)
@@ -220,6 +590,27 @@ func (s *ttlshard[K, V]) Delete(hash uint32, key K) (v V) {
 	return
 }
 
+// DeleteIfValue deletes key if fn reports true for its value under the shard lock, and reports whether it was deleted.
+// The negative entries and tombstones have no value, so they are never deleted by it.
+func (s *ttlshard[K, V]) DeleteIfValue(hash uint32, key K, fn func(value V) bool) (deleted bool) {
+	var zero V
+
+	s.mu.Lock()
+	if index, exists := s.tableGet(hash, key); exists && s.list[index].mark&ttlmarkMarkers == 0 && fn(s.list[index].value) {
+		node := &s.list[index]
+		if s.removal.listener != nil {
+			s.notify(node, RemovalDeleted)
+		}
+		s.listMoveToBack(index)
+		node.value = zero
+		s.tableDelete(hash, key)
+		deleted = true
+	}
+	s.mu.Unlock()
+
+	return
+}
+
 func (s *ttlshard[K, V]) Len() (n uint32) {
 	s.mu.Lock()
 	// inlining s.table_Len()
@@ -237,11 +628,325 @@ func (s *ttlshard[K, V]) AppendKeys(dst []K, now uint32) []K {
 			continue
 		}
 		node := &s.list[b.index]
-		if expires := node.expires; expires == 0 || now <= expires {
+		if expires := node.expires; node.epoch == s.epoch && (expires == 0 || now <= expires) && node.mark&ttlmarkMarkers == 0 {
+			dst = append(dst, node.key)
+		}
+	}
+	s.mu.Unlock()
+
+	return dst
+}
+
+func (s *ttlshard[K, V]) EvictTo(n uint32) (evicted uint32) {
+	var zero V
+
+	s.mu.Lock()
+	// walk from the list back, skipping the free nodes which are not in the table.
+	for index := s.list[0].prev; index != 0 && s.tableLength > n; {
+		node := &s.list[index]
+		prev := node.prev
+		hash := uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key))))
+		if i, exists := s.tableGet(hash, node.key); exists && i == index {
+			s.tableDelete(hash, node.key)
+			s.evict(node)
+			node.value = zero
+			evicted++
+		}
+		index = prev
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *ttlshard[K, V]) AppendLiveKeys(dst []K, now uint32) []K {
+	s.mu.Lock()
+	for _, bucket := range s.tableBuckets {
+		b := (*ttlbucket)(unsafe.Pointer(&bucket))
+		if b.index == 0 {
+			continue
+		}
+		node := &s.list[b.index]
+		if expires := node.expires; node.epoch == s.epoch && (expires == 0 || now < expires) && node.mark&ttlmarkMarkers == 0 {
+			dst = append(dst, node.key)
+		}
+	}
+	s.mu.Unlock()
+
+	return dst
+}
+
+func (s *ttlshard[K, V]) AppendAllKeys(dst []K) []K {
+	s.mu.Lock()
+	for _, bucket := range s.tableBuckets {
+		b := (*ttlbucket)(unsafe.Pointer(&bucket))
+		if b.index == 0 {
+			continue
+		}
+		dst = append(dst, s.list[b.index].key)
+	}
+	s.mu.Unlock()
+
+	return dst
+}
+
+func (s *ttlshard[K, V]) ScanKeys(dst []K, pos uint32, count int, now uint32) ([]K, uint32) {
+	s.mu.Lock()
+	for n := uint32(len(s.tableBuckets)); pos < n; pos++ {
+		if count == 0 {
+			s.mu.Unlock()
+			return dst, pos
+		}
+		b := (*ttlbucket)(unsafe.Pointer(&s.tableBuckets[pos]))
+		if b.index == 0 {
+			continue
+		}
+		node := &s.list[b.index]
+		if expires := node.expires; node.epoch == s.epoch && (expires == 0 || now <= expires) && node.mark&ttlmarkMarkers == 0 {
+			dst = append(dst, node.key)
+			count--
+		}
+	}
+	s.mu.Unlock()
+
+	return dst, 0
+}
+
+// ScanEntries appends the unexpired entries in at most count buckets starting from pos, and returns the next pos,
+// which is 0 if the table is done. The count less than 1 means all buckets.
+func (s *ttlshard[K, V]) ScanEntries(keys []K, values []V, pos uint32, count int, now uint32) ([]K, []V, uint32) {
+	s.mu.Lock()
+	n := uint32(len(s.tableBuckets))
+	end := n
+	if count > 0 && uint64(pos)+uint64(count) < uint64(n) {
+		end = pos + uint32(count)
+	}
+	for ; pos < end; pos++ {
+		b := (*ttlbucket)(unsafe.Pointer(&s.tableBuckets[pos]))
+		if b.index == 0 {
+			continue
+		}
+		node := &s.list[b.index]
+		if expires := node.expires; node.epoch == s.epoch && (expires == 0 || now < expires) && node.mark&ttlmarkMarkers == 0 {
+			keys = append(keys, node.key)
+			values = append(values, node.value)
+		}
+	}
+	s.mu.Unlock()
+
+	if pos == n {
+		pos = 0
+	}
+	return keys, values, pos
+}
+
+func (s *ttlshard[K, V]) AppendEntries(keys []K, values []V, now uint32) ([]K, []V) {
+	s.mu.Lock()
+	for _, bucket := range s.tableBuckets {
+		b := (*ttlbucket)(unsafe.Pointer(&bucket))
+		if b.index == 0 {
+			continue
+		}
+		node := &s.list[b.index]
+		if expires := node.expires; node.epoch == s.epoch && (expires == 0 || now <= expires) && node.mark&ttlmarkMarkers == 0 {
+			keys = append(keys, node.key)
+			values = append(values, node.value)
+		}
+	}
+	s.mu.Unlock()
+
+	return keys, values
+}
+
+func (s *ttlshard[K, V]) AppendEvictionOrder(dst []K, max int) []K {
+	s.mu.Lock()
+	// walk from the list back, skipping the free nodes which are not in the table.
+	for index := s.list[0].prev; index != 0 && max > 0; index = s.list[index].prev {
+		node := &s.list[index]
+		if i, exists := s.tableGet(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key); exists && i == index {
 			dst = append(dst, node.key)
+			max--
 		}
 	}
 	s.mu.Unlock()
 
 	return dst
 }
+
+// Edge returns the least recently used live entry from the list back if back is true,
+// or the most recently used live entry from the list front otherwise.
+func (s *ttlshard[K, V]) Edge(back bool) (key K, value V, ok bool) {
+	step := func(index uint32) uint32 {
+		if back {
+			return s.list[index].prev
+		}
+		return s.list[index].next
+	}
+
+	s.mu.Lock()
+	// skip the free nodes which are not in the table, and the expired or invalidated nodes.
+	now := atomic.LoadUint32(&clock)
+	for index := step(0); index != 0; index = step(index) {
+		node := &s.list[index]
+		if node.epoch != s.epoch || (node.expires != 0 && now >= node.expires) || node.mark&ttlmarkMarkers != 0 {
+			continue
+		}
+		if i, exists := s.tableGet(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key); exists && i == index {
+			key, value, ok = node.key, node.value, true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+// DeleteExpired deletes the expired entries in at most count buckets starting from pos, and returns the next pos,
+// which is 0 if the table is done. The count less than 1 means all buckets.
+func (s *ttlshard[K, V]) DeleteExpired(pos uint32, count int, now uint32) (next uint32, deleted uint32) {
+	var zero V
+
+	s.mu.Lock()
+	n := uint32(len(s.tableBuckets))
+	end := n
+	if count > 0 && uint64(pos)+uint64(count) < uint64(n) {
+		end = pos + uint32(count)
+	}
+	for pos < end {
+		b := (*ttlbucket)(unsafe.Pointer(&s.tableBuckets[pos]))
+		if b.index == 0 {
+			pos++
+			continue
+		}
+		node := &s.list[b.index]
+		if node.epoch == s.epoch && (node.expires == 0 || now < node.expires) {
+			pos++
+			continue
+		}
+		atomic.AddUint64(&s.removal.expired, 1)
+		if s.removal.listener != nil {
+			s.notify(node, RemovalExpired)
+		}
+		s.listMoveToBack(b.index)
+		node.value = zero
+		// the following buckets are shifted backward, so check pos again.
+		s.tableDeleteByIndex(pos)
+		deleted++
+	}
+	s.mu.Unlock()
+
+	if pos == n {
+		pos = 0
+	}
+	return pos, deleted
+}
+
+func (s *ttlshard[K, V]) InvalidateBefore(before uint32) (deleted uint32) {
+	var zero V
+
+	s.mu.Lock()
+	for i := uint32(0); i < uint32(len(s.tableBuckets)); {
+		b := (*ttlbucket)(unsafe.Pointer(&s.tableBuckets[i]))
+		if b.index == 0 || s.list[b.index].created > before {
+			i++
+			continue
+		}
+		node := &s.list[b.index]
+		if s.removal.listener != nil {
+			s.notify(node, RemovalDeleted)
+		}
+		s.listMoveToBack(b.index)
+		node.value = zero
+		// the following buckets are shifted backward, so check i again.
+		s.tableDeleteByIndex(i)
+		deleted++
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *ttlshard[K, V]) DeleteIf(fn func(key K, value V) bool, now uint32) (deleted uint32) {
+	var zero V
+
+	s.mu.Lock()
+	for i := uint32(0); i < uint32(len(s.tableBuckets)); {
+		b := (*ttlbucket)(unsafe.Pointer(&s.tableBuckets[i]))
+		if b.index == 0 {
+			i++
+			continue
+		}
+		node := &s.list[b.index]
+		if node.epoch != s.epoch || (node.expires != 0 && now >= node.expires) || node.mark&ttlmarkMarkers != 0 || !fn(node.key, node.value) {
+			i++
+			continue
+		}
+		if s.removal.listener != nil {
+			s.notify(node, RemovalDeleted)
+		}
+		s.listMoveToBack(b.index)
+		node.value = zero
+		// the following buckets are shifted backward, so check i again.
+		s.tableDeleteByIndex(i)
+		deleted++
+	}
+	s.mu.Unlock()
+
+	return
+}
+
+func (s *ttlshard[K, V]) BumpEpoch() {
+	s.mu.Lock()
+	s.epoch++
+	if s.epoch == 0 {
+		// the epoch wraps around, reset the shard so that the entries of old epochs never come back.
+		s.reset()
+	}
+	s.mu.Unlock()
+}
+
+// evict counts the node which is evicted by capacity, and calls the eviction callback and removal listener.
+// An expired node is counted and reported to the removal listener as expired instead, and neither it nor a marker
+// set by SetNegative and Tombstone is passed to the eviction callback. The caller must hold the shard lock.
+func (s *ttlshard[K, V]) evict(node *ttlnode[K, V]) {
+	if node.epoch != s.epoch || (node.expires != 0 && atomic.LoadUint32(&clock) >= node.expires) {
+		atomic.AddUint64(&s.removal.expired, 1)
+	} else if node.mark&ttlmarkMarkers == 0 {
+		atomic.AddUint64(&s.removal.evictions, 1)
+		if s.removal.evicted != nil {
+			s.removal.evicted(node.key, node.value)
+		}
+	}
+	if s.removal.listener != nil {
+		s.notify(node, RemovalEvicted)
+	}
+}
+
+// notify calls the removal listener for the node which is going to be removed, the caller must hold the shard lock.
+// The markers set by SetNegative and Tombstone are skipped, and the removal of an expired node is always reported as expired.
+func (s *ttlshard[K, V]) notify(node *ttlnode[K, V], reason RemovalReason) {
+	if node.mark&ttlmarkMarkers != 0 {
+		return
+	}
+	if node.epoch != s.epoch || (node.expires != 0 && atomic.LoadUint32(&clock) >= node.expires) {
+		reason = RemovalExpired
+	}
+	s.removal.listener(node.key, node.value, reason)
+}
+
+func (s *ttlshard[K, V]) Clear() {
+	s.mu.Lock()
+	s.reset()
+	s.mu.Unlock()
+}
+
+func (s *ttlshard[K, V]) reset() {
+	for i := range s.list {
+		s.list[i] = ttlnode[K, V]{}
+	}
+	for i := range s.tableBuckets {
+		s.tableBuckets[i] = 0
+	}
+	atomic.StoreUint32(&s.tableLength, 0)
+	s.listInit(uint32(len(s.list) - 1))
+}
@@ -6,6 +6,7 @@
 package lru
 
 import (
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -15,9 +16,8 @@ func (s *ttlshard[K, V]) tableInit(size uint32, hasher func(key unsafe.Pointer,
 		s.tableBuckets = make([]uint64, newsize)
 	}
 	s.tableMask = newsize - 1
-	s.tableLength = 0
-	s.tableHasher = hasher
-	s.tableSeed = seed
+	atomic.StoreUint32(&s.tableLength, 0)
+	s.tableHasher = func(key unsafe.Pointer) uintptr { return hasher(key, seed) }
 }
 
 func ttlNewTableSize(size uint32) (newsize uint32) {
@@ -31,6 +31,14 @@ func ttlNewTableSize(size uint32) (newsize uint32) {
 	return
 }
 
+// tableKeyEqual reports whether the keys are equal, by the equality of WithKeyEqual if any.
+func (s *ttlshard[K, V]) tableKeyEqual(a, b K) bool {
+	if s.tableEqual != nil {
+		return s.tableEqual(a, b)
+	}
+	return a == b
+}
+
 // tableSet assigns an index to a key.
 // Returns the previous index, or false when no index was assigned.
 func (s *ttlshard[K, V]) tableSet(hash uint32, key K, index uint32) (prev uint32, ok bool) {
@@ -45,10 +53,10 @@ func (s *ttlshard[K, V]) tableSet(hash uint32, key K, index uint32) (prev uint32
 		if b.hdib&maxDIB == 0 {
 			b.hdib = hdib
 			b.index = index
-			s.tableLength++
+			atomic.AddUint32(&s.tableLength, 1)
 			return
 		}
-		if hdib>>dibBitSize == b.hdib>>dibBitSize && (*ttlnode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key == key {
+		if hdib>>dibBitSize == b.hdib>>dibBitSize && s.tableKeyEqual((*ttlnode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key, key) {
 			prev = b.index
 			b.hdib = hdib
 			b.index = index
@@ -77,7 +85,7 @@ func (s *ttlshard[K, V]) tableGet(hash uint32, key K) (index uint32, ok bool) {
 		if b.hdib&maxDIB == 0 {
 			return
 		}
-		if b.hdib>>dibBitSize == subhash && (*ttlnode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key == key {
+		if b.hdib>>dibBitSize == subhash && s.tableKeyEqual((*ttlnode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key, key) {
 			return b.index, true
 		}
 		i = (i + 1) & mask
@@ -97,7 +105,7 @@ func (s *ttlshard[K, V]) tableDelete(hash uint32, key K) (v uint32, ok bool) {
 		if b.hdib&maxDIB == 0 {
 			return
 		}
-		if b.hdib>>dibBitSize == subhash && (*ttlnode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key == key {
+		if b.hdib>>dibBitSize == subhash && s.tableKeyEqual((*ttlnode[K, V])(unsafe.Add(l0, uintptr(b.index)*unsafe.Sizeof(s.list[0]))).key, key) {
 			old := b.index
 			s.tableDeleteByIndex(i)
 			return old, true
@@ -124,5 +132,5 @@ func (s *ttlshard[K, V]) tableDeleteByIndex(i uint32) {
 		bpi.index = bi.index
 		bpi.hdib = bi.hdib>>dibBitSize<<dibBitSize | (bi.hdib&maxDIB-1)&maxDIB
 	}
-	s.tableLength--
+	atomic.AddUint32(&s.tableLength, ^uint32(0))
 }
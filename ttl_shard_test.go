@@ -1,6 +1,7 @@
 package lru
 
 import (
+	"fmt"
 	"testing"
 	"unsafe"
 )
@@ -8,9 +9,14 @@ import (
 func TestTTLShardPadding(t *testing.T) {
 	var s ttlshard[string, int]
 
-	if n := unsafe.Sizeof(s); n != 128 {
+	// the shards are padded to a cache line on 64-bit platforms.
+	if n := unsafe.Sizeof(s); unsafe.Sizeof(uintptr(0)) == 8 && n != 128 {
 		t.Errorf("shard size is %d, not 128", n)
 	}
+	// the stats counters at the shard head are 64-bit aligned in arrays on 32-bit platforms.
+	if n := unsafe.Sizeof(s); n%8 != 0 {
+		t.Errorf("shard size is %d, not a multiple of 8", n)
+	}
 }
 
 func TestTTLShardListSet(t *testing.T) {
@@ -18,7 +24,7 @@ func TestTTLShardListSet(t *testing.T) {
 	s.Init(1024, getRuntimeHasher[string](), 0)
 
 	key := "foobar"
-	hash := uint32(s.tableHasher(noescape(unsafe.Pointer(&key)), s.tableSeed))
+	hash := uint32(s.tableHasher(noescape(unsafe.Pointer(&key))))
 
 	s.Set(hash, key, 42, 0)
 
@@ -32,7 +38,7 @@ func TestTTLShardTableSet(t *testing.T) {
 	s.Init(1024, getRuntimeHasher[string](), 0)
 
 	key := "foobar"
-	hash := uint32(s.tableHasher(noescape(unsafe.Pointer(&key)), s.tableSeed))
+	hash := uint32(s.tableHasher(noescape(unsafe.Pointer(&key))))
 
 	s.Set(hash, key, 42, 0)
 
@@ -41,3 +47,62 @@ func TestTTLShardTableSet(t *testing.T) {
 		t.Errorf("foobar should be set to 42: %v %v", i, ok)
 	}
 }
+
+// checkTTLShardList checks the invariants of shard list, all nodes are linked in a ring,
+// and the nodes in the table are exactly the first tableLength nodes from the list front.
+func checkTTLShardList[K comparable, V any](t *testing.T, s *ttlshard[K, V]) {
+	t.Helper()
+
+	live := make(map[uint32]bool)
+	for _, bucket := range s.tableBuckets {
+		if b := (*ttlbucket)(unsafe.Pointer(&bucket)); b.index != 0 {
+			live[b.index] = true
+		}
+	}
+	if len(live) != int(s.tableLength) {
+		t.Fatalf("table length %v should be %v", s.tableLength, len(live))
+	}
+
+	index, n := s.list[0].next, 1
+	for ; index != 0; index, n = s.list[index].next, n+1 {
+		if s.list[s.list[index].next].prev != index {
+			t.Fatalf("list node %v is not linked with next", index)
+		}
+		if live[index] != (n <= int(s.tableLength)) {
+			t.Fatalf("list node %v at %v should be in the first %v nodes", index, n, s.tableLength)
+		}
+	}
+	if n != len(s.list) {
+		t.Fatalf("list ring length %v should be %v", n, len(s.list))
+	}
+}
+
+func TestTTLShardEvictionOrder(t *testing.T) {
+	var s ttlshard[string, int]
+	s.Init(4, getRuntimeHasher[string](), 0)
+
+	hash := func(key string) uint32 {
+		return uint32(s.tableHasher(noescape(unsafe.Pointer(&key))))
+	}
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		s.Set(hash(key), key, 1, 0)
+	}
+	checkTTLShardList(t, &s)
+
+	s.Get(hash("a"), "a")
+	s.Delete(hash("c"), "c")
+	checkTTLShardList(t, &s)
+
+	if got, want := fmt.Sprint(s.AppendEvictionOrder(nil, 10)), "[b d a]"; got != want {
+		t.Fatalf("eviction order should be %v: %v", want, got)
+	}
+
+	s.Set(hash("e"), "e", 1, 0)
+	s.Set(hash("f"), "f", 1, 0)
+	checkTTLShardList(t, &s)
+
+	if got, want := fmt.Sprint(s.AppendEvictionOrder(nil, 2)), "[d a]"; got != want {
+		t.Fatalf("eviction order should be %v: %v", want, got)
+	}
+}
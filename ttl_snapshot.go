@@ -0,0 +1,140 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// snapshotMagic is the header of snapshot format version 1, which is followed by records of
+// { uvarint key length, key, uvarint value length, value, uvarint remaining ttl seconds (0 for no ttl) }.
+const snapshotMagic = "LRU\x01"
+
+// maxSnapshotRecordSize is the max size of a marshaled key or value in snapshots.
+const maxSnapshotRecordSize = 1 << 30
+
+// ErrInvalidSnapshot is returned when a snapshot has an unknown header or a truncated record.
+var ErrInvalidSnapshot = errors.New("lru: invalid snapshot")
+
+func (s *ttlshard[K, V]) AppendSnapshot(keys []K, values []V, ttls []uint32, now uint32) ([]K, []V, []uint32) {
+	s.mu.Lock()
+	// walk from the list back to front, so that the least recent entries are restored first.
+	for index := s.list[0].prev; index != 0; index = s.list[index].prev {
+		node := &s.list[index]
+		if node.epoch != s.epoch || (node.expires != 0 && now >= node.expires) || node.mark&ttlmarkMarkers != 0 {
+			continue
+		}
+		if i, exists := s.tableGet(uint32(s.tableHasher(noescape(unsafe.Pointer(&node.key)))), node.key); !exists || i != index {
+			continue
+		}
+		var ttl uint32
+		if node.expires != 0 {
+			ttl = node.expires - now
+		}
+		keys = append(keys, node.key)
+		values = append(values, node.value)
+		ttls = append(ttls, ttl)
+	}
+	s.mu.Unlock()
+
+	return keys, values, ttls
+}
+
+// SaveTo writes a snapshot of the unexpired entries with their remaining ttl to w, keys and values are marshaled by the codecs.
+// The entries of each shard are written from the least recent to the most recent, so the recency order is kept by NewTTLCacheFromReader.
+func (c *TTLCache[K, V]) SaveTo(w io.Writer, keyCodec Codec[K], valueCodec Codec[V]) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+
+	now := atomic.LoadUint32(&clock)
+	var keys []K
+	var values []V
+	var ttls []uint32
+	var buf [binary.MaxVarintLen64]byte
+	for i := uint32(0); i <= c.mask; i++ {
+		keys, values, ttls = c.shards[i].AppendSnapshot(keys[:0], values[:0], ttls[:0], now)
+		for j := range keys {
+			key, err := keyCodec.Marshal(keys[j])
+			if err != nil {
+				return err
+			}
+			value, err := valueCodec.Marshal(values[j])
+			if err != nil {
+				return err
+			}
+			bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(key)))])
+			bw.Write(key)
+			bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(value)))])
+			bw.Write(value)
+			if _, err := bw.Write(buf[:binary.PutUvarint(buf[:], uint64(ttls[j]))]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// NewTTLCacheFromReader creates lru cache with size capacity, and restores the entries from a snapshot written by SaveTo.
+func NewTTLCacheFromReader[K comparable, V any](r io.Reader, size int, keyCodec Codec[K], valueCodec Codec[V], options ...Option[K, V]) (*TTLCache[K, V], error) {
+	br := bufio.NewReader(r)
+
+	var magic [len(snapshotMagic)]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil || string(magic[:]) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+
+	c := NewTTLCache[K, V](size, options...)
+
+	// the codecs may retain the data, so it is not reused.
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxSnapshotRecordSize {
+			return nil, ErrInvalidSnapshot
+		}
+		data := make([]byte, n)
+		_, err = io.ReadFull(br, data)
+		return data, err
+	}
+
+	for {
+		var key K
+		var value V
+
+		data, err := readBytes()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		if err = keyCodec.Unmarshal(data, &key); err != nil {
+			return nil, err
+		}
+		if data, err = readBytes(); err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+		if err = valueCodec.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		ttl, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+
+		c.Set(key, value, time.Duration(ttl)*time.Second)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+package lru
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tagindex is the index of tags to keys for group invalidation, its zero value is ready to use.
+// It is maintained by SetWithTags, the other Set methods and Delete, and the keys removed by eviction or expiration are pruned lazily.
+type tagindex[K comparable] struct {
+	mu     sync.Mutex
+	tagged int32 // the number of tagged keys, read atomically by Set and Delete to skip the lock.
+	tags   map[string]map[K]struct{}
+	keys   map[K][]string
+	limit  int // prune the keys not in cache when the index grows beyond limit
+}
+
+func (x *tagindex[K]) set(key K, tags []string) (prune bool) {
+	x.mu.Lock()
+	if x.tags == nil {
+		x.tags = make(map[string]map[K]struct{})
+		x.keys = make(map[K][]string)
+		x.limit = minIndexLimit
+	}
+	x.unlink(key)
+	if len(tags) > 0 {
+		for _, tag := range tags {
+			keys := x.tags[tag]
+			if keys == nil {
+				keys = make(map[K]struct{})
+				x.tags[tag] = keys
+			}
+			keys[key] = struct{}{}
+		}
+		x.keys[key] = append([]string(nil), tags...)
+	}
+	atomic.StoreInt32(&x.tagged, int32(len(x.keys)))
+	prune = len(x.keys) > x.limit
+	x.mu.Unlock()
+
+	return
+}
+
+// delete removes the tags of key, which is set by other methods than SetWithTags or deleted.
+func (x *tagindex[K]) delete(key K) {
+	if atomic.LoadInt32(&x.tagged) == 0 {
+		return
+	}
+
+	x.mu.Lock()
+	x.unlink(key)
+	atomic.StoreInt32(&x.tagged, int32(len(x.keys)))
+	x.mu.Unlock()
+}
+
+// prune drops the keys not in cache, and doubles the limit of live keys.
+func (x *tagindex[K]) prune(contains func(key K) bool) {
+	x.mu.Lock()
+	for key := range x.keys {
+		if !contains(key) {
+			x.unlink(key)
+		}
+	}
+	atomic.StoreInt32(&x.tagged, int32(len(x.keys)))
+	x.limit = 2 * len(x.keys)
+	if x.limit < minIndexLimit {
+		x.limit = minIndexLimit
+	}
+	x.mu.Unlock()
+}
+
+func (x *tagindex[K]) invalidate(tag string) (keys []K) {
+	x.mu.Lock()
+	for key := range x.tags[tag] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		x.unlink(key)
+	}
+	atomic.StoreInt32(&x.tagged, int32(len(x.keys)))
+	x.mu.Unlock()
+
+	return
+}
+
+func (x *tagindex[K]) clear() {
+	x.mu.Lock()
+	x.tags = nil
+	x.keys = nil
+	atomic.StoreInt32(&x.tagged, 0)
+	x.mu.Unlock()
+}
+
+// unlink removes key from all its tags, the caller must hold the lock.
+func (x *tagindex[K]) unlink(key K) {
+	for _, tag := range x.keys[key] {
+		if keys := x.tags[tag]; keys != nil {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(x.tags, tag)
+			}
+		}
+	}
+	delete(x.keys, key)
+}
+
+// SetWithTags inserts key value pair with tags and returns previous value, the tags replace the previous tags of key.
+// The tags of a key are removed once it is set by other methods or deleted, and the tags of the keys evicted or expired
+// are pruned lazily, so InvalidateTag never deletes a key set again without the tags.
+func (c *TTLCache[K, V]) SetWithTags(key K, value V, ttl time.Duration, tags ...string) (prev V, replaced bool) {
+	prev, replaced = c.Set(key, value, ttl)
+	if c.tags.set(key, tags) {
+		c.tags.prune(c.Contains)
+	}
+	return
+}
+
+// InvalidateTag deletes all keys tagged with tag by SetWithTags, and returns the number of the tagged keys.
+func (c *TTLCache[K, V]) InvalidateTag(tag string) int {
+	keys := c.tags.invalidate(tag)
+	for _, key := range keys {
+		c.Delete(key)
+	}
+	return len(keys)
+}
@@ -0,0 +1,80 @@
+// Copyright 2023-2024 Phus Lu. All rights reserved.
+
+//go:build go1.24
+
+package lru
+
+import (
+	"sync/atomic"
+	"time"
+	"weak"
+)
+
+// WeakCache implements TTL Cache which holds values by weak pointers, so that GC could reclaim
+// huge values under memory pressure. The reclaimed entries are treated as misses.
+type WeakCache[K comparable, V any] struct {
+	reclaimed uint64 // the hits of reclaimed entries counted as misses, keep first for 64-bit atomic alignment
+	cache     *TTLCache[K, weak.Pointer[V]]
+}
+
+// NewWeakCache creates weak cache with size capacity.
+func NewWeakCache[K comparable, V any](size int, options ...Option[K, weak.Pointer[V]]) *WeakCache[K, V] {
+	return &WeakCache[K, V]{
+		cache: NewTTLCache[K, weak.Pointer[V]](size, options...),
+	}
+}
+
+// Get returns value for key, the entry reclaimed by GC is deleted and counted as a miss.
+func (c *WeakCache[K, V]) Get(key K) (value *V, ok bool) {
+	p, ok := c.cache.Get(key)
+	if ok {
+		value = p.Value()
+		if ok = value != nil; !ok {
+			atomic.AddUint64(&c.reclaimed, 1)
+			c.cache.deleteIfValue(key, func(v weak.Pointer[V]) bool { return v == p })
+		}
+	}
+	return
+}
+
+// Peek returns value and expires nanoseconds for key, but does not modify its recency.
+// The entry reclaimed by GC is deleted.
+func (c *WeakCache[K, V]) Peek(key K) (value *V, expires int64, ok bool) {
+	p, expires, ok := c.cache.Peek(key)
+	if ok {
+		value = p.Value()
+		if ok = value != nil; !ok {
+			expires = 0
+			c.cache.deleteIfValue(key, func(v weak.Pointer[V]) bool { return v == p })
+		}
+	}
+	return
+}
+
+// Contains reports whether key is in the cache and its value is not reclaimed by GC, but does not modify its recency.
+func (c *WeakCache[K, V]) Contains(key K) bool {
+	_, _, ok := c.Peek(key)
+	return ok
+}
+
+// Set inserts key value pair, the value will be held weakly.
+func (c *WeakCache[K, V]) Set(key K, value *V, ttl time.Duration) {
+	c.cache.Set(key, weak.Make(value), ttl)
+}
+
+// Delete method deletes value associated with key.
+func (c *WeakCache[K, V]) Delete(key K) {
+	c.cache.Delete(key)
+}
+
+// Len returns number of cached nodes, including the reclaimed ones not read yet.
+func (c *WeakCache[K, V]) Len() int {
+	return c.cache.Len()
+}
+
+// Stats returns cache stats, the hits of reclaimed entries are counted as misses.
+func (c *WeakCache[K, V]) Stats() Stats {
+	stats := c.cache.Stats()
+	stats.Misses += atomic.LoadUint64(&c.reclaimed)
+	return stats
+}
@@ -0,0 +1,47 @@
+//go:build go1.24
+
+package lru
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWeakCacheGetSet(t *testing.T) {
+	cache := NewWeakCache[string, [4096]byte](128)
+
+	a := new([4096]byte)
+	a[0] = 42
+	cache.Set("a", a, time.Hour)
+
+	if v, ok := cache.Get("a"); !ok || v[0] != 42 {
+		t.Fatalf("a should be set to 42: %v", ok)
+	}
+
+	runtime.KeepAlive(a)
+	runtime.GC()
+
+	if v, ok := cache.Get("a"); ok || v != nil {
+		t.Fatalf("a should be reclaimed: %v", ok)
+	}
+
+	if got, want := cache.Len(), 0; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+	if stats := cache.Stats(); stats.GetCalls != 2 || stats.Misses != 1 {
+		t.Fatalf("reclaimed entry should be counted as a miss: %+v", stats)
+	}
+
+	b := new([4096]byte)
+	cache.Set("b", b, time.Hour)
+	runtime.KeepAlive(b)
+	runtime.GC()
+
+	if ok := cache.Contains("b"); ok {
+		t.Fatalf("b should be reclaimed: %v", ok)
+	}
+	if got, want := cache.Len(), 0; got != want {
+		t.Fatalf("curent cache length %v should be %v", got, want)
+	}
+}